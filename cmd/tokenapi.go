@@ -1,18 +1,36 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"flag"
 	"fmt"
 	"net/http"
 	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
 	"time"
 
 	"github.com/go-chi/chi/v5"
 
+	"github.com/google/uuid"
 	"github.com/joho/godotenv"
 	_ "github.com/nabishec/tokenapi/docs"
+	"github.com/nabishec/tokenapi/internal/client/notification"
+	"github.com/nabishec/tokenapi/internal/client/webhook"
 	"github.com/nabishec/tokenapi/internal/lib"
+	"github.com/nabishec/tokenapi/internal/risk"
+	"github.com/nabishec/tokenapi/internal/server/handlers/admin"
 	"github.com/nabishec/tokenapi/internal/server/handlers/auth"
+	"github.com/nabishec/tokenapi/internal/server/handlers/health"
+	"github.com/nabishec/tokenapi/internal/server/handlers/preferences"
+	"github.com/nabishec/tokenapi/internal/server/handlers/sessions"
+	"github.com/nabishec/tokenapi/internal/server/middleware/ratelimit"
+	"github.com/nabishec/tokenapi/internal/server/mtls"
+	"github.com/nabishec/tokenapi/internal/storage"
+	"github.com/nabishec/tokenapi/internal/storage/memory"
 	"github.com/nabishec/tokenapi/internal/storage/postgresql/db"
 	"github.com/rs/zerolog"
 	"github.com/rs/zerolog/log"
@@ -24,11 +42,18 @@ import (
 // @description API Server for Auth
 // @contact.email nabishec@mail.ru
 // @host localhost:8080
+// @securityDefinitions.apikey AdminToken
+// @in header
+// @name Authorization
+// @securityDefinitions.apikey BearerAuth
+// @in header
+// @name Authorization
 func main() {
 	//TODO: init logger
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	debug := flag.Bool("d", false, "set log level to debug")
 	easyReading := flag.Bool("r", false, "set console writer")
+	revokeUser := flag.String("revoke-user", "", "revoke all tokens of the user with this GUID and exit")
 	flag.Parse()
 
 	zerolog.SetGlobalLevel(zerolog.InfoLevel)
@@ -47,50 +72,323 @@ func main() {
 		os.Exit(1)
 	}
 
+	auth.TrustedProxies, err = auth.ParseTrustedProxies(os.Getenv("TRUSTED_PROXIES"))
+	if err != nil {
+		log.Error().AnErr(lib.ErrReader(err)).Msg("Failed to parse trusted proxies")
+		os.Exit(1)
+	}
+	auth.TrustedProxyHeader, err = auth.ParseProxyHeader(os.Getenv("TRUSTED_PROXY_HEADER"))
+	if err != nil {
+		log.Error().AnErr(lib.ErrReader(err)).Msg("Failed to parse trusted proxy header")
+		os.Exit(1)
+	}
+
+	auth.IPBindingMode, err = auth.ParseIPBinding(os.Getenv("IP_BINDING"))
+	if err != nil {
+		log.Error().AnErr(lib.ErrReader(err)).Msg("Failed to parse ip binding")
+		os.Exit(1)
+	}
+	if auth.IPBindingMode == auth.BindASN {
+		asnDB, err := risk.OpenASN(os.Getenv("GEOIP_ASN_DB"))
+		if err != nil {
+			log.Error().AnErr(lib.ErrReader(err)).Msg("IP_BINDING=asn requires the ASN database in GEOIP_ASN_DB")
+			os.Exit(1)
+		}
+		defer asnDB.Close()
+		auth.ASNs = asnDB
+	}
+
+	auth.RefreshFormat, err = auth.ParseRefreshFormat(os.Getenv("REFRESH_TOKEN_FORMAT"))
+	if err != nil {
+		log.Error().AnErr(lib.ErrReader(err)).Msg("Failed to parse refresh token format")
+		os.Exit(1)
+	}
+
+	err = auth.LoadPASETOKeys(os.Getenv("PASETO_PUBLIC_SEED"), os.Getenv("PASETO_LOCAL_KEY"))
+	if err != nil {
+		log.Error().AnErr(lib.ErrReader(err)).Msg("Failed to load PASETO keys")
+		os.Exit(1)
+	}
+	auth.JWEAudiences, err = auth.ParseJWEAudiences(os.Getenv("JWE_AUDIENCES"))
+	if err != nil {
+		log.Error().AnErr(lib.ErrReader(err)).Msg("Failed to load JWE keys of audiences")
+		os.Exit(1)
+	}
+	if format := os.Getenv("ACCESS_TOKEN_FORMAT"); format != "" {
+		_, err = auth.GetTokenFormat(format)
+		if err != nil {
+			log.Error().AnErr(lib.ErrReader(err)).Msg("Invalid access token format")
+			os.Exit(1)
+		}
+		auth.DefaultTokenFormat = format
+	}
+
 	//TODO: init storage postgresql
 	log.Info().Msg("Init storage")
-	storage, err := db.NewDatabase()
+	store, err := newStorage()
 	if err != nil {
 		log.Error().AnErr(lib.ErrReader(err)).Msg("Failed init storage")
 		os.Exit(1)
 	}
 	log.Info().Msg("Storage init successful")
-	//TODO: init middleweare
-	router := chi.NewRouter()
-
-	tokenIssuance := auth.NewTokenIssuance(storage)
-	tokenRefresh := auth.NewRefresh(storage)
 
-	router.Get("/swagger/*", httpSwagger.WrapHandler)
-	router.Post("/tokenapi/v1/auth/token", tokenIssuance.ReturnToken)
-	router.Post("/tokenapi/v1/auth/refresh", tokenRefresh.RefreshToken)
+	events := webhook.NewEmitter(store)
+	if *revokeUser != "" {
+		err = revokeUserTokens(store, events, *revokeUser)
+		if err != nil {
+			log.Error().AnErr(lib.ErrReader(err)).Msg("Failed to revoke tokens of user")
+			os.Exit(1)
+		}
+		return
+	}
+	if database, ok := store.(*db.Database); ok {
+		go database.RunHealthCheck(lib.DurationEnv("DB_HEALTH_INTERVAL", 15*time.Second))
+	}
 
-	//TODO: run server
+	//TODO: init middleweare
 	wrTime, err := time.ParseDuration(os.Getenv("TIMEOUT"))
 	if err != nil {
 		log.Error().Err(err).Msg("timeout not received from env")
 		wrTime = 4 * time.Second
 	}
+
+	// handlers must be done before the write timeout, so that their response, 504 included, still reaches the client
+	reqTime := lib.DurationEnv("REQUEST_TIMEOUT", wrTime*3/4)
+	if reqTime <= 0 || reqTime >= wrTime {
+		log.Error().Msgf("REQUEST_TIMEOUT must be shorter than TIMEOUT, %s is used", wrTime*3/4)
+		reqTime = wrTime * 3 / 4
+	}
+
+	router := chi.NewRouter()
+	// queries of requests the server can't answer in time anymore are canceled
+	router.Use(requestTimeout(reqTime))
+
+	var locator risk.Locator
+	if path := os.Getenv("GEOIP_DB"); path != "" {
+		geoIP, err := risk.OpenGeoIP(path)
+		if err != nil {
+			log.Error().AnErr(lib.ErrReader(err)).Msg("Failed to open GeoIP database")
+			os.Exit(1)
+		}
+		defer geoIP.Close()
+		locator = geoIP
+	}
+	riskEngine := risk.NewEngine(store, locator)
+
+	auth.PublicURL = strings.TrimSuffix(os.Getenv("PUBLIC_URL"), "/")
+	auth.RevokeLinkLifetime = lib.DurationEnv("REVOKE_LINK_LIFETIME", auth.RevokeLinkLifetime)
+	dpop := auth.NewDPoP(store)
+	lockout := auth.NewLockout(store)
+	refreshCookie, err := auth.NewRefreshCookie()
+	if err != nil {
+		log.Error().AnErr(lib.ErrReader(err)).Msg("Invalid refresh cookie configuration")
+		os.Exit(1)
+	}
+	channels, err := notification.NewFromEnv()
+	if err != nil {
+		log.Error().AnErr(lib.ErrReader(err)).Msg("Failed to init notification channels")
+		os.Exit(1)
+	}
+	// handlers only save notifications, the worker delivers them to the channels
+	notifier := notification.NewOutbox(store)
+	outboxWorker := notification.NewWorker(store, channels)
+	webhookDispatcher := webhook.NewDispatcher(store)
+	// workers are stopped after the server on shutdown, so that they deliver what the last requests queued
+	workersCtx, stopWorkers := context.WithCancel(context.Background())
+	var workers sync.WaitGroup
+	workers.Add(2)
+	go func() {
+		defer workers.Done()
+		outboxWorker.Run(workersCtx, lib.DurationEnv("NOTIFY_OUTBOX_INTERVAL", 5*time.Second))
+	}()
+	go func() {
+		defer workers.Done()
+		webhookDispatcher.Run(workersCtx, lib.DurationEnv("WEBHOOK_INTERVAL", 5*time.Second))
+	}()
+	tokenIssuance := auth.NewTokenIssuance(store, lockout, riskEngine, dpop, refreshCookie, notifier, events)
+	tokenRefresh := auth.NewRefresh(store, lockout, riskEngine, dpop, refreshCookie, notifier, events)
+	lockoutAdmin := admin.NewLockoutAdmin(store)
+	userAdmin := admin.NewUserAdmin(store, events)
+	notificationAdmin := admin.NewNotificationAdmin(store)
+	webhookAdmin := admin.NewWebhookAdmin(store)
+	authenticator := auth.NewAuthenticator(store, dpop)
+	userSessions := sessions.NewSessions(store, events, refreshCookie)
+	notificationPreferences := preferences.NewPreferences(store, channels.Push != nil)
+	healthCheck := health.NewHealth(store)
+
+	rateLimitBackend, err := newRateLimitBackend(store)
+	if err != nil {
+		log.Error().AnErr(lib.ErrReader(err)).Msg("Failed to init rate limit backend")
+		os.Exit(1)
+	}
+	limiter := ratelimit.New(rateLimitBackend)
+	go limiter.SweepEvery(time.Minute, lib.DurationEnv("RATE_LIMIT_IDLE", time.Hour))
+
+	router.Get("/swagger/*", httpSwagger.WrapHandler)
+	router.Get("/tokenapi/v1/health", healthCheck.Get)
+	router.With(limiter.Limit("token",
+		ratelimit.RuleFromEnv("RATE_LIMIT_TOKEN_IP", "ip", ratelimit.ByIP),
+		ratelimit.RuleFromEnv("RATE_LIMIT_TOKEN_CLIENT", "client", ratelimit.ByClientID),
+	)).Post("/tokenapi/v1/auth/token", tokenIssuance.ReturnToken)
+	router.With(limiter.Limit("refresh",
+		ratelimit.RuleFromEnv("RATE_LIMIT_REFRESH_IP", "ip", ratelimit.ByIP),
+	)).Post("/tokenapi/v1/auth/refresh", tokenRefresh.RefreshToken)
+	router.With(limiter.Limit("revoke",
+		ratelimit.RuleFromEnv("RATE_LIMIT_REVOKE_IP", "ip", ratelimit.ByIP),
+	)).Route(auth.RevokeLinkPath, func(r chi.Router) {
+		r.Get("/", userSessions.ConfirmRevokeLink)
+		r.Post("/", userSessions.RevokeByLink)
+	})
+	router.Route("/tokenapi/v1/sessions", func(r chi.Router) {
+		r.Use(authenticator.Authenticate)
+		r.Get("/", userSessions.List)
+		r.Delete("/", userSessions.RevokeAll)
+		r.Delete("/others", userSessions.RevokeOthers)
+		r.Delete("/{session_id}", userSessions.Revoke)
+	})
+	router.Route("/tokenapi/v1/notifications/preferences", func(r chi.Router) {
+		r.Use(authenticator.Authenticate)
+		r.Get("/", notificationPreferences.Get)
+		r.Put("/", notificationPreferences.Set)
+		r.Delete("/", notificationPreferences.Reset)
+	})
+	router.Route("/tokenapi/v1/admin", func(r chi.Router) {
+		r.Use(admin.Authorize)
+		r.Post("/unlock", lockoutAdmin.Unlock)
+		r.Post("/users/{user_id}/revoke", userAdmin.RevokeTokens)
+		r.Put("/users/{user_id}/token-format", userAdmin.SetTokenFormat)
+		r.Get("/notifications", notificationAdmin.List)
+		r.Route("/webhooks", func(r chi.Router) {
+			r.Post("/", webhookAdmin.Add)
+			r.Get("/", webhookAdmin.List)
+			r.Put("/{webhook_id}", webhookAdmin.Update)
+			r.Delete("/{webhook_id}", webhookAdmin.Delete)
+			r.Get("/{webhook_id}/deliveries", webhookAdmin.Deliveries)
+		})
+	})
+
+	//TODO: run server
 	idleTime, err := time.ParseDuration(os.Getenv("IDLE_TIMEOUT"))
 	if err != nil {
 		log.Error().Err(err).Msg("idle timeout not received from env")
 		idleTime = 60 * time.Second
 	}
 
+	tlsConfig, err := mtls.ServerTLSConfig()
+	if err != nil {
+		log.Error().AnErr(lib.ErrReader(err)).Msg("Failed to load tls configuration")
+		os.Exit(1)
+	}
+
 	srv := &http.Server{
 		Addr:         os.Getenv("ADDRESS"),
 		Handler:      router,
 		ReadTimeout:  wrTime,
 		WriteTimeout: wrTime,
 		IdleTimeout:  idleTime,
+		TLSConfig:    tlsConfig,
 	}
-	log.Info().Msgf("Starting server on %s", srv.Addr)
-	if err := srv.ListenAndServe(); err != nil {
-		log.Error().Msg("failed to start server")
+	shutdownCtx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	serverErr := make(chan error, 1)
+	go func() {
+		log.Info().Msgf("Starting server on %s", srv.Addr)
+		if tlsConfig != nil {
+			// certificates are already loaded into tls config
+			serverErr <- srv.ListenAndServeTLS("", "")
+		} else {
+			serverErr <- srv.ListenAndServe()
+		}
+	}()
+	select {
+	case err = <-serverErr:
+		log.Error().Err(err).Msg("failed to start server")
 		os.Exit(1)
+	case <-shutdownCtx.Done():
+	}
+
+	log.Info().Msg("Shutting down server")
+	ctx, cancel := context.WithTimeout(context.Background(), wrTime)
+	defer cancel()
+	err = srv.Shutdown(ctx)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		log.Error().Err(err).Msg("Failed to finish requests")
+	}
+	stopWorkers()
+	workers.Wait()
+
+	log.Info().Msg("Program ended")
+}
+
+// requestTimeout puts a deadline shorter than the write timeout of the server into the request
+// context. The server doesn't cancel the context itself, so storage calls would outlive the response.
+func requestTimeout(timeout time.Duration) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx, cancel := context.WithTimeout(r.Context(), timeout)
+			defer cancel()
+			next.ServeHTTP(w, r.WithContext(ctx))
+		})
 	}
+}
 
-	log.Error().Msg("Program ended")
+func revokeUserTokens(store storage.Storage, events *webhook.Emitter, userGUID string) error {
+	const op = "cmd.revokeUserTokens()"
+	userID, err := uuid.Parse(userGUID)
+	if err != nil {
+		return fmt.Errorf("%s:%s", op, "invalid user GUID")
+	}
+	revokedBefore, err := store.RevokeUserTokens(context.Background(), userID, time.Now().Add(auth.AccessTokenLifetime))
+	if err != nil {
+		if err == storage.ErrUserNotExists {
+			return fmt.Errorf("%s:%s", op, "user not found")
+		}
+		return err
+	}
+	log.Info().Msgf("Tokens of user - %s issued before %s revoked", userID, revokedBefore)
+	events.Emit(webhook.TokenRevoked(userID, nil, webhook.RevokedAdmin))
+	return nil
+}
+
+// newStorage creates the storage chosen by STORAGE_BACKEND, postgres by default.
+func newStorage() (storage.Storage, error) {
+	const op = "cmd.newStorage()"
+	// constructors return nil pointers on errors, they mustn't end up in a non-nil interface
+	switch os.Getenv("STORAGE_BACKEND") {
+	case "", "postgres":
+		database, err := db.NewDatabase()
+		if err != nil {
+			return nil, err
+		}
+		return database, nil
+	case "memory":
+		log.Warn().Msg("Memory storage is used, everything is lost on restart")
+		store, err := memory.NewFromEnv()
+		if err != nil {
+			return nil, err
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("%s:%s", op, "unknown STORAGE_BACKEND")
+	}
+}
+
+// newRateLimitBackend creates the rate limit backend chosen by RATE_LIMIT_BACKEND, memory by default.
+func newRateLimitBackend(store storage.Storage) (ratelimit.Backend, error) {
+	const op = "cmd.newRateLimitBackend()"
+	switch os.Getenv("RATE_LIMIT_BACKEND") {
+	case "", "memory":
+		return ratelimit.NewMemory(lib.IntEnv("RATE_LIMIT_MAX_BUCKETS", 100000)), nil
+	case "postgres":
+		// buckets in the memory storage wouldn't be shared between instances as expected
+		if _, ok := store.(*db.Database); !ok {
+			return nil, fmt.Errorf("%s:%s", op, "RATE_LIMIT_BACKEND=postgres requires STORAGE_BACKEND=postgres")
+		}
+		return store, nil
+	default:
+		return nil, fmt.Errorf("%s:%s", op, "unknown RATE_LIMIT_BACKEND")
+	}
 }
 
 func loadEnv() error {
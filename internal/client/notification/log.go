@@ -0,0 +1,52 @@
+package notification
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Log writes notifications to the service log, useful for development.
+type Log struct{}
+
+func (Log) Notify(msg Message) error {
+	log.Info().Str("event", string(msg.Event)).Str("user_id", msg.UserID.String()).
+		Str("ip", msg.IP).Str("user_agent", msg.UserAgent).Int("repeated", msg.Repeated).Msg("User notification")
+	return nil
+}
+
+// File appends notifications to a file as JSON lines.
+type File struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func NewFile(path string) (*File, error) {
+	const op = "internal.client.notification.NewFile()"
+	if path == "" {
+		return nil, fmt.Errorf("%s:%s", op, "file path isn't set")
+	}
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	return &File{file: file}, nil
+}
+
+func (f *File) Notify(msg Message) error {
+	const op = "internal.client.notification.Notify()"
+	line, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	_, err = f.file.Write(append(line, '\n'))
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	return nil
+}
@@ -1,89 +1,229 @@
 package notification
 
 import (
-	"context"
-	"crypto/tls"
+	"errors"
 	"fmt"
-	"net/smtp"
 	"os"
+	"strings"
 	"time"
+
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/models"
 )
 
-var (
-	from        = os.Getenv("FROM_EMAIL_ADRESS")
-	password    = os.Getenv("SMTP_PASSWORD")
-	hostName    = "smtp.mail.ru"
-	addr        = "smtp.mail.ru:465"
-	timeForSend = 2 * time.Second
+// Event is the kind of a notification, channels are chosen per event.
+type Event string
+
+const (
+	// EventSuspiciousLogin is sent when tokens are refreshed from a new IP or device.
+	EventSuspiciousLogin Event = "suspicious_login"
+	// EventRiskyLogin is sent when the risk score of a login requires attention.
+	EventRiskyLogin Event = "risky_login"
+	// EventLockout is sent when an account is locked after failed attempts.
+	EventLockout Event = "lockout"
 )
 
-func SendMessage(userMail string) error {
-	const op = "internal.client.notification.SenMessage()"
-	if from == "" || password == "" {
-		return fmt.Errorf("%s:%s", op, "Server's mail data couldn`t be retrieved")
-	}
-	auth := smtp.PlainAuth("", from, password, hostName)
-	msg := []byte("From: " + from + "\n" +
-		"To: " + userMail + "\n" +
-		"Subject: WARN\n" +
-		"\n" +
-		"Someone tried to log into your account")
-	ctx, cancel := context.WithTimeout(context.Background(), timeForSend)
-	defer cancel()
+var Events = []Event{EventSuspiciousLogin, EventRiskyLogin, EventLockout}
 
-	errCH := make(chan error)
+// Change is what differs from the session in a suspicious login.
+type Change string
 
-	go func() {
-		conf := &tls.Config{ServerName: hostName}
+const (
+	ChangeIP        Change = "ip"
+	ChangeDevice    Change = "device"
+	ChangeUserAgent Change = "user_agent"
+)
 
-		conn, err := tls.Dial("tcp", addr, conf)
-		if err != nil {
-			errCH <- fmt.Errorf("%s:%w", op, err)
-		}
+type Message struct {
+	Event  Event     `json:"event"`
+	UserID uuid.UUID `json:"user_id"`
+	Email  string    `json:"-"`
+	// Locale is the language of the user, like "ru" or "en-US".
+	Locale    string     `json:"-"`
+	IP        string     `json:"ip,omitempty"`
+	Location  string     `json:"location,omitempty"`
+	UserAgent string     `json:"user_agent,omitempty"`
+	DeviceID  string     `json:"device_id,omitempty"`
+	Changes   []Change   `json:"changes,omitempty"`
+	Until     *time.Time `json:"until,omitempty"`
+	Time      time.Time  `json:"time"`
+	// Repeated is the number of similar events since Since that were throttled,
+	// IPs are their addresses. A message with Since set is a digest.
+	Repeated int        `json:"repeated,omitempty"`
+	IPs      []string   `json:"ips,omitempty"`
+	Since    *time.Time `json:"since,omitempty"`
+	// RevokeURL is the "this wasn't me" link, it is only sent to the user.
+	RevokeURL string `json:"-"`
+	// Preferences of the user, nil if the user hasn't chosen channels.
+	Preferences *models.NotificationPreferences `json:"-"`
+}
 
-		cl, err := smtp.NewClient(conn, hostName)
-		if err != nil {
-			errCH <- fmt.Errorf("%s:%w", op, err)
-		}
+type Notifier interface {
+	Notify(msg Message) error
+}
 
-		if err = cl.Auth(auth); err != nil {
-			errCH <- fmt.Errorf("%s:%w", op, err)
-		}
+// SuspiciousLogin warns the user that tokens were refreshed with the changes.
+func SuspiciousLogin(userID uuid.UUID, ip string, userAgent string, deviceID string, changes ...Change) Message {
+	return Message{
+		Event:     EventSuspiciousLogin,
+		UserID:    userID,
+		IP:        ip,
+		UserAgent: userAgent,
+		DeviceID:  deviceID,
+		Changes:   changes,
+		Time:      time.Now(),
+	}
+}
 
-		if err = cl.Mail(from); err != nil {
-			errCH <- fmt.Errorf("%s:%w", op, err)
-		}
+// RiskyLogin warns the user about a login with a high risk score, location may be empty.
+func RiskyLogin(userID uuid.UUID, ip string, location string, userAgent string) Message {
+	return Message{
+		Event:     EventRiskyLogin,
+		UserID:    userID,
+		IP:        ip,
+		Location:  location,
+		UserAgent: userAgent,
+		Time:      time.Now(),
+	}
+}
 
-		if err = cl.Rcpt(userMail); err != nil {
-			errCH <- fmt.Errorf("%s:%w", op, err)
-		}
+// Lockout warns the user that their account is locked until the given time.
+func Lockout(userID uuid.UUID, until time.Time) Message {
+	return Message{
+		Event:  EventLockout,
+		UserID: userID,
+		Until:  &until,
+		Time:   time.Now(),
+	}
+}
+
+// Fanout sends every message to all channels of its event, or to Default
+// if the event has no channels of its own. Preferences of the user decide
+// whether the mail is sent and add the webhook and push channels of the user.
+type Fanout struct {
+	Default []Notifier
+	Events  map[Event][]Notifier
+	// Email is the channel mail is sent through, nil if mail isn't configured.
+	Email Notifier
+	// Push is the push gateway, nil if it isn't configured.
+	Push Notifier
+}
 
-		w, err := cl.Data()
+func (f *Fanout) Notify(msg Message) error {
+	const op = "internal.client.notification.Notify()"
+	channels, ok := f.Events[msg.Event]
+	if !ok {
+		channels = f.Default
+	}
+	if msg.Preferences != nil {
+		channels = f.userChannels(channels, msg)
+	}
+	var errs []error
+	for _, channel := range channels {
+		err := channel.Notify(msg)
 		if err != nil {
-			errCH <- fmt.Errorf("%s:%w", op, err)
+			errs = append(errs, err)
 		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s:%w", op, errors.Join(errs...))
+	}
+	return nil
+}
 
-		if _, err = w.Write(msg); err != nil {
-			errCH <- fmt.Errorf("%s:%w", op, err)
-		}
+// userChannels applies the preferences of the user to the channels of the event.
+// Channels other than mail are kept, they deliver to the operators, not to the user.
+func (f *Fanout) userChannels(channels []Notifier, msg Message) []Notifier {
+	wanted := map[string]bool{}
+	for _, channel := range msg.Preferences.Channels(string(msg.Event)) {
+		wanted[channel] = true
+	}
 
-		if err = w.Close(); err != nil {
-			errCH <- fmt.Errorf("%s:%w", op, err)
+	var result []Notifier
+	for _, channel := range channels {
+		if channel == f.Email && !wanted[models.ChannelEmail] {
+			continue
 		}
+		result = append(result, channel)
+	}
+	if wanted[models.ChannelWebhook] && msg.Preferences.WebhookURL != "" {
+		result = append(result, newUserWebhook(msg.Preferences.WebhookURL, msg.Preferences.WebhookSecret))
+	}
+	if wanted[models.ChannelPush] && f.Push != nil && msg.Preferences.PushRecipient != "" {
+		result = append(result, f.Push)
+	}
+	return result
+}
 
-		if err = cl.Quit(); err != nil {
-			errCH <- fmt.Errorf("%s:%w", op, err)
+// NewFromEnv creates the channels listed in NOTIFY_CHANNELS (smtp, webhook, log, file),
+// NOTIFY_CHANNELS_<EVENT> overrides the list for one event, e.g. NOTIFY_CHANNELS_LOCKOUT.
+// Users can choose the push gateway from NOTIFY_PUSH_URL if it is set.
+func NewFromEnv() (*Fanout, error) {
+	const op = "internal.client.notification.NewFromEnv()"
+	channels := map[string]Notifier{}
+	parse := func(list string) ([]Notifier, error) {
+		var notifiers []Notifier
+		for _, name := range strings.Split(list, ",") {
+			name = strings.ToLower(strings.TrimSpace(name))
+			if name == "" {
+				continue
+			}
+			if channel, ok := channels[name]; ok {
+				notifiers = append(notifiers, channel)
+				continue
+			}
+			channel, err := newChannel(name)
+			if err != nil {
+				return nil, err
+			}
+			channels[name] = channel
+			notifiers = append(notifiers, channel)
 		}
+		return notifiers, nil
+	}
 
-		errCH <- nil
-	}()
-
+	list, ok := os.LookupEnv("NOTIFY_CHANNELS")
+	if !ok {
+		list = "smtp"
+	}
+	fanout := &Fanout{Events: map[Event][]Notifier{}}
 	var err error
-	select {
-	case err = <-errCH:
-		return err
-	case <-ctx.Done():
-		return fmt.Errorf("%s:%s", op, "Time to send the message has expired")
+	fanout.Default, err = parse(list)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	for _, event := range Events {
+		list, ok := os.LookupEnv("NOTIFY_CHANNELS_" + strings.ToUpper(string(event)))
+		if !ok {
+			continue
+		}
+		fanout.Events[event], err = parse(list)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%w", op, err)
+		}
 	}
+	fanout.Email = channels["smtp"]
 
+	if pushURL := os.Getenv("NOTIFY_PUSH_URL"); pushURL != "" {
+		fanout.Push, err = NewPush(pushURL, os.Getenv("NOTIFY_PUSH_TOKEN"))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%w", op, err)
+		}
+	}
+	return fanout, nil
+}
+
+func newChannel(name string) (Notifier, error) {
+	switch name {
+	case "smtp":
+		return NewSMTP()
+	case "webhook":
+		return NewWebhook(os.Getenv("NOTIFY_WEBHOOK_URL"))
+	case "log":
+		return Log{}, nil
+	case "file":
+		return NewFile(os.Getenv("NOTIFY_FILE"))
+	default:
+		return nil, fmt.Errorf("unknown notification channel %q", name)
+	}
 }
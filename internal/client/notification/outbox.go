@@ -0,0 +1,246 @@
+package notification
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nabishec/tokenapi/internal/client/queue"
+	"github.com/nabishec/tokenapi/internal/lib"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// OutboxStorage keeps notifications until they are delivered.
+type OutboxStorage interface {
+	EnqueueNotifications(notifications ...models.Notification) error
+	ClaimNotifications(limit int, lease time.Duration) ([]models.Notification, error)
+	CompleteNotification(id int64) error
+	FailNotification(id int64, nextAttempt time.Time, dead bool, lastError string) error
+	SuppressNotification(id int64) error
+	ThrottleNotification(notification models.Notification, ip string, window time.Duration) (bool, *models.NotificationDigest, error)
+	ClaimDigests(limit int) ([]models.NotificationDigest, error)
+}
+
+// outboxPayload keeps the fields that aren't sent to channels in JSON,
+// they are needed to deliver the message later.
+type outboxPayload struct {
+	Message
+	Email       string                          `json:"email,omitempty"`
+	Locale      string                          `json:"locale,omitempty"`
+	RevokeURL   string                          `json:"revoke_url,omitempty"`
+	Preferences *models.NotificationPreferences `json:"preferences,omitempty"`
+}
+
+// ToOutbox serializes the message to be saved in the outbox.
+func ToOutbox(msg Message) (models.Notification, error) {
+	const op = "internal.client.notification.ToOutbox()"
+	payload, err := json.Marshal(outboxPayload{
+		Message:     msg,
+		Email:       msg.Email,
+		Locale:      msg.Locale,
+		RevokeURL:   msg.RevokeURL,
+		Preferences: msg.Preferences,
+	})
+	if err != nil {
+		return models.Notification{}, fmt.Errorf("%s:%w", op, err)
+	}
+	return models.Notification{
+		Event:   string(msg.Event),
+		UserID:  msg.UserID,
+		Payload: payload,
+	}, nil
+}
+
+// FromOutbox restores the message saved in the outbox.
+func FromOutbox(notification models.Notification) (Message, error) {
+	const op = "internal.client.notification.FromOutbox()"
+	var payload outboxPayload
+	err := json.Unmarshal(notification.Payload, &payload)
+	if err != nil {
+		return Message{}, fmt.Errorf("%s:%w", op, err)
+	}
+	msg := payload.Message
+	msg.Email = payload.Email
+	msg.Locale = payload.Locale
+	msg.RevokeURL = payload.RevokeURL
+	msg.Preferences = payload.Preferences
+	return msg, nil
+}
+
+// Outbox saves messages to be delivered by the Worker instead of sending them.
+type Outbox struct {
+	storage OutboxStorage
+}
+
+func NewOutbox(storage OutboxStorage) *Outbox {
+	return &Outbox{
+		storage: storage,
+	}
+}
+
+func (o *Outbox) Notify(msg Message) error {
+	const op = "internal.client.notification.Notify()"
+	notification, err := ToOutbox(msg)
+	if err != nil {
+		return err
+	}
+	err = o.storage.EnqueueNotifications(notification)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	return nil
+}
+
+// Worker delivers notifications from the outbox. Failed notifications are retried
+// with exponential backoff, after MaxAttempts they are left in the dead state.
+// One notification per user and event is sent within the throttle window of the event,
+// the others are summed up in a digest sent when the window is over.
+type Worker struct {
+	*queue.Worker[models.Notification]
+	storage  OutboxStorage
+	notifier Notifier
+	// Throttle is the window of events without their own window in EventThrottle,
+	// zero disables throttling.
+	Throttle      time.Duration
+	EventThrottle map[Event]time.Duration
+}
+
+// NewWorker creates the worker delivering to notifier with the settings from NOTIFY_OUTBOX_* variables.
+// The throttle window is set by NOTIFY_THROTTLE, NOTIFY_THROTTLE_<EVENT> overrides it for one event.
+func NewWorker(storage OutboxStorage, notifier Notifier) *Worker {
+	eventThrottle := map[Event]time.Duration{}
+	for _, event := range Events {
+		name := "NOTIFY_THROTTLE_" + strings.ToUpper(string(event))
+		if window := lib.DurationEnv(name, -1); window >= 0 {
+			eventThrottle[event] = window
+		}
+	}
+	w := &Worker{
+		storage:       storage,
+		notifier:      notifier,
+		Throttle:      lib.DurationEnv("NOTIFY_THROTTLE", time.Hour),
+		EventThrottle: eventThrottle,
+	}
+	w.Worker = queue.NewWorker[models.Notification]("notifications", outboxJobs{w})
+	w.BatchSize = lib.IntEnv("NOTIFY_OUTBOX_BATCH", 20)
+	w.MaxAttempts = lib.IntEnv("NOTIFY_OUTBOX_MAX_ATTEMPTS", 8)
+	w.BaseDelay = lib.DurationEnv("NOTIFY_OUTBOX_BASE_DELAY", 30*time.Second)
+	w.MaxDelay = lib.DurationEnv("NOTIFY_OUTBOX_MAX_DELAY", time.Hour)
+	w.Lease = lib.DurationEnv("NOTIFY_OUTBOX_LEASE", 5*time.Minute)
+	return w
+}
+
+// outboxJobs is the queue of the outbox delivered by the Worker.
+type outboxJobs struct {
+	w *Worker
+}
+
+// Claim adds digests of the throttle windows that are over before due notifications are claimed.
+func (o outboxJobs) Claim(limit int, lease time.Duration) ([]models.Notification, error) {
+	const op = "internal.client.notification.Claim()"
+	err := o.w.enqueueDigests()
+	if err != nil {
+		log.Error().Str("fn", op).AnErr(lib.ErrReader(err)).Msg("Failed to send digests")
+	}
+	return o.w.storage.ClaimNotifications(limit, lease)
+}
+
+func (o outboxJobs) Do(notification models.Notification) error {
+	const op = "internal.client.notification.Do()"
+	logs := log.With().Str("fn", op).Logger()
+	msg, err := FromOutbox(notification)
+	if err != nil {
+		return err
+	}
+	// retries already passed the throttle
+	if notification.Attempts == 1 && !o.w.throttle(notification, &msg, logs) {
+		err = o.w.storage.SuppressNotification(notification.ID)
+		if err != nil {
+			logs.Error().AnErr(lib.ErrReader(err)).Msgf("Failed to suppress notification - %d", notification.ID)
+		}
+		return queue.ErrSkipped
+	}
+	return o.w.notifier.Notify(msg)
+}
+
+func (o outboxJobs) Complete(notification models.Notification) error {
+	return o.w.storage.CompleteNotification(notification.ID)
+}
+
+func (o outboxJobs) Fail(notification models.Notification, nextAttempt time.Time, dead bool, lastError string) error {
+	return o.w.storage.FailNotification(notification.ID, nextAttempt, dead, lastError)
+}
+
+func (o outboxJobs) Attempts(notification models.Notification) int {
+	return notification.Attempts
+}
+
+func (o outboxJobs) Describe(notification models.Notification) string {
+	return fmt.Sprintf("notification - %d", notification.ID)
+}
+
+// throttle returns false if the message must not be sent yet. A sent message
+// carries the digest of the throttled messages if there are any.
+func (w *Worker) throttle(notification models.Notification, msg *Message, logs zerolog.Logger) bool {
+	window, ok := w.EventThrottle[msg.Event]
+	if !ok {
+		window = w.Throttle
+	}
+	if window <= 0 || msg.Since != nil {
+		return true
+	}
+	send, digest, err := w.storage.ThrottleNotification(notification, msg.IP, window)
+	if err != nil {
+		// it is better to send too many notifications than to lose them
+		logs.Error().AnErr(lib.ErrReader(err)).Msgf("Failed to throttle notification - %d", notification.ID)
+		return true
+	}
+	if !send {
+		logs.Debug().Msgf("Notification - %d throttled", notification.ID)
+		return false
+	}
+	if digest != nil {
+		msg.Repeated = digest.Suppressed
+		msg.IPs = digest.IPs
+		msg.Since = &digest.Since
+	}
+	return true
+}
+
+// enqueueDigests adds digests of the throttle windows that are over to the outbox.
+// The latest throttled message is sent with the count of the others.
+func (w *Worker) enqueueDigests() error {
+	const op = "internal.client.notification.enqueueDigests()"
+	digests, err := w.storage.ClaimDigests(w.BatchSize)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	var notifications []models.Notification
+	for _, digest := range digests {
+		msg, err := FromOutbox(models.Notification{Payload: digest.Payload})
+		if err != nil {
+			log.Error().Str("fn", op).AnErr(lib.ErrReader(err)).Msgf("Failed to read digest of user - %s", digest.UserID)
+			continue
+		}
+		msg.Repeated = digest.Suppressed - 1
+		msg.IPs = digest.IPs
+		msg.Since = &digest.Since
+		notification, err := ToOutbox(msg)
+		if err != nil {
+			log.Error().Str("fn", op).AnErr(lib.ErrReader(err)).Msgf("Failed to prepare digest of user - %s", digest.UserID)
+			continue
+		}
+		notifications = append(notifications, notification)
+	}
+	if len(notifications) == 0 {
+		return nil
+	}
+	err = w.storage.EnqueueNotifications(notifications...)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package notification
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// Push posts notifications to an HTTP gateway, e.g. of a Telegram bot or an SMS provider,
+// for the recipient the user has chosen. The gateway gets the rendered text, not the raw message.
+type Push struct {
+	URL    string
+	Token  string
+	client *http.Client
+}
+
+type pushRequest struct {
+	Recipient string `json:"recipient"`
+	Event     Event  `json:"event"`
+	Subject   string `json:"subject"`
+	Text      string `json:"text"`
+}
+
+func NewPush(endpoint string, token string) (*Push, error) {
+	const op = "internal.client.notification.NewPush()"
+	parsed, err := url.Parse(endpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("%s:invalid push gateway url %q", op, endpoint)
+	}
+	return &Push{
+		URL:    endpoint,
+		Token:  token,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}, nil
+}
+
+func (p *Push) Notify(msg Message) error {
+	const op = "internal.client.notification.Notify()"
+	if msg.Preferences == nil || msg.Preferences.PushRecipient == "" {
+		return fmt.Errorf("%s:%s", op, "user has no push recipient")
+	}
+	subject, text, _, err := msg.Render()
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	body, err := json.Marshal(pushRequest{
+		Recipient: msg.Preferences.PushRecipient,
+		Event:     msg.Event,
+		Subject:   subject,
+		Text:      text,
+	})
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, p.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if p.Token != "" {
+		req.Header.Set("Authorization", "Bearer "+p.Token)
+	}
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s:push gateway responded with %s", op, resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,304 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/nabishec/tokenapi/internal/lib"
+)
+
+// Connection security of SMTP.
+const (
+	// SecurityTLS is implicit TLS, usually on port 465.
+	SecurityTLS = "tls"
+	// SecuritySTARTTLS upgrades a plain connection, usually on port 587.
+	SecuritySTARTTLS = "starttls"
+	// SecurityNone is a plaintext connection, only for local test servers.
+	SecurityNone = "none"
+)
+
+// Authentication mechanisms of SMTP.
+const (
+	AuthPlain   = "plain"
+	AuthLogin   = "login"
+	AuthCRAMMD5 = "cram-md5"
+	AuthNone    = "none"
+)
+
+// SMTP sends notifications by mail.
+type SMTP struct {
+	HostName string
+	Port     int
+	Security string
+	Auth     string
+	Username string
+	Password string
+	From     string
+	FromName string
+	Timeout  time.Duration
+	// rootCAs verify the server certificate, nil for the system roots.
+	rootCAs *x509.CertPool
+}
+
+// NewSMTP reads the SMTP_* settings, the defaults are implicit TLS with PLAIN auth to smtp.mail.ru.
+func NewSMTP() (*SMTP, error) {
+	const op = "internal.client.notification.NewSMTP()"
+	s := &SMTP{
+		HostName: os.Getenv("SMTP_HOST"),
+		Port:     lib.IntEnv("SMTP_PORT", 0),
+		Security: strings.ToLower(os.Getenv("SMTP_SECURITY")),
+		Auth:     strings.ToLower(os.Getenv("SMTP_AUTH")),
+		Username: os.Getenv("SMTP_USERNAME"),
+		Password: os.Getenv("SMTP_PASSWORD"),
+		From:     os.Getenv("FROM_EMAIL_ADRESS"),
+		FromName: os.Getenv("SMTP_FROM_NAME"),
+		Timeout:  lib.DurationEnv("SMTP_TIMEOUT", 2*time.Second),
+	}
+	if s.HostName == "" {
+		s.HostName = "smtp.mail.ru"
+	}
+	switch s.Security {
+	case "":
+		s.Security = SecurityTLS
+	case SecurityTLS, SecuritySTARTTLS, SecurityNone:
+	default:
+		return nil, fmt.Errorf("%s:unknown smtp security %q", op, s.Security)
+	}
+	if s.Port == 0 {
+		s.Port = 465
+		if s.Security != SecurityTLS {
+			s.Port = 587
+		}
+	}
+	switch s.Auth {
+	case "":
+		s.Auth = AuthPlain
+	case AuthPlain, AuthLogin, AuthCRAMMD5, AuthNone:
+	default:
+		return nil, fmt.Errorf("%s:unknown smtp auth %q", op, s.Auth)
+	}
+	if s.Username == "" {
+		s.Username = s.From
+	}
+	return s, nil
+}
+
+func (s *SMTP) Notify(msg Message) error {
+	const op = "internal.client.notification.Notify()"
+	subject, text, html, err := msg.Render()
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	return s.send(msg.Email, subject, text, html)
+}
+
+func (s *SMTP) send(userMail string, subject string, text string, html string) error {
+	const op = "internal.client.notification.send()"
+	if s.From == "" || (s.Auth != AuthNone && s.Password == "") {
+		return fmt.Errorf("%s:%s", op, "Server's mail data couldn`t be retrieved")
+	}
+	if userMail == "" {
+		return fmt.Errorf("%s:%s", op, "user has no mail")
+	}
+	msg, err := s.compose(userMail, subject, text, html)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.Timeout)
+	defer cancel()
+
+	errCH := make(chan error, 1)
+
+	go func() {
+		conn, err := s.dial()
+		if err != nil {
+			errCH <- fmt.Errorf("%s:%w", op, err)
+			return
+		}
+		// the deadline ends the exchange even if nobody waits for it anymore
+		conn.SetDeadline(time.Now().Add(s.Timeout))
+
+		cl, err := smtp.NewClient(conn, s.HostName)
+		if err != nil {
+			conn.Close()
+			errCH <- fmt.Errorf("%s:%w", op, err)
+			return
+		}
+		defer cl.Close()
+
+		if s.Security == SecuritySTARTTLS {
+			if err = cl.StartTLS(s.tlsConfig()); err != nil {
+				errCH <- fmt.Errorf("%s:%w", op, err)
+				return
+			}
+		}
+
+		if auth := s.auth(); auth != nil {
+			if err = cl.Auth(auth); err != nil {
+				errCH <- fmt.Errorf("%s:%w", op, err)
+				return
+			}
+		}
+
+		if err = cl.Mail(s.From); err != nil {
+			errCH <- fmt.Errorf("%s:%w", op, err)
+			return
+		}
+
+		if err = cl.Rcpt(userMail); err != nil {
+			errCH <- fmt.Errorf("%s:%w", op, err)
+			return
+		}
+
+		w, err := cl.Data()
+		if err != nil {
+			errCH <- fmt.Errorf("%s:%w", op, err)
+			return
+		}
+
+		if _, err = w.Write(msg); err != nil {
+			errCH <- fmt.Errorf("%s:%w", op, err)
+			return
+		}
+
+		if err = w.Close(); err != nil {
+			errCH <- fmt.Errorf("%s:%w", op, err)
+			return
+		}
+
+		if err = cl.Quit(); err != nil {
+			errCH <- fmt.Errorf("%s:%w", op, err)
+			return
+		}
+
+		errCH <- nil
+	}()
+
+	select {
+	case err = <-errCH:
+		return err
+	case <-ctx.Done():
+		return fmt.Errorf("%s:%s", op, "Time to send the message has expired")
+	}
+
+}
+
+// compose builds a multipart/alternative mail with quoted-printable text and HTML parts.
+func (s *SMTP) compose(userMail string, subject string, text string, html string) ([]byte, error) {
+	var body bytes.Buffer
+	parts := multipart.NewWriter(&body)
+	for _, part := range []struct{ contentType, content string }{
+		{"text/plain; charset=utf-8", text},
+		{"text/html; charset=utf-8", html},
+	} {
+		header := textproto.MIMEHeader{}
+		header.Set("Content-Type", part.contentType)
+		header.Set("Content-Transfer-Encoding", "quoted-printable")
+		w, err := parts.CreatePart(header)
+		if err != nil {
+			return nil, err
+		}
+		qp := quotedprintable.NewWriter(w)
+		_, err = qp.Write([]byte(strings.ReplaceAll(part.content, "\n", "\r\n")))
+		if err != nil {
+			return nil, err
+		}
+		err = qp.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+	err := parts.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	from := mail.Address{Name: s.FromName, Address: s.From}
+	var msg bytes.Buffer
+	msg.WriteString("From: " + from.String() + "\r\n" +
+		"To: " + userMail + "\r\n" +
+		"Subject: " + mime.QEncoding.Encode("utf-8", subject) + "\r\n" +
+		"Date: " + time.Now().Format(time.RFC1123Z) + "\r\n" +
+		"MIME-Version: 1.0\r\n" +
+		"Content-Type: multipart/alternative; boundary=" + strconv.Quote(parts.Boundary()) + "\r\n" +
+		"\r\n")
+	msg.Write(body.Bytes())
+	return msg.Bytes(), nil
+}
+
+func (s *SMTP) dial() (net.Conn, error) {
+	addr := net.JoinHostPort(s.HostName, strconv.Itoa(s.Port))
+	dialer := &net.Dialer{Timeout: s.Timeout}
+	if s.Security == SecurityTLS {
+		return tls.DialWithDialer(dialer, "tcp", addr, s.tlsConfig())
+	}
+	return dialer.Dial("tcp", addr)
+}
+
+func (s *SMTP) tlsConfig() *tls.Config {
+	return &tls.Config{ServerName: s.HostName, RootCAs: s.rootCAs}
+}
+
+func (s *SMTP) auth() smtp.Auth {
+	switch s.Auth {
+	case AuthPlain:
+		return smtp.PlainAuth("", s.Username, s.Password, s.HostName)
+	case AuthLogin:
+		return &loginAuth{username: s.Username, password: s.Password, host: s.HostName}
+	case AuthCRAMMD5:
+		return smtp.CRAMMD5Auth(s.Username, s.Password)
+	default:
+		return nil
+	}
+}
+
+// loginAuth is the LOGIN mechanism, which net/smtp doesn't have. Like PlainAuth
+// it refuses to send credentials over plaintext connections to remote servers.
+type loginAuth struct {
+	username string
+	password string
+	host     string
+}
+
+func (a *loginAuth) Start(server *smtp.ServerInfo) (string, []byte, error) {
+	if !server.TLS && !isLocalhost(server.Name) {
+		return "", nil, errors.New("unencrypted connection")
+	}
+	if server.Name != a.host {
+		return "", nil, errors.New("wrong host name")
+	}
+	return "LOGIN", nil, nil
+}
+
+func (a *loginAuth) Next(fromServer []byte, more bool) ([]byte, error) {
+	if !more {
+		return nil, nil
+	}
+	switch strings.ToLower(strings.TrimSpace(string(fromServer))) {
+	case "username:":
+		return []byte(a.username), nil
+	case "password:":
+		return []byte(a.password), nil
+	default:
+		return nil, fmt.Errorf("unexpected server challenge %q", fromServer)
+	}
+}
+
+func isLocalhost(name string) bool {
+	return name == "localhost" || name == "127.0.0.1" || name == "::1"
+}
@@ -0,0 +1,243 @@
+package notification
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"math/big"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"net/textproto"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// receivedMail is what the fake SMTP server got from the client.
+type receivedMail struct {
+	tls  bool
+	auth string
+	from string
+	rcpt []string
+	data []byte
+	err  error
+}
+
+// fakeSMTP serves one SMTP session on a local port, offering STARTTLS when cert is set.
+func fakeSMTP(t *testing.T, cert *tls.Certificate) (int, <-chan receivedMail) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	received := make(chan receivedMail, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			received <- receivedMail{err: err}
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		received <- serveSMTP(conn, cert)
+	}()
+	return listener.Addr().(*net.TCPAddr).Port, received
+}
+
+func serveSMTP(conn net.Conn, cert *tls.Certificate) receivedMail {
+	var got receivedMail
+	text := textproto.NewConn(conn)
+	reply := func(lines ...string) {
+		for _, line := range lines {
+			text.PrintfLine("%s", line)
+		}
+	}
+	reply("220 127.0.0.1 ESMTP fake")
+	for {
+		line, err := text.ReadLine()
+		if err != nil {
+			got.err = err
+			return got
+		}
+		verb, arg, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			lines := []string{"250-127.0.0.1"}
+			if cert != nil && !got.tls {
+				lines = append(lines, "250-STARTTLS")
+			}
+			reply(append(lines, "250 AUTH PLAIN")...)
+		case "STARTTLS":
+			reply("220 ready to start TLS")
+			tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{*cert}})
+			err = tlsConn.Handshake()
+			if err != nil {
+				got.err = err
+				return got
+			}
+			got.tls = true
+			text = textproto.NewConn(tlsConn)
+		case "AUTH":
+			mechanism, initial, _ := strings.Cut(arg, " ")
+			credentials, err := base64.StdEncoding.DecodeString(initial)
+			if !strings.EqualFold(mechanism, "PLAIN") || err != nil {
+				reply("504 unsupported")
+				continue
+			}
+			got.auth = string(credentials)
+			reply("235 authenticated")
+		case "MAIL":
+			got.from = strings.TrimSuffix(strings.TrimPrefix(arg, "FROM:<"), ">")
+			reply("250 ok")
+		case "RCPT":
+			got.rcpt = append(got.rcpt, strings.TrimSuffix(strings.TrimPrefix(arg, "TO:<"), ">"))
+			reply("250 ok")
+		case "DATA":
+			reply("354 end with .")
+			got.data, err = text.ReadDotBytes()
+			if err != nil {
+				got.err = err
+				return got
+			}
+			reply("250 queued")
+		case "QUIT":
+			reply("221 bye")
+			return got
+		default:
+			reply("502 unknown command")
+		}
+	}
+}
+
+// localCertificate creates a self-signed certificate for 127.0.0.1 and the pool trusting it.
+func localCertificate(t *testing.T) (*tls.Certificate, *x509.CertPool) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "127.0.0.1"},
+		IPAddresses:           []net.IP{net.ParseIP("127.0.0.1")},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(parsed)
+	return &tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestSMTPSend(t *testing.T) {
+	for _, security := range []string{SecurityNone, SecuritySTARTTLS} {
+		t.Run(security, func(t *testing.T) {
+			var cert *tls.Certificate
+			var pool *x509.CertPool
+			if security == SecuritySTARTTLS {
+				cert, pool = localCertificate(t)
+			}
+			port, received := fakeSMTP(t, cert)
+
+			t.Setenv("SMTP_HOST", "127.0.0.1")
+			t.Setenv("SMTP_PORT", strconv.Itoa(port))
+			t.Setenv("SMTP_SECURITY", security)
+			t.Setenv("SMTP_AUTH", AuthPlain)
+			t.Setenv("SMTP_USERNAME", "sender")
+			t.Setenv("SMTP_PASSWORD", "secret")
+			t.Setenv("SMTP_FROM_NAME", "Token API")
+			t.Setenv("FROM_EMAIL_ADRESS", "noreply@example.com")
+			t.Setenv("SMTP_TIMEOUT", "5s")
+			sender, err := NewSMTP()
+			if err != nil {
+				t.Fatal(err)
+			}
+			sender.rootCAs = pool
+
+			msg := Lockout(uuid.New(), time.Now().Add(time.Hour))
+			msg.Email = "user@example.com"
+			err = sender.Notify(msg)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := <-received
+			if got.err != nil {
+				t.Fatal(got.err)
+			}
+			if got.tls != (security == SecuritySTARTTLS) {
+				t.Fatalf("tls = %v", got.tls)
+			}
+			if got.auth != "\x00sender\x00secret" {
+				t.Fatalf("auth = %q", got.auth)
+			}
+			if got.from != "noreply@example.com" || len(got.rcpt) != 1 || got.rcpt[0] != "user@example.com" {
+				t.Fatalf("envelope from %q to %q", got.from, got.rcpt)
+			}
+			checkMail(t, got.data, msg)
+		})
+	}
+}
+
+// checkMail checks the headers of the mail and that its text part is the rendered message.
+func checkMail(t *testing.T, data []byte, msg Message) {
+	t.Helper()
+	subject, text, _, err := msg.Render()
+	if err != nil {
+		t.Fatal(err)
+	}
+	parsed, err := mail.ReadMessage(strings.NewReader(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	from, err := parsed.Header.AddressList("From")
+	if err != nil || len(from) != 1 || from[0].Address != "noreply@example.com" || from[0].Name != "Token API" {
+		t.Fatalf("From = %q", parsed.Header.Get("From"))
+	}
+	if parsed.Header.Get("To") != "user@example.com" {
+		t.Fatalf("To = %q", parsed.Header.Get("To"))
+	}
+	decoded, err := new(mime.WordDecoder).DecodeHeader(parsed.Header.Get("Subject"))
+	if err != nil || decoded != subject {
+		t.Fatalf("Subject = %q, want %q", decoded, subject)
+	}
+
+	mediaType, params, err := mime.ParseMediaType(parsed.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("Content-Type = %q", parsed.Header.Get("Content-Type"))
+	}
+	parts := multipart.NewReader(parsed.Body, params["boundary"])
+	part, err := parts.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(part)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.ReplaceAll(string(body), "\r\n", "\n"); got != text {
+		t.Fatalf("text part = %q, want %q", got, text)
+	}
+}
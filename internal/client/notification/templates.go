@@ -0,0 +1,155 @@
+package notification
+
+import (
+	"bytes"
+	"embed"
+	htmltemplate "html/template"
+	"strings"
+	texttemplate "text/template"
+	"time"
+)
+
+//go:embed templates
+var templateFiles embed.FS
+
+var (
+	textMail = texttemplate.Must(texttemplate.ParseFS(templateFiles, "templates/mail.txt"))
+	htmlMail = htmltemplate.Must(htmltemplate.ParseFS(templateFiles, "templates/mail.html"))
+)
+
+// locale holds the texts of messages in one language.
+type locale struct {
+	Subjects map[Event]string
+	Intros   map[Event]string
+	Changes  map[Change]string
+	IP       string
+	Location string
+	Device   string
+	DeviceID string
+	Until    string
+	Time     string
+	Repeated string
+	IPs      string
+	Since    string
+	NotMe    string
+	Footer   string
+}
+
+const defaultLocale = "en"
+
+var locales = map[string]locale{
+	"en": {
+		Subjects: map[Event]string{
+			EventSuspiciousLogin: "New sign-in to your account",
+			EventRiskyLogin:      "Unusual sign-in attempt",
+			EventLockout:         "Your account is temporarily locked",
+		},
+		Intros: map[Event]string{
+			EventSuspiciousLogin: "Your session was used in a different way than before:",
+			EventRiskyLogin:      "Someone tried to sign in to your account from an unusual place or device.",
+			EventLockout:         "There were too many failed attempts to sign in to your account, so it is locked for a while.",
+		},
+		Changes: map[Change]string{
+			ChangeIP:        "new IP address",
+			ChangeDevice:    "new device",
+			ChangeUserAgent: "new browser or app",
+		},
+		IP:       "IP address",
+		Location: "Approximate location",
+		Device:   "Browser or app",
+		DeviceID: "Device",
+		Until:    "Locked until",
+		Time:     "Time",
+		Repeated: "Similar events not reported separately",
+		IPs:      "Their IP addresses",
+		Since:    "Since",
+		NotMe:    "This wasn't me - sign out everywhere",
+		Footer:   "If it was you, no action is needed.",
+	},
+	"ru": {
+		Subjects: map[Event]string{
+			EventSuspiciousLogin: "Новый вход в ваш аккаунт",
+			EventRiskyLogin:      "Подозрительная попытка входа",
+			EventLockout:         "Ваш аккаунт временно заблокирован",
+		},
+		Intros: map[Event]string{
+			EventSuspiciousLogin: "Ваша сессия использовалась не так, как раньше:",
+			EventRiskyLogin:      "Кто-то пытался войти в ваш аккаунт из необычного места или с необычного устройства.",
+			EventLockout:         "Было слишком много неудачных попыток входа в ваш аккаунт, поэтому он временно заблокирован.",
+		},
+		Changes: map[Change]string{
+			ChangeIP:        "новый IP адрес",
+			ChangeDevice:    "новое устройство",
+			ChangeUserAgent: "новый браузер или приложение",
+		},
+		IP:       "IP адрес",
+		Location: "Примерное местоположение",
+		Device:   "Браузер или приложение",
+		DeviceID: "Устройство",
+		Until:    "Заблокирован до",
+		Time:     "Время",
+		Repeated: "Похожих событий, о которых не сообщалось отдельно",
+		IPs:      "Их IP адреса",
+		Since:    "Начиная с",
+		NotMe:    "Это был не я - выйти на всех устройствах",
+		Footer:   "Если это были вы, ничего делать не нужно.",
+	},
+}
+
+// resolveLocale returns the supported language of locale like "ru-RU", English by default.
+func resolveLocale(value string) string {
+	lang, _, _ := strings.Cut(strings.ToLower(strings.TrimSpace(value)), "-")
+	lang, _, _ = strings.Cut(lang, "_")
+	if _, ok := locales[lang]; ok {
+		return lang
+	}
+	return defaultLocale
+}
+
+type mailData struct {
+	Lang    string
+	L       locale
+	M       Message
+	Subject string
+	Intro   string
+	Changes []string
+	Time    string
+	Until   string
+	IPs     string
+	Since   string
+}
+
+// Render returns the subject and the plain text and HTML bodies of the message in the locale of the user.
+func (m Message) Render() (subject string, text string, html string, err error) {
+	lang := resolveLocale(m.Locale)
+	l := locales[lang]
+	data := mailData{
+		Lang:    lang,
+		L:       l,
+		M:       m,
+		Subject: l.Subjects[m.Event],
+		Intro:   l.Intros[m.Event],
+		Time:    m.Time.UTC().Format(time.RFC1123),
+	}
+	for _, change := range m.Changes {
+		data.Changes = append(data.Changes, l.Changes[change])
+	}
+	if m.Until != nil {
+		data.Until = m.Until.UTC().Format(time.RFC1123)
+	}
+	if m.Since != nil {
+		data.Since = m.Since.UTC().Format(time.RFC1123)
+	}
+	data.IPs = strings.Join(m.IPs, ", ")
+
+	var textBody, htmlBody bytes.Buffer
+	err = textMail.Execute(&textBody, data)
+	if err != nil {
+		return "", "", "", err
+	}
+	err = htmlMail.Execute(&htmlBody, data)
+	if err != nil {
+		return "", "", "", err
+	}
+	return data.Subject, textBody.String(), htmlBody.String(), nil
+}
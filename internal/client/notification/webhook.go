@@ -0,0 +1,117 @@
+package notification
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"syscall"
+	"time"
+
+	"github.com/nabishec/tokenapi/internal/client/webhook"
+)
+
+// Webhook posts notifications as JSON to an HTTP endpoint. The mail of the user isn't sent.
+type Webhook struct {
+	URL string
+	// Secret signs the deliveries like webhook.Sign, empty for unsigned ones.
+	Secret string
+	client *http.Client
+}
+
+func NewWebhook(endpoint string) (*Webhook, error) {
+	const op = "internal.client.notification.NewWebhook()"
+	parsed, err := url.Parse(endpoint)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return nil, fmt.Errorf("%s:invalid webhook url %q", op, endpoint)
+	}
+	return &Webhook{
+		URL:    endpoint,
+		client: &http.Client{Timeout: 5 * time.Second},
+	}, nil
+}
+
+// userWebhookClient delivers to the webhooks of users. The url is chosen by the user,
+// so only public addresses are dialed, checked on every connection against DNS rebinding.
+var userWebhookClient = &http.Client{
+	Timeout: 5 * time.Second,
+	Transport: &http.Transport{
+		Proxy: nil,
+		DialContext: (&net.Dialer{
+			Timeout: 5 * time.Second,
+			Control: func(network string, address string, _ syscall.RawConn) error {
+				host, _, err := net.SplitHostPort(address)
+				if err != nil {
+					return err
+				}
+				if !PublicIP(net.ParseIP(host)) {
+					return fmt.Errorf("webhook address %s isn't public", host)
+				}
+				return nil
+			},
+		}).DialContext,
+		MaxIdleConns:        100,
+		IdleConnTimeout:     90 * time.Second,
+		TLSHandshakeTimeout: 5 * time.Second,
+	},
+}
+
+// newUserWebhook creates the webhook channel of a user, signed with the secret of the user.
+func newUserWebhook(endpoint string, secret string) *Webhook {
+	return &Webhook{URL: endpoint, Secret: secret, client: userWebhookClient}
+}
+
+// CheckWebhookURL checks that a webhook url chosen by a user is https and that
+// its host resolves only to public addresses.
+func CheckWebhookURL(ctx context.Context, endpoint string) error {
+	const op = "internal.client.notification.CheckWebhookURL()"
+	parsed, err := url.Parse(endpoint)
+	if err != nil || parsed.Scheme != "https" || parsed.Hostname() == "" {
+		return fmt.Errorf("%s:%s", op, "webhook url must be an https url")
+	}
+	addrs, err := net.DefaultResolver.LookupIPAddr(ctx, parsed.Hostname())
+	if err != nil {
+		return fmt.Errorf("%s:%s", op, "webhook host can't be resolved")
+	}
+	for _, addr := range addrs {
+		if !PublicIP(addr.IP) {
+			return fmt.Errorf("%s:%s", op, "webhook host must have a public address")
+		}
+	}
+	return nil
+}
+
+// PublicIP reports whether ip can be reached from the internet: it isn't loopback,
+// private, link-local, multicast or unspecified.
+func PublicIP(ip net.IP) bool {
+	return ip != nil && !ip.IsLoopback() && !ip.IsPrivate() && !ip.IsLinkLocalUnicast() &&
+		!ip.IsLinkLocalMulticast() && !ip.IsInterfaceLocalMulticast() && !ip.IsMulticast() && !ip.IsUnspecified()
+}
+
+func (h *Webhook) Notify(msg Message) error {
+	const op = "internal.client.notification.Notify()"
+	body, err := json.Marshal(msg)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	req, err := http.NewRequest(http.MethodPost, h.URL, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if h.Secret != "" {
+		req.Header.Set(webhook.SignatureHeader, webhook.Sign(h.Secret, time.Now(), body))
+	}
+	resp, err := h.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("%s:webhook responded with %s", op, resp.Status)
+	}
+	return nil
+}
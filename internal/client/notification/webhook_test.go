@@ -0,0 +1,97 @@
+package notification
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/client/webhook"
+)
+
+func TestPublicIP(t *testing.T) {
+	for ip, public := range map[string]bool{
+		"93.184.215.14":    true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"::1":              false,
+		"10.1.2.3":         false,
+		"172.16.0.1":       false,
+		"192.168.1.1":      false,
+		"fd00::1":          false,
+		"169.254.169.254":  false,
+		"fe80::1":          false,
+		"0.0.0.0":          false,
+		"::":               false,
+		"::ffff:127.0.0.1": false,
+	} {
+		if got := PublicIP(net.ParseIP(ip)); got != public {
+			t.Errorf("PublicIP(%s) = %v, want %v", ip, got, public)
+		}
+	}
+}
+
+func TestCheckWebhookURL(t *testing.T) {
+	for endpoint, valid := range map[string]bool{
+		"https://93.184.215.14/hook":          true,
+		"http://93.184.215.14/hook":           false,
+		"https://127.0.0.1/hook":              false,
+		"https://localhost:8443/hook":         false,
+		"https://[::1]/hook":                  false,
+		"https://169.254.169.254/latest/meta": false,
+		"https://10.0.0.1/hook":               false,
+		"https://0.0.0.0/hook":                false,
+		"https:///hook":                       false,
+	} {
+		err := CheckWebhookURL(context.Background(), endpoint)
+		if (err == nil) != valid {
+			t.Errorf("CheckWebhookURL(%s) = %v, want valid %v", endpoint, err, valid)
+		}
+	}
+}
+
+func TestUserWebhookRefusesLocalAddress(t *testing.T) {
+	var requests atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests.Add(1)
+	}))
+	defer server.Close()
+
+	// the url passed the check once, then its host moved to a local address
+	err := newUserWebhook(server.URL, "whsec_test").Notify(Lockout(uuid.New(), time.Now()))
+	if err == nil {
+		t.Fatal("webhook to a loopback address was delivered")
+	}
+	if requests.Load() != 0 {
+		t.Fatalf("server got %d requests", requests.Load())
+	}
+}
+
+func TestUserWebhookIsSigned(t *testing.T) {
+	const secret = "whsec_test"
+	verified := make(chan error, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err == nil {
+			err = webhook.Verify(secret, r.Header.Get(webhook.SignatureHeader), body, time.Minute)
+		}
+		verified <- err
+	}))
+	defer server.Close()
+
+	hook := newUserWebhook(server.URL, secret)
+	hook.client = server.Client()
+	err := hook.Notify(Lockout(uuid.New(), time.Now()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = <-verified
+	if err != nil {
+		t.Fatal(err)
+	}
+}
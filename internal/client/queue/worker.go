@@ -0,0 +1,123 @@
+// Package queue delivers jobs saved in the storage in the background,
+// like notifications of the outbox and webhook deliveries.
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/nabishec/tokenapi/internal/lib"
+	"github.com/rs/zerolog/log"
+)
+
+// ErrSkipped is returned by Handler.Do for a job that is settled without being done,
+// e.g. suppressed, the Worker neither completes nor fails it.
+var ErrSkipped = errors.New("job skipped")
+
+// Handler claims jobs of one queue, does them and records the results.
+type Handler[J any] interface {
+	// Claim leases up to limit due jobs and counts their attempt,
+	// a claimed job isn't claimed again until the lease is over.
+	Claim(limit int, lease time.Duration) ([]J, error)
+	Do(job J) error
+	Complete(job J) error
+	Fail(job J, nextAttempt time.Time, dead bool, lastError string) error
+	// Attempts returns the attempts of the job, the current one included.
+	Attempts(job J) int
+	// Describe names the job in logs, e.g. "notification - 1".
+	Describe(job J) string
+}
+
+// Worker does jobs of the handler in batches. Failed jobs are retried
+// with exponential backoff, after MaxAttempts they are left in the dead state.
+type Worker[J any] struct {
+	name        string
+	handler     Handler[J]
+	BatchSize   int
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Lease is how long a claimed job isn't retried,
+	// it must be longer than the delivery of a batch.
+	Lease time.Duration
+}
+
+// NewWorker creates the worker of the handler, name is the plural of jobs used in logs.
+func NewWorker[J any](name string, handler Handler[J]) *Worker[J] {
+	return &Worker[J]{
+		name:    name,
+		handler: handler,
+	}
+}
+
+// Run delivers due jobs every interval until ctx is canceled. A batch being delivered
+// is finished first, so that its jobs don't wait for the lease to be retried.
+// It is meant to be run in its own goroutine.
+func (w *Worker[J]) Run(ctx context.Context, interval time.Duration) {
+	const op = "internal.client.queue.Run()"
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			log.Info().Str("fn", op).Msgf("Delivery of %s stopped", w.name)
+			return
+		case <-ticker.C:
+		}
+		err := w.Deliver()
+		if err != nil {
+			log.Error().Str("fn", op).AnErr(lib.ErrReader(err)).Msgf("Failed to deliver %s", w.name)
+		}
+	}
+}
+
+// Deliver does one batch of due jobs.
+func (w *Worker[J]) Deliver() error {
+	const op = "internal.client.queue.Deliver()"
+	logs := log.With().Str("fn", op).Logger()
+	jobs, err := w.handler.Claim(w.BatchSize, w.Lease)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	for _, job := range jobs {
+		err := w.handler.Do(job)
+		if errors.Is(err, ErrSkipped) {
+			continue
+		}
+		if err == nil {
+			err = w.handler.Complete(job)
+			if err != nil {
+				logs.Error().AnErr(lib.ErrReader(err)).Msgf("Failed to complete %s", w.handler.Describe(job))
+			}
+			continue
+		}
+
+		// the attempt is already counted when the job is claimed
+		attempts := w.handler.Attempts(job)
+		dead := attempts >= w.MaxAttempts
+		if dead {
+			logs.Error().Err(err).Msgf("%s is dead after %d attempts", w.handler.Describe(job), attempts)
+		} else {
+			logs.Warn().Err(err).Msgf("Failed to deliver %s", w.handler.Describe(job))
+		}
+		err = w.handler.Fail(job, time.Now().Add(w.backoff(attempts)), dead, err.Error())
+		if err != nil {
+			logs.Error().AnErr(lib.ErrReader(err)).Msgf("Failed to reschedule %s", w.handler.Describe(job))
+		}
+	}
+	return nil
+}
+
+// backoff doubles the delay after every attempt up to MaxDelay.
+func (w *Worker[J]) backoff(attempts int) time.Duration {
+	delay := w.BaseDelay
+	for i := 1; i < attempts && delay < w.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > w.MaxDelay {
+		delay = w.MaxDelay
+	}
+	return delay
+}
@@ -0,0 +1,110 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type testJob struct {
+	id       int
+	attempts int
+	err      error
+}
+
+type testResult struct {
+	completed bool
+	failed    bool
+	dead      bool
+	delay     time.Duration
+}
+
+type testHandler struct {
+	jobs    []testJob
+	results map[int]testResult
+}
+
+func (h *testHandler) Claim(limit int, lease time.Duration) ([]testJob, error) {
+	jobs := h.jobs
+	h.jobs = nil
+	return jobs, nil
+}
+
+func (h *testHandler) Do(job testJob) error {
+	return job.err
+}
+
+func (h *testHandler) Complete(job testJob) error {
+	h.results[job.id] = testResult{completed: true}
+	return nil
+}
+
+func (h *testHandler) Fail(job testJob, nextAttempt time.Time, dead bool, lastError string) error {
+	h.results[job.id] = testResult{failed: true, dead: dead, delay: time.Until(nextAttempt).Round(time.Minute)}
+	return nil
+}
+
+func (h *testHandler) Attempts(job testJob) int {
+	return job.attempts
+}
+
+func (h *testHandler) Describe(job testJob) string {
+	return fmt.Sprintf("job - %d", job.id)
+}
+
+func TestDeliver(t *testing.T) {
+	failed := errors.New("failed")
+	handler := &testHandler{
+		jobs: []testJob{
+			{id: 1, attempts: 1},
+			{id: 2, attempts: 1, err: failed},
+			{id: 3, attempts: 3, err: failed},
+			{id: 4, attempts: 10, err: failed},
+			{id: 5, attempts: 5, err: failed},
+			{id: 6, attempts: 1, err: ErrSkipped},
+		},
+		results: map[int]testResult{},
+	}
+	worker := NewWorker[testJob]("jobs", handler)
+	worker.MaxAttempts = 5
+	worker.BaseDelay = time.Minute
+	worker.MaxDelay = 10 * time.Minute
+
+	err := worker.Deliver()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[int]testResult{
+		1: {completed: true},
+		2: {failed: true, delay: time.Minute},
+		3: {failed: true, delay: 4 * time.Minute},
+		4: {failed: true, dead: true, delay: 10 * time.Minute},
+		5: {failed: true, dead: true, delay: 10 * time.Minute},
+	}
+	for id, result := range want {
+		if handler.results[id] != result {
+			t.Errorf("job %d: %+v, want %+v", id, handler.results[id], result)
+		}
+	}
+	if _, ok := handler.results[6]; ok {
+		t.Error("skipped job was settled")
+	}
+}
+
+func TestRunStops(t *testing.T) {
+	worker := NewWorker[testJob]("jobs", &testHandler{results: map[int]testResult{}})
+	ctx, cancel := context.WithCancel(context.Background())
+	stopped := make(chan struct{})
+	go func() {
+		worker.Run(ctx, time.Millisecond)
+		close(stopped)
+	}()
+	cancel()
+	select {
+	case <-stopped:
+	case <-time.After(time.Second):
+		t.Fatal("Run didn't return after ctx was canceled")
+	}
+}
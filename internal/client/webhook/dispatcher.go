@@ -0,0 +1,108 @@
+package webhook
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/nabishec/tokenapi/internal/client/queue"
+	"github.com/nabishec/tokenapi/internal/lib"
+	"github.com/nabishec/tokenapi/internal/models"
+)
+
+// DeliveryStorage keeps deliveries until they are delivered and logs their results.
+type DeliveryStorage interface {
+	ClaimWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	CompleteWebhookDelivery(id int64, responseStatus int) error
+	FailWebhookDelivery(id int64, responseStatus int, nextAttempt time.Time, dead bool, lastError string) error
+}
+
+// Dispatcher posts queued deliveries to webhooks. Failed deliveries are retried
+// with exponential backoff, after MaxAttempts they are left in the dead state.
+type Dispatcher struct {
+	*queue.Worker[*models.WebhookDelivery]
+	storage DeliveryStorage
+	client  *http.Client
+}
+
+// NewDispatcher creates the dispatcher with the settings from WEBHOOK_* variables.
+func NewDispatcher(storage DeliveryStorage) *Dispatcher {
+	d := &Dispatcher{
+		storage: storage,
+		client:  &http.Client{Timeout: lib.DurationEnv("WEBHOOK_TIMEOUT", 5*time.Second)},
+	}
+	d.Worker = queue.NewWorker[*models.WebhookDelivery]("webhooks", deliveryJobs{d})
+	d.BatchSize = lib.IntEnv("WEBHOOK_BATCH", 20)
+	d.MaxAttempts = lib.IntEnv("WEBHOOK_MAX_ATTEMPTS", 10)
+	d.BaseDelay = lib.DurationEnv("WEBHOOK_BASE_DELAY", 30*time.Second)
+	d.MaxDelay = lib.DurationEnv("WEBHOOK_MAX_DELAY", 6*time.Hour)
+	d.Lease = lib.DurationEnv("WEBHOOK_LEASE", 5*time.Minute)
+	return d
+}
+
+// deliveryJobs is the queue of deliveries posted by the Dispatcher. Deliveries are
+// passed by pointer to keep the response status of the attempt for its log.
+type deliveryJobs struct {
+	d *Dispatcher
+}
+
+func (j deliveryJobs) Claim(limit int, lease time.Duration) ([]*models.WebhookDelivery, error) {
+	deliveries, err := j.d.storage.ClaimWebhookDeliveries(limit, lease)
+	if err != nil {
+		return nil, err
+	}
+	jobs := make([]*models.WebhookDelivery, len(deliveries))
+	for i := range deliveries {
+		jobs[i] = &deliveries[i]
+	}
+	return jobs, nil
+}
+
+func (j deliveryJobs) Do(delivery *models.WebhookDelivery) error {
+	status, err := j.d.post(*delivery)
+	delivery.ResponseStatus = status
+	return err
+}
+
+func (j deliveryJobs) Complete(delivery *models.WebhookDelivery) error {
+	return j.d.storage.CompleteWebhookDelivery(delivery.ID, delivery.ResponseStatus)
+}
+
+func (j deliveryJobs) Fail(delivery *models.WebhookDelivery, nextAttempt time.Time, dead bool, lastError string) error {
+	return j.d.storage.FailWebhookDelivery(delivery.ID, delivery.ResponseStatus, nextAttempt, dead, lastError)
+}
+
+func (j deliveryJobs) Attempts(delivery *models.WebhookDelivery) int {
+	return delivery.Attempts
+}
+
+func (j deliveryJobs) Describe(delivery *models.WebhookDelivery) string {
+	return fmt.Sprintf("delivery - %d to webhook - %s", delivery.ID, delivery.WebhookID)
+}
+
+// post sends the signed delivery and returns the response status, 0 if there is no response.
+func (d *Dispatcher) post(delivery models.WebhookDelivery) (int, error) {
+	req, err := http.NewRequest(http.MethodPost, delivery.URL, bytes.NewReader(delivery.Payload))
+	if err != nil {
+		return 0, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, delivery.Event)
+	req.Header.Set(DeliveryHeader, strconv.FormatInt(delivery.ID, 10))
+	// signed at every attempt, so that retries aren't rejected as replays
+	req.Header.Set(SignatureHeader, Sign(delivery.Secret, time.Now(), delivery.Payload))
+
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64<<10))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return resp.StatusCode, fmt.Errorf("webhook responded with %s", resp.Status)
+	}
+	return resp.StatusCode, nil
+}
@@ -0,0 +1,143 @@
+package webhook
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/lib"
+	"github.com/rs/zerolog/log"
+)
+
+// Event is the kind of a security event, webhooks subscribe to events.
+type Event string
+
+const (
+	EventTokenIssued    Event = "token.issued"
+	EventTokenRefreshed Event = "token.refreshed"
+	EventTokenRevoked   Event = "token.revoked"
+	// EventIPMismatch is sent when a refresh comes from another IP than the session,
+	// Blocked tells whether the refresh was rejected.
+	EventIPMismatch Event = "ip.mismatch"
+	// EventTokenReuse is sent when a refresh token that is already used or revoked is presented.
+	EventTokenReuse Event = "token.reuse_detected"
+)
+
+var Events = []Event{EventTokenIssued, EventTokenRefreshed, EventTokenRevoked, EventIPMismatch, EventTokenReuse}
+
+// KnownEvent reports whether webhooks can subscribe to the event.
+func KnownEvent(event string) bool {
+	for _, known := range Events {
+		if string(known) == event {
+			return true
+		}
+	}
+	return false
+}
+
+// Reasons of revocations.
+const (
+	RevokedSession       = "session"
+	RevokedOtherSessions = "other_sessions"
+	RevokedLogout        = "logout"
+	RevokedLink          = "revoke_link"
+	RevokedAdmin         = "admin"
+)
+
+// SecurityEvent is the payload of webhook deliveries.
+type SecurityEvent struct {
+	// ID is the same for all deliveries and retries of the event.
+	ID        uuid.UUID  `json:"id"`
+	Event     Event      `json:"event"`
+	Time      time.Time  `json:"time"`
+	UserID    uuid.UUID  `json:"user_id"`
+	SessionID *uuid.UUID `json:"session_id,omitempty"`
+	IP        string     `json:"ip,omitempty"`
+	// BoundIP is the IP of the session in ip.mismatch events.
+	BoundIP   string `json:"bound_ip,omitempty"`
+	Blocked   bool   `json:"blocked,omitempty"`
+	UserAgent string `json:"user_agent,omitempty"`
+	Reason    string `json:"reason,omitempty"`
+}
+
+func newEvent(event Event, userID uuid.UUID) SecurityEvent {
+	return SecurityEvent{
+		ID:     uuid.New(),
+		Event:  event,
+		Time:   time.Now(),
+		UserID: userID,
+	}
+}
+
+func TokenIssued(userID uuid.UUID, sessionID uuid.UUID, ip string, userAgent string) SecurityEvent {
+	e := newEvent(EventTokenIssued, userID)
+	e.SessionID = &sessionID
+	e.IP = ip
+	e.UserAgent = userAgent
+	return e
+}
+
+func TokenRefreshed(userID uuid.UUID, sessionID uuid.UUID, ip string, userAgent string) SecurityEvent {
+	e := newEvent(EventTokenRefreshed, userID)
+	e.SessionID = &sessionID
+	e.IP = ip
+	e.UserAgent = userAgent
+	return e
+}
+
+// TokenRevoked reports revoked tokens of the user, sessionID is nil when all or several sessions are revoked.
+func TokenRevoked(userID uuid.UUID, sessionID *uuid.UUID, reason string) SecurityEvent {
+	e := newEvent(EventTokenRevoked, userID)
+	e.SessionID = sessionID
+	e.Reason = reason
+	return e
+}
+
+func IPMismatch(userID uuid.UUID, sessionID uuid.UUID, boundIP string, ip string, blocked bool) SecurityEvent {
+	e := newEvent(EventIPMismatch, userID)
+	e.SessionID = &sessionID
+	e.BoundIP = boundIP
+	e.IP = ip
+	e.Blocked = blocked
+	return e
+}
+
+func TokenReuse(userID uuid.UUID, ip string, userAgent string) SecurityEvent {
+	e := newEvent(EventTokenReuse, userID)
+	e.IP = ip
+	e.UserAgent = userAgent
+	return e
+}
+
+// Queue keeps deliveries of events to webhooks.
+type Queue interface {
+	EnqueueWebhookEvent(event string, payload []byte) (int, error)
+}
+
+// Emitter queues security events for the subscribed webhooks.
+type Emitter struct {
+	queue Queue
+}
+
+func NewEmitter(queue Queue) *Emitter {
+	return &Emitter{
+		queue: queue,
+	}
+}
+
+// Emit queues the event, failures are only logged so that webhooks never break the api.
+func (e *Emitter) Emit(event SecurityEvent) {
+	const op = "internal.client.webhook.Emit()"
+	logs := log.With().Str("fn", op).Logger()
+	payload, err := json.Marshal(event)
+	if err != nil {
+		logs.Error().Err(err).Msgf("Failed to encode %s event", event.Event)
+		return
+	}
+	deliveries, err := e.queue.EnqueueWebhookEvent(string(event.Event), payload)
+	if err != nil {
+		logs.Error().AnErr(lib.ErrReader(err)).Msgf("Failed to queue %s event of user - %s", event.Event, event.UserID)
+		return
+	}
+	logs.Debug().Msgf("%s event queued for %d webhooks", event.Event, deliveries)
+}
@@ -0,0 +1,76 @@
+package webhook
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// SignatureHeader is "t=<unix time>,v1=<hex HMAC-SHA256 of "<unix time>.<body>">".
+	SignatureHeader = "Webhook-Signature"
+	// EventHeader is the event of the delivery.
+	EventHeader = "Webhook-Event"
+	// DeliveryHeader is the id of the delivery, it is the same for its retries.
+	DeliveryHeader = "Webhook-Delivery"
+)
+
+var (
+	ErrInvalidSignature = errors.New("invalid webhook signature")
+	ErrSignatureExpired = errors.New("webhook signature is too old")
+)
+
+// Sign returns the value of the signature header of body sent at timestamp.
+func Sign(secret string, timestamp time.Time, body []byte) string {
+	t := strconv.FormatInt(timestamp.Unix(), 10)
+	return "t=" + t + ",v1=" + hex.EncodeToString(signature(secret, t, body))
+}
+
+func signature(secret string, timestamp string, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// Verify checks the signature header of a delivery for receivers. Deliveries signed more than
+// tolerance ago are rejected, so that a captured request can't be replayed later.
+func Verify(secret string, header string, body []byte, tolerance time.Duration) error {
+	const op = "internal.client.webhook.Verify()"
+	var timestamp string
+	var signatures [][]byte
+	for _, part := range strings.Split(header, ",") {
+		key, value, _ := strings.Cut(strings.TrimSpace(part), "=")
+		switch key {
+		case "t":
+			timestamp = value
+		case "v1":
+			sig, err := hex.DecodeString(value)
+			if err == nil {
+				signatures = append(signatures, sig)
+			}
+		}
+	}
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || len(signatures) == 0 {
+		return fmt.Errorf("%s:%w", op, ErrInvalidSignature)
+	}
+
+	expected := signature(secret, timestamp, body)
+	for _, sig := range signatures {
+		if hmac.Equal(sig, expected) {
+			age := time.Since(time.Unix(unix, 0))
+			if age > tolerance || age < -tolerance {
+				return fmt.Errorf("%s:%w", op, ErrSignatureExpired)
+			}
+			return nil
+		}
+	}
+	return fmt.Errorf("%s:%w", op, ErrInvalidSignature)
+}
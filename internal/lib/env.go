@@ -0,0 +1,37 @@
+package lib
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// DurationEnv reads a duration from the env variable name, falling back to def.
+func DurationEnv(name string, def time.Duration) time.Duration {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	d, err := time.ParseDuration(value)
+	if err != nil {
+		log.Warn().Err(err).Msgf("%s has invalid value, using %s", name, def)
+		return def
+	}
+	return d
+}
+
+// IntEnv reads an integer from the env variable name, falling back to def.
+func IntEnv(name string, def int) int {
+	value := os.Getenv(name)
+	if value == "" {
+		return def
+	}
+	n, err := strconv.Atoi(value)
+	if err != nil {
+		log.Warn().Err(err).Msgf("%s has invalid value, using %d", name, def)
+		return def
+	}
+	return n
+}
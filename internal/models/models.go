@@ -1,8 +1,16 @@
 package models
 
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+)
+
 type Tokens struct {
 	AccessToken  string `json:"access_token" validate:"required"`
-	RefreshToken string `json:"refresh_token" validate:"required"`
+	RefreshToken string `json:"refresh_token,omitempty" validate:"required"`
+	TokenType    string `json:"token_type,omitempty"`
 }
 type Response struct {
 	Status string `json:"status"`
@@ -15,3 +23,178 @@ func StatusError(msg string) Response {
 		Error:  msg,
 	}
 }
+
+type TokenFormat struct {
+	TokenFormat string `json:"token_format"`
+}
+
+type Unlock struct {
+	ClientID string `json:"client_id" validate:"required_without=IP,omitempty,uuid"`
+	IP       string `json:"ip" validate:"required_without=ClientID,omitempty,ip"`
+}
+
+func StatusOK() Response {
+	return Response{
+		Status: "OK",
+	}
+}
+
+type Login struct {
+	UserID    uuid.UUID `db:"user_id"`
+	IP        string    `db:"ip"`
+	UserAgent string    `db:"user_agent"`
+	Latitude  *float64  `db:"latitude"`
+	Longitude *float64  `db:"longitude"`
+	Country   string    `db:"country"`
+	City      string    `db:"city"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+type RefreshToken struct {
+	Hash      string
+	UserID    uuid.UUID
+	IP        string
+	JTI       string
+	Exp       time.Time
+	UserAgent string
+	DeviceID  string
+	// JKT is the thumbprint of the DPoP key the token is bound to.
+	JKT string
+	// X5T is the thumbprint of the client certificate the token is bound to.
+	X5T string
+	// SessionID stays the same for all tokens refreshed from one login.
+	SessionID uuid.UUID
+	CreatedAt time.Time
+	LastUsed  time.Time
+	// Selector finds the token, it is the selector of opaque tokens and the jti of JWT refresh tokens.
+	Selector string
+	// Audience is the audience of the access token issued with the token.
+	Audience string
+}
+
+type Session struct {
+	ID        uuid.UUID `json:"id" db:"session_id"`
+	IP        string    `json:"ip" db:"ip"`
+	UserAgent string    `json:"user_agent" db:"user_agent"`
+	DeviceID  string    `json:"device_id,omitempty" db:"device_id"`
+	CreatedAt time.Time `json:"created_at" db:"created_at"`
+	LastUsed  time.Time `json:"last_used" db:"last_used"`
+	Current   bool      `json:"current" db:"-"`
+	JTI       string    `json:"-" db:"jti"`
+}
+
+type Sessions struct {
+	Sessions []Session `json:"sessions"`
+}
+
+// Statuses of notifications in the outbox.
+const (
+	NotificationPending   = "pending"
+	NotificationDelivered = "delivered"
+	NotificationDead      = "dead"
+	// NotificationThrottled notifications aren't sent, they are summed up in a digest.
+	NotificationThrottled = "throttled"
+)
+
+type Notification struct {
+	ID     int64     `json:"id" db:"notification_id"`
+	Event  string    `json:"event" db:"event"`
+	UserID uuid.UUID `json:"user_id" db:"user_id"`
+	// Payload is the serialized message, it holds the mail of the user and isn't listed.
+	Payload     []byte     `json:"-" db:"payload"`
+	Status      string     `json:"status" db:"status"`
+	Attempts    int        `json:"attempts" db:"attempts"`
+	NextAttempt time.Time  `json:"next_attempt" db:"next_attempt"`
+	LastError   string     `json:"last_error,omitempty" db:"last_error"`
+	CreatedAt   time.Time  `json:"created_at" db:"created_at"`
+	DeliveredAt *time.Time `json:"delivered_at,omitempty" db:"delivered_at"`
+}
+
+type Notifications struct {
+	Notifications []Notification `json:"notifications"`
+}
+
+// NotificationDigest sums up the notifications of the user about the event that were throttled.
+type NotificationDigest struct {
+	UserID     uuid.UUID
+	Event      string
+	Suppressed int
+	IPs        []string
+	Since      time.Time
+	// Payload is the latest of the throttled notifications.
+	Payload []byte
+}
+
+// Webhook is a subscription of an external service to security events.
+type Webhook struct {
+	ID  uuid.UUID `json:"id"`
+	URL string    `json:"url"`
+	// Secret signs the deliveries, it is only returned when the webhook is created or changed.
+	Secret string `json:"secret,omitempty"`
+	// Events the webhook is subscribed to, all events if empty.
+	Events    []string  `json:"events"`
+	Active    bool      `json:"active"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+type Webhooks struct {
+	Webhooks []Webhook `json:"webhooks"`
+}
+
+type WebhookRequest struct {
+	URL    string   `json:"url" validate:"required,url"`
+	Events []string `json:"events"`
+	// Secret is generated if empty, on update an empty secret keeps the current one.
+	Secret string `json:"secret,omitempty" validate:"omitempty,min=16"`
+	Active *bool  `json:"active,omitempty"`
+}
+
+type WebhookDelivery struct {
+	ID             int64           `json:"id" db:"delivery_id"`
+	WebhookID      uuid.UUID       `json:"webhook_id" db:"webhook_id"`
+	Event          string          `json:"event" db:"event"`
+	Payload        json.RawMessage `json:"payload" db:"payload" swaggertype:"object"`
+	Status         string          `json:"status" db:"status"`
+	Attempts       int             `json:"attempts" db:"attempts"`
+	NextAttempt    time.Time       `json:"next_attempt" db:"next_attempt"`
+	ResponseStatus int             `json:"response_status,omitempty" db:"response_status"`
+	LastError      string          `json:"last_error,omitempty" db:"last_error"`
+	CreatedAt      time.Time       `json:"created_at" db:"created_at"`
+	DeliveredAt    *time.Time      `json:"delivered_at,omitempty" db:"delivered_at"`
+	// URL and Secret of the webhook are filled for deliveries being sent.
+	URL    string `json:"-" db:"url"`
+	Secret string `json:"-" db:"secret"`
+}
+
+type WebhookDeliveries struct {
+	Deliveries []WebhookDelivery `json:"deliveries"`
+}
+
+// Channels users can choose for their notifications.
+const (
+	ChannelEmail   = "email"
+	ChannelWebhook = "webhook"
+	ChannelPush    = "push"
+)
+
+// NotificationPreferences are the events a user is notified about and the channels for them.
+type NotificationPreferences struct {
+	// Events maps an event to its channels: email, webhook or push. Events that
+	// aren't listed are sent by email, an empty list turns the event off.
+	Events map[string][]string `json:"events"`
+	// WebhookURL receives the events with the webhook channel as JSON.
+	WebhookURL string `json:"webhook_url,omitempty" validate:"omitempty,url"`
+	// WebhookSecret signs the webhook deliveries, it is generated by the server.
+	WebhookSecret string `json:"webhook_secret,omitempty"`
+	// PushRecipient is the chat id or phone number the push gateway delivers to.
+	PushRecipient string `json:"push_recipient,omitempty" validate:"max=256"`
+}
+
+// Channels returns the channels the user wants the event on.
+func (p *NotificationPreferences) Channels(event string) []string {
+	channels, ok := p.Events[event]
+	if !ok {
+		return []string{ChannelEmail}
+	}
+	return channels
+}
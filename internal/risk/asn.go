@@ -0,0 +1,43 @@
+package risk
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+// ASNDatabase finds the autonomous systems of IPs with an offline MaxMind-format
+// (GeoLite2/GeoIP2 ASN) database.
+type ASNDatabase struct {
+	reader *maxminddb.Reader
+}
+
+type asnRecord struct {
+	Number       uint   `maxminddb:"autonomous_system_number"`
+	Organization string `maxminddb:"autonomous_system_organization"`
+}
+
+func OpenASN(path string) (*ASNDatabase, error) {
+	const op = "internal.risk.OpenASN()"
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	return &ASNDatabase{
+		reader: reader,
+	}, nil
+}
+
+// ASN returns the number of the autonomous system the IP belongs to.
+func (a *ASNDatabase) ASN(ip net.IP) (uint, bool) {
+	var record asnRecord
+	if ip == nil || a.reader.Lookup(ip, &record) != nil || record.Number == 0 {
+		return 0, false
+	}
+	return record.Number, true
+}
+
+func (a *ASNDatabase) Close() error {
+	return a.reader.Close()
+}
@@ -0,0 +1,68 @@
+package risk
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/oschwald/maxminddb-golang"
+)
+
+type Location struct {
+	Latitude  float64
+	Longitude float64
+	Country   string
+	City      string
+}
+
+type Locator interface {
+	Locate(ip net.IP) (Location, bool)
+}
+
+// GeoIP locates IPs with an offline MaxMind-format (GeoLite2/GeoIP2 City) database.
+type GeoIP struct {
+	reader *maxminddb.Reader
+}
+
+type geoIPRecord struct {
+	City struct {
+		Names map[string]string `maxminddb:"names"`
+	} `maxminddb:"city"`
+	Country struct {
+		ISOCode string `maxminddb:"iso_code"`
+	} `maxminddb:"country"`
+	Location struct {
+		Latitude  *float64 `maxminddb:"latitude"`
+		Longitude *float64 `maxminddb:"longitude"`
+	} `maxminddb:"location"`
+}
+
+func OpenGeoIP(path string) (*GeoIP, error) {
+	const op = "internal.risk.OpenGeoIP()"
+	reader, err := maxminddb.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	return &GeoIP{
+		reader: reader,
+	}, nil
+}
+
+func (g *GeoIP) Locate(ip net.IP) (Location, bool) {
+	var record geoIPRecord
+	if ip == nil || g.reader.Lookup(ip, &record) != nil {
+		return Location{}, false
+	}
+	if record.Location.Latitude == nil || record.Location.Longitude == nil {
+		return Location{}, false
+	}
+	return Location{
+		Latitude:  *record.Location.Latitude,
+		Longitude: *record.Location.Longitude,
+		Country:   record.Country.ISOCode,
+		City:      record.City.Names["en"],
+	}, true
+}
+
+func (g *GeoIP) Close() error {
+	return g.reader.Close()
+}
@@ -0,0 +1,218 @@
+package risk
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net"
+	"os"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/lib"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+type Action string
+
+const (
+	ActionAllow  Action = "allow"
+	ActionNotify Action = "notify"
+	ActionBlock  Action = "block"
+)
+
+// Signals raised by the engine and their weights in the score.
+const (
+	SignalNewLocation      = "new_location"
+	SignalImpossibleTravel = "impossible_travel"
+	SignalNewUserAgent     = "new_user_agent"
+	SignalDormant          = "dormant"
+
+	weightNewLocation      = 30
+	weightImpossibleTravel = 50
+	weightNewUserAgent     = 20
+	weightDormant          = 15
+)
+
+// historySize is the number of last logins the attempts are compared with.
+const historySize = 50
+
+type Storage interface {
+	GetLogins(ctx context.Context, userID uuid.UUID, limit int) ([]models.Login, error)
+	AddLogin(ctx context.Context, login models.Login, keep int) error
+}
+
+type Attempt struct {
+	UserID    uuid.UUID
+	IP        string
+	UserAgent string
+	Time      time.Time
+}
+
+type Assessment struct {
+	Score    int
+	Signals  []string
+	Action   Action
+	Location *Location
+}
+
+// Engine scores login and refresh attempts against the user's login history.
+type Engine struct {
+	storage Storage
+	locator Locator
+
+	NotifyScore int
+	StepUpScore int
+	BlockScore  int
+	// StepUpAction is applied to scores from StepUpScore up to BlockScore. There is no second
+	// factor to ask the user for yet, so the band either only notifies or blocks.
+	StepUpAction Action
+	// FarDistance is the distance in km from every known location that makes a location new.
+	FarDistance float64
+	// MaxSpeed is the fastest plausible travel speed in km/h.
+	MaxSpeed float64
+	// DormantAfter is the time without logins after which the account is considered dormant.
+	DormantAfter time.Duration
+}
+
+// NewEngine creates an engine, locator may be nil if no GeoIP database is configured.
+func NewEngine(storage Storage, locator Locator) *Engine {
+	return &Engine{
+		storage:      storage,
+		locator:      locator,
+		NotifyScore:  lib.IntEnv("RISK_NOTIFY_SCORE", 30),
+		StepUpScore:  lib.IntEnv("RISK_STEP_UP_SCORE", 60),
+		BlockScore:   lib.IntEnv("RISK_BLOCK_SCORE", 80),
+		StepUpAction: stepUpActionFromEnv(),
+		FarDistance:  float64(lib.IntEnv("RISK_FAR_DISTANCE_KM", 500)),
+		MaxSpeed:     float64(lib.IntEnv("RISK_MAX_SPEED_KMH", 900)),
+		DormantAfter: lib.DurationEnv("RISK_DORMANT_AFTER", 30*24*time.Hour),
+	}
+}
+
+func (e *Engine) Assess(ctx context.Context, attempt Attempt) (Assessment, error) {
+	const op = "internal.risk.Assess()"
+	var assessment Assessment
+	if e.locator != nil {
+		if location, ok := e.locator.Locate(net.ParseIP(attempt.IP)); ok {
+			assessment.Location = &location
+		}
+	}
+
+	logins, err := e.storage.GetLogins(ctx, attempt.UserID, historySize)
+	if err != nil {
+		return assessment, fmt.Errorf("%s:%w", op, err)
+	}
+	if len(logins) > 0 {
+		e.score(&assessment, attempt, logins)
+	}
+	assessment.Action = e.action(assessment.Score)
+	return assessment, nil
+}
+
+// Record adds the attempt to the login history of the user.
+func (e *Engine) Record(ctx context.Context, attempt Attempt, assessment Assessment) error {
+	const op = "internal.risk.Record()"
+	login := models.Login{
+		UserID:    attempt.UserID,
+		IP:        attempt.IP,
+		UserAgent: attempt.UserAgent,
+		CreatedAt: attempt.Time,
+	}
+	if location := assessment.Location; location != nil {
+		login.Latitude = &location.Latitude
+		login.Longitude = &location.Longitude
+		login.Country = location.Country
+		login.City = location.City
+	}
+	err := e.storage.AddLogin(ctx, login, historySize)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	return nil
+}
+
+// score compares the attempt with logins sorted from the newest one.
+func (e *Engine) score(assessment *Assessment, attempt Attempt, logins []models.Login) {
+	last := logins[0]
+	if attempt.Time.Sub(last.CreatedAt) > e.DormantAfter {
+		assessment.add(SignalDormant, weightDormant)
+	}
+
+	knownAgent := false
+	for _, login := range logins {
+		if login.UserAgent == attempt.UserAgent {
+			knownAgent = true
+			break
+		}
+	}
+	if !knownAgent {
+		assessment.add(SignalNewUserAgent, weightNewUserAgent)
+	}
+
+	location := assessment.Location
+	if location == nil {
+		return
+	}
+	nearest := math.Inf(1)
+	for _, login := range logins {
+		if login.Latitude != nil && login.Longitude != nil {
+			nearest = math.Min(nearest, distance(*location, *login.Latitude, *login.Longitude))
+		}
+	}
+	if !math.IsInf(nearest, 1) && nearest > e.FarDistance {
+		assessment.add(SignalNewLocation, weightNewLocation)
+	}
+
+	if last.Latitude != nil && last.Longitude != nil {
+		km := distance(*location, *last.Latitude, *last.Longitude)
+		// a minute is the least time counted, so close logins don't divide by zero
+		hours := math.Max(attempt.Time.Sub(last.CreatedAt).Hours(), 1.0/60)
+		if km > e.FarDistance && km/hours > e.MaxSpeed {
+			assessment.add(SignalImpossibleTravel, weightImpossibleTravel)
+		}
+	}
+}
+
+func (e *Engine) action(score int) Action {
+	switch {
+	case score >= e.BlockScore:
+		return ActionBlock
+	case score >= e.StepUpScore:
+		return e.StepUpAction
+	case score >= e.NotifyScore:
+		return ActionNotify
+	default:
+		return ActionAllow
+	}
+}
+
+// stepUpActionFromEnv returns the action of the step-up band from RISK_STEP_UP_ACTION, block by default.
+func stepUpActionFromEnv() Action {
+	switch action := Action(os.Getenv("RISK_STEP_UP_ACTION")); action {
+	case ActionNotify, ActionBlock:
+		return action
+	case "":
+		return ActionBlock
+	default:
+		log.Warn().Msgf("RISK_STEP_UP_ACTION has invalid value, using %s", ActionBlock)
+		return ActionBlock
+	}
+}
+
+func (a *Assessment) add(signal string, weight int) {
+	a.Signals = append(a.Signals, signal)
+	a.Score += weight
+}
+
+// distance returns the great-circle distance in km.
+func distance(location Location, latitude float64, longitude float64) float64 {
+	const earthRadius = 6371.0
+	toRad := func(deg float64) float64 { return deg * math.Pi / 180 }
+	lat1, lat2 := toRad(location.Latitude), toRad(latitude)
+	dLat := lat2 - lat1
+	dLon := toRad(longitude - location.Longitude)
+	h := math.Sin(dLat/2)*math.Sin(dLat/2) + math.Cos(lat1)*math.Cos(lat2)*math.Sin(dLon/2)*math.Sin(dLon/2)
+	return 2 * earthRadius * math.Asin(math.Sqrt(h))
+}
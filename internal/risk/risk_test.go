@@ -0,0 +1,192 @@
+package risk
+
+import (
+	"context"
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/models"
+)
+
+type testStorage struct {
+	logins []models.Login
+}
+
+func (s *testStorage) GetLogins(ctx context.Context, userID uuid.UUID, limit int) ([]models.Login, error) {
+	return s.logins, nil
+}
+
+func (s *testStorage) AddLogin(ctx context.Context, login models.Login, keep int) error {
+	s.logins = append([]models.Login{login}, s.logins...)
+	return nil
+}
+
+type testLocator map[string]Location
+
+func (l testLocator) Locate(ip net.IP) (Location, bool) {
+	location, ok := l[ip.String()]
+	return location, ok
+}
+
+var (
+	moscow  = Location{Latitude: 55.7558, Longitude: 37.6173, Country: "RU", City: "Moscow"}
+	tula    = Location{Latitude: 54.1931, Longitude: 37.6177, Country: "RU", City: "Tula"}
+	berlin  = Location{Latitude: 52.52, Longitude: 13.405, Country: "DE", City: "Berlin"}
+	newYork = Location{Latitude: 40.7128, Longitude: -74.006, Country: "US", City: "New York"}
+)
+
+var testLocations = testLocator{
+	"198.51.100.1": moscow,
+	"198.51.100.2": tula,
+	"198.51.100.3": berlin,
+	"198.51.100.4": newYork,
+}
+
+func newTestEngine(storage Storage) *Engine {
+	return &Engine{
+		storage:      storage,
+		locator:      testLocations,
+		NotifyScore:  30,
+		StepUpScore:  60,
+		BlockScore:   80,
+		StepUpAction: ActionBlock,
+		FarDistance:  500,
+		MaxSpeed:     900,
+		DormantAfter: 30 * 24 * time.Hour,
+	}
+}
+
+func TestAssessSignals(t *testing.T) {
+	const agent = "test-agent/1.0"
+	now := time.Now()
+	loginFrom := func(ip string, ago time.Duration) models.Login {
+		login := models.Login{IP: ip, UserAgent: agent, CreatedAt: now.Add(-ago)}
+		if location, ok := testLocations[ip]; ok {
+			login.Latitude = &location.Latitude
+			login.Longitude = &location.Longitude
+		}
+		return login
+	}
+
+	tests := []struct {
+		name      string
+		history   []models.Login
+		ip        string
+		userAgent string
+		score     int
+		signals   []string
+	}{
+		{name: "no history", ip: "198.51.100.4", userAgent: "other", score: 0},
+		{name: "known device and place", history: []models.Login{loginFrom("198.51.100.1", time.Hour)}, ip: "198.51.100.1", score: 0},
+		{name: "near place", history: []models.Login{loginFrom("198.51.100.1", time.Minute)}, ip: "198.51.100.2", score: 0},
+		{
+			name:      "new user agent",
+			history:   []models.Login{loginFrom("198.51.100.1", time.Hour)},
+			ip:        "198.51.100.1",
+			userAgent: "other",
+			score:     weightNewUserAgent,
+			signals:   []string{SignalNewUserAgent},
+		},
+		{
+			name:    "dormant",
+			history: []models.Login{loginFrom("198.51.100.1", 31*24*time.Hour)},
+			ip:      "198.51.100.1",
+			score:   weightDormant,
+			signals: []string{SignalDormant},
+		},
+		{
+			name:    "new location reached in time",
+			history: []models.Login{loginFrom("198.51.100.1", 10*time.Hour)},
+			ip:      "198.51.100.3",
+			score:   weightNewLocation,
+			signals: []string{SignalNewLocation},
+		},
+		{
+			name:    "impossible travel",
+			history: []models.Login{loginFrom("198.51.100.1", time.Hour)},
+			ip:      "198.51.100.4",
+			score:   weightNewLocation + weightImpossibleTravel,
+			signals: []string{SignalNewLocation, SignalImpossibleTravel},
+		},
+		{
+			name:    "impossible travel back to a known location",
+			history: []models.Login{loginFrom("198.51.100.4", time.Hour), loginFrom("198.51.100.1", 48*time.Hour)},
+			ip:      "198.51.100.1",
+			score:   weightImpossibleTravel,
+			signals: []string{SignalImpossibleTravel},
+		},
+		{
+			name:    "unknown location of the attempt",
+			history: []models.Login{loginFrom("198.51.100.1", time.Hour)},
+			ip:      "192.0.2.1",
+			score:   0,
+		},
+		{
+			name:      "every signal",
+			history:   []models.Login{loginFrom("198.51.100.1", 31*24*time.Hour)},
+			ip:        "198.51.100.4",
+			userAgent: "other",
+			score:     weightDormant + weightNewUserAgent + weightNewLocation,
+			signals:   []string{SignalDormant, SignalNewUserAgent, SignalNewLocation},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			userAgent := tt.userAgent
+			if userAgent == "" {
+				userAgent = agent
+			}
+			engine := newTestEngine(&testStorage{logins: tt.history})
+			assessment, err := engine.Assess(context.Background(), Attempt{
+				UserID:    uuid.New(),
+				IP:        tt.ip,
+				UserAgent: userAgent,
+				Time:      now,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if assessment.Score != tt.score || !reflect.DeepEqual(assessment.Signals, tt.signals) {
+				t.Fatalf("score %d, signals %v, want %d, %v", assessment.Score, assessment.Signals, tt.score, tt.signals)
+			}
+		})
+	}
+}
+
+func TestActionBands(t *testing.T) {
+	for _, stepUp := range []Action{ActionBlock, ActionNotify} {
+		engine := newTestEngine(nil)
+		engine.StepUpAction = stepUp
+		for score, want := range map[int]Action{
+			0:   ActionAllow,
+			29:  ActionAllow,
+			30:  ActionNotify,
+			59:  ActionNotify,
+			60:  stepUp,
+			79:  stepUp,
+			80:  ActionBlock,
+			115: ActionBlock,
+		} {
+			if got := engine.action(score); got != want {
+				t.Errorf("step-up %s: action(%d) = %s, want %s", stepUp, score, got, want)
+			}
+		}
+	}
+}
+
+func TestStepUpActionFromEnv(t *testing.T) {
+	for value, want := range map[string]Action{
+		"":       ActionBlock,
+		"block":  ActionBlock,
+		"notify": ActionNotify,
+		"allow":  ActionBlock,
+	} {
+		t.Setenv("RISK_STEP_UP_ACTION", value)
+		if got := stepUpActionFromEnv(); got != want {
+			t.Errorf("RISK_STEP_UP_ACTION=%q: %s, want %s", value, got, want)
+		}
+	}
+}
@@ -0,0 +1,41 @@
+package admin
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/go-chi/render"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+// Authorize lets through only requests with the bearer token from ADMIN_TOKEN.
+// The admin API is disabled when ADMIN_TOKEN isn't set.
+func Authorize(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const op = "internal.server.handlers.admin.Authorize()"
+		logs := log.With().Str("fn", op).Logger()
+
+		adminToken := os.Getenv("ADMIN_TOKEN")
+		if adminToken == "" {
+			logs.Error().Msg("Admin API is disabled")
+
+			w.WriteHeader(http.StatusForbidden) // 403
+			render.JSON(w, r, models.StatusError("admin api is disabled"))
+			return
+		}
+
+		token, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !found || subtle.ConstantTimeCompare([]byte(token), []byte(adminToken)) != 1 {
+			logs.Error().Msg("Invalid admin token")
+
+			w.WriteHeader(http.StatusUnauthorized) // 401
+			render.JSON(w, r, models.StatusError("invalid admin token"))
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
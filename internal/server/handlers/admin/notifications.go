@@ -0,0 +1,86 @@
+package admin
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+
+	"github.com/go-chi/render"
+	"github.com/nabishec/tokenapi/internal/lib"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/nabishec/tokenapi/internal/server/handlers/auth"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	defaultNotificationsLimit = 50
+	maxNotificationsLimit     = 500
+)
+
+type NotificationStorage interface {
+	GetNotifications(ctx context.Context, status string, limit int) ([]models.Notification, error)
+}
+
+type NotificationAdmin struct {
+	storage NotificationStorage
+}
+
+func NewNotificationAdmin(storage NotificationStorage) NotificationAdmin {
+	return NotificationAdmin{
+		storage: storage,
+	}
+}
+
+// @Summary      List notifications in outbox
+// @Tags         admin
+// @Description  Просмотр уведомлений в outbox: ожидающих отправки (pending), доставленных (delivered), не доставленных после всех попыток (dead) и вошедших в сводку (throttled).
+// @Produce      json
+// @Security     AdminToken
+// @Param        status   query    string  false  "Status of notifications: pending, delivered, dead or throttled, all if omitted"
+// @Param        limit    query    int     false  "Max number of notifications, 50 by default"
+// @Success      200        {object}  models.Notifications  "Notifications, newest first"
+// @Failure      400        {object}  models.Response     "Incorrect status or limit"
+// @Failure      401        {object}  models.Response     "Invalid admin token"
+// @Failure      500        {object}  models.Response     "Server error(failed get notifications)"
+// @Failure      504        {object}  models.Response     "Storage didn't answer in time"
+// @Router       /tokenapi/v1/admin/notifications [get]
+func (h *NotificationAdmin) List(w http.ResponseWriter, r *http.Request) {
+	const op = "internal.server.handlers.admin.List()"
+	logs := log.With().Str("fn", op).Logger()
+	logs.Info().Msg("Request for list notifications has been received")
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", models.NotificationPending, models.NotificationDelivered, models.NotificationDead, models.NotificationThrottled:
+	default:
+		logs.Error().Msgf("Unknown notification status - %q", status)
+
+		w.WriteHeader(http.StatusBadRequest) // 400
+		render.JSON(w, r, models.StatusError("incorrect value of status"))
+		return
+	}
+
+	limit := defaultNotificationsLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxNotificationsLimit {
+			logs.Error().Msgf("Invalid limit - %q", value)
+
+			w.WriteHeader(http.StatusBadRequest) // 400
+			render.JSON(w, r, models.StatusError("incorrect value of limit"))
+			return
+		}
+	}
+
+	notifications, err := h.storage.GetNotifications(r.Context(), status, limit)
+	if err != nil {
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to get notifications")
+
+		auth.StorageFailed(w, r, err, "failed to get notifications")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK) // 200
+	render.JSON(w, r, models.Notifications{Notifications: notifications})
+}
@@ -0,0 +1,105 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/nabishec/tokenapi/internal/lib"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/nabishec/tokenapi/internal/server/handlers/auth"
+	"github.com/rs/zerolog/log"
+)
+
+type Unlocker interface {
+	DeleteFailures(ctx context.Context, key string) error
+}
+
+type LockoutAdmin struct {
+	unlocker Unlocker
+}
+
+func NewLockoutAdmin(unlocker Unlocker) LockoutAdmin {
+	return LockoutAdmin{
+		unlocker: unlocker,
+	}
+}
+
+// @Summary      Unlock user or IP
+// @Tags         admin
+// @Description  Сброс счетчиков неудачных попыток и снятие блокировки с пользователя и/или IP.
+// @Accept       json
+// @Produce      json
+// @Security     AdminToken
+// @Param        unlock   body     models.Unlock  true   "User GUID and/or IP"
+// @Success      200        {object}  models.Response    "Unlocked successful"
+// @Failure      400        {object}  models.Response     "Incorrect request"
+// @Failure      401        {object}  models.Response     "Invalid admin token"
+// @Failure      500        {object}  models.Response     "Server error(failed unlock)"
+// @Failure      504        {object}  models.Response     "Storage didn't answer in time"
+// @Router       /tokenapi/v1/admin/unlock [post]
+func (h *LockoutAdmin) Unlock(w http.ResponseWriter, r *http.Request) {
+	const op = "internal.server.handlers.admin.Unlock()"
+	logs := log.With().Str("fn", op).Logger()
+	logs.Info().Msg("Request for unlock has been received")
+
+	var req models.Unlock
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			logs.Error().Msg("request body is empty")
+		} else {
+			logs.Error().Err(err).Msg("failed to decode request body")
+		}
+
+		w.WriteHeader(http.StatusBadRequest) // 400
+		render.JSON(w, r, models.StatusError("incorrect request"))
+		return
+	}
+
+	if err := validator.New().Struct(req); err != nil {
+		validatorErr := err.(validator.ValidationErrors)
+		logs.Error().Err(err).Msg("invalid types")
+
+		w.WriteHeader(http.StatusBadRequest) // 400
+		render.JSON(w, r, models.StatusError(validatorErr.Error()))
+		return
+	}
+
+	var keys []string
+	if req.ClientID != "" {
+		keys = append(keys, auth.UserKey(req.ClientID))
+	}
+	if req.IP != "" {
+		// lockouts are recorded under the canonical form from auth.GetIP,
+		// so ::ffff:10.0.0.1 or an uncompressed IPv6 unlock the same key
+		ip := net.ParseIP(req.IP)
+		if ip == nil {
+			logs.Error().Msg("invalid ip")
+
+			w.WriteHeader(http.StatusBadRequest) // 400
+			render.JSON(w, r, models.StatusError("invalid ip"))
+			return
+		}
+		keys = append(keys, auth.IPKey(ip.String()))
+	}
+
+	for _, key := range keys {
+		err = h.unlocker.DeleteFailures(r.Context(), key)
+		if err != nil {
+			logs.Error().AnErr(lib.ErrReader(err)).Msgf("Failed to unlock %s", key)
+
+			auth.StorageFailed(w, r, err, "failed to unlock")
+			return
+		}
+		logs.Info().Msgf("%s unlocked", key)
+	}
+
+	w.WriteHeader(http.StatusOK) // 200
+	render.JSON(w, r, models.StatusOK())
+}
@@ -0,0 +1,159 @@
+package admin
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/client/webhook"
+	"github.com/nabishec/tokenapi/internal/lib"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/nabishec/tokenapi/internal/server/handlers/auth"
+	"github.com/nabishec/tokenapi/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+type UserStorage interface {
+	RevokeUserTokens(ctx context.Context, userID uuid.UUID, exp time.Time) (time.Time, error)
+	SetTokenFormat(ctx context.Context, userID uuid.UUID, format string) error
+}
+
+type UserAdmin struct {
+	storage UserStorage
+	events  *webhook.Emitter
+}
+
+func NewUserAdmin(storage UserStorage, events *webhook.Emitter) UserAdmin {
+	return UserAdmin{
+		storage: storage,
+		events:  events,
+	}
+}
+
+// @Summary      Revoke all tokens of user
+// @Tags         admin
+// @Description  Отзыв всех токенов пользователя (смена пароля, компрометация, увольнение). Токены, выданные раньше, перестают приниматься.
+// @Produce      json
+// @Security     AdminToken
+// @Param        user_id  path     string  true   "GUID user"
+// @Success      200        {object}  models.Response    "Tokens revoked"
+// @Failure      400        {object}  models.Response     "Incorrect value of user id"
+// @Failure      401        {object}  models.Response     "Invalid admin token"
+// @Failure      404        {object}  models.Response     "User not found"
+// @Failure      500        {object}  models.Response     "Server error(failed revoke tokens)"
+// @Failure      504        {object}  models.Response     "Storage didn't answer in time"
+// @Router       /tokenapi/v1/admin/users/{user_id}/revoke [post]
+func (h *UserAdmin) RevokeTokens(w http.ResponseWriter, r *http.Request) {
+	const op = "internal.server.handlers.admin.RevokeTokens()"
+	logs := log.With().Str("fn", op).Logger()
+	logs.Info().Msg("Request for revoke tokens of user has been received")
+
+	userGUID, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		logs.Error().Msg("Failed to receive user GUID")
+
+		w.WriteHeader(http.StatusBadRequest) // 400
+		render.JSON(w, r, models.StatusError("incorrect value of user id"))
+		return
+	}
+
+	revokedBefore, err := h.storage.RevokeUserTokens(r.Context(), userGUID, time.Now().Add(auth.AccessTokenLifetime))
+	if err != nil {
+		if err == storage.ErrUserNotExists {
+			logs.Error().Msgf("User id - %s not found", userGUID)
+
+			w.WriteHeader(http.StatusNotFound) // 404
+			render.JSON(w, r, models.StatusError("user id not fount"))
+			return
+		}
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to revoke tokens")
+
+		auth.StorageFailed(w, r, err, "failed to revoke tokens")
+		return
+	}
+	logs.Info().Msgf("Tokens of user - %s issued before %s revoked", userGUID, revokedBefore)
+	h.events.Emit(webhook.TokenRevoked(userGUID, nil, webhook.RevokedAdmin))
+
+	w.WriteHeader(http.StatusOK) // 200
+	render.JSON(w, r, models.StatusOK())
+}
+
+// @Summary      Set access token format of user
+// @Tags         admin
+// @Description  Выбор формата access токенов клиента: jwt, v4.public или v4.local (PASETO). Пустое значение возвращает формат по умолчанию.
+// @Accept       json
+// @Produce      json
+// @Security     AdminToken
+// @Param        user_id  path     string              true   "GUID user"
+// @Param        format   body     models.TokenFormat  true   "Token format"
+// @Success      200        {object}  models.Response    "Token format set"
+// @Failure      400        {object}  models.Response     "Incorrect request or format isn't configured"
+// @Failure      401        {object}  models.Response     "Invalid admin token"
+// @Failure      404        {object}  models.Response     "User not found"
+// @Failure      500        {object}  models.Response     "Server error(failed set token format)"
+// @Failure      504        {object}  models.Response     "Storage didn't answer in time"
+// @Router       /tokenapi/v1/admin/users/{user_id}/token-format [put]
+func (h *UserAdmin) SetTokenFormat(w http.ResponseWriter, r *http.Request) {
+	const op = "internal.server.handlers.admin.SetTokenFormat()"
+	logs := log.With().Str("fn", op).Logger()
+	logs.Info().Msg("Request for set token format of user has been received")
+
+	userGUID, err := uuid.Parse(chi.URLParam(r, "user_id"))
+	if err != nil {
+		logs.Error().Msg("Failed to receive user GUID")
+
+		w.WriteHeader(http.StatusBadRequest) // 400
+		render.JSON(w, r, models.StatusError("incorrect value of user id"))
+		return
+	}
+
+	var req models.TokenFormat
+	err = json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			logs.Error().Msg("request body is empty")
+		} else {
+			logs.Error().Err(err).Msg("failed to decode request body")
+		}
+
+		w.WriteHeader(http.StatusBadRequest) // 400
+		render.JSON(w, r, models.StatusError("incorrect request"))
+		return
+	}
+
+	if req.TokenFormat != "" {
+		_, err = auth.GetTokenFormat(req.TokenFormat)
+		if err != nil {
+			logs.Error().AnErr(lib.ErrReader(err)).Msg("Unknown token format")
+
+			w.WriteHeader(http.StatusBadRequest) // 400
+			render.JSON(w, r, models.StatusError("token format isn't configured"))
+			return
+		}
+	}
+
+	err = h.storage.SetTokenFormat(r.Context(), userGUID, req.TokenFormat)
+	if err != nil {
+		if err == storage.ErrUserNotExists {
+			logs.Error().Msgf("User id - %s not found", userGUID)
+
+			w.WriteHeader(http.StatusNotFound) // 404
+			render.JSON(w, r, models.StatusError("user id not fount"))
+			return
+		}
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to set token format")
+
+		auth.StorageFailed(w, r, err, "failed to set token format")
+		return
+	}
+	logs.Info().Msgf("Token format of user - %s set to %q", userGUID, req.TokenFormat)
+
+	w.WriteHeader(http.StatusOK) // 200
+	render.JSON(w, r, models.StatusOK())
+}
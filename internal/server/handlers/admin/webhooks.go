@@ -0,0 +1,363 @@
+package admin
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/client/webhook"
+	"github.com/nabishec/tokenapi/internal/lib"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/nabishec/tokenapi/internal/server/handlers/auth"
+	"github.com/nabishec/tokenapi/internal/storage"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+type WebhookStorage interface {
+	AddWebhook(ctx context.Context, webhook models.Webhook) error
+	GetWebhooks(ctx context.Context) ([]models.Webhook, error)
+	UpdateWebhook(ctx context.Context, webhook models.Webhook) error
+	DeleteWebhook(ctx context.Context, webhookID uuid.UUID) error
+	GetWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, status string, limit int) ([]models.WebhookDelivery, error)
+}
+
+type WebhookAdmin struct {
+	storage WebhookStorage
+}
+
+func NewWebhookAdmin(storage WebhookStorage) WebhookAdmin {
+	return WebhookAdmin{
+		storage: storage,
+	}
+}
+
+// @Summary      Add webhook
+// @Tags         admin
+// @Description  Подписка внешнего сервиса на события безопасности: token.issued, token.refreshed, token.revoked, ip.mismatch, token.reuse_detected. Пустой список events - все события. Доставки подписываются HMAC-SHA256 секретом webhook в заголовке Webhook-Signature. Секрет возвращается только при создании и изменении.
+// @Accept       json
+// @Produce      json
+// @Security     AdminToken
+// @Param        webhook  body     models.WebhookRequest  true   "Webhook"
+// @Success      201        {object}  models.Webhook     "Webhook added"
+// @Failure      400        {object}  models.Response     "Incorrect url or events"
+// @Failure      401        {object}  models.Response     "Invalid admin token"
+// @Failure      500        {object}  models.Response     "Server error(failed add webhook)"
+// @Failure      504        {object}  models.Response     "Storage didn't answer in time"
+// @Router       /tokenapi/v1/admin/webhooks [post]
+func (h *WebhookAdmin) Add(w http.ResponseWriter, r *http.Request) {
+	const op = "internal.server.handlers.admin.Add()"
+	logs := log.With().Str("fn", op).Logger()
+	logs.Info().Msg("Request for add webhook has been received")
+
+	req, ok := decodeWebhook(w, r, logs)
+	if !ok {
+		return
+	}
+
+	hook := models.Webhook{
+		ID:        uuid.New(),
+		URL:       req.URL,
+		Secret:    req.Secret,
+		Events:    req.Events,
+		Active:    req.Active == nil || *req.Active,
+		CreatedAt: time.Now(),
+	}
+	if hook.Secret == "" {
+		var err error
+		hook.Secret, err = newWebhookSecret()
+		if err != nil {
+			logs.Error().Err(err).Msg("Failed to generate webhook secret")
+
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			render.JSON(w, r, models.StatusError("failed to add webhook"))
+			return
+		}
+	}
+
+	err := h.storage.AddWebhook(r.Context(), hook)
+	if err != nil {
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to add webhook")
+
+		auth.StorageFailed(w, r, err, "failed to add webhook")
+		return
+	}
+	logs.Info().Msgf("Webhook - %s to %s added", hook.ID, hook.URL)
+
+	w.WriteHeader(http.StatusCreated) // 201
+	render.JSON(w, r, hook)
+}
+
+// @Summary      List webhooks
+// @Tags         admin
+// @Description  Список подписок на события безопасности без секретов.
+// @Produce      json
+// @Security     AdminToken
+// @Success      200        {object}  models.Webhooks    "Webhooks"
+// @Failure      401        {object}  models.Response     "Invalid admin token"
+// @Failure      500        {object}  models.Response     "Server error(failed get webhooks)"
+// @Failure      504        {object}  models.Response     "Storage didn't answer in time"
+// @Router       /tokenapi/v1/admin/webhooks [get]
+func (h *WebhookAdmin) List(w http.ResponseWriter, r *http.Request) {
+	const op = "internal.server.handlers.admin.List()"
+	logs := log.With().Str("fn", op).Logger()
+	logs.Info().Msg("Request for list webhooks has been received")
+
+	webhooks, err := h.storage.GetWebhooks(r.Context())
+	if err != nil {
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to get webhooks")
+
+		auth.StorageFailed(w, r, err, "failed to get webhooks")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK) // 200
+	render.JSON(w, r, models.Webhooks{Webhooks: webhooks})
+}
+
+// @Summary      Update webhook
+// @Tags         admin
+// @Description  Изменение url, событий и состояния (active) подписки. Если secret не указан, остается прежний.
+// @Accept       json
+// @Produce      json
+// @Security     AdminToken
+// @Param        webhook_id  path     string                 true   "Webhook id"
+// @Param        webhook     body     models.WebhookRequest  true   "Webhook"
+// @Success      200        {object}  models.Response    "Webhook updated"
+// @Failure      400        {object}  models.Response     "Incorrect webhook id, url or events"
+// @Failure      401        {object}  models.Response     "Invalid admin token"
+// @Failure      404        {object}  models.Response     "Webhook not found"
+// @Failure      500        {object}  models.Response     "Server error(failed update webhook)"
+// @Failure      504        {object}  models.Response     "Storage didn't answer in time"
+// @Router       /tokenapi/v1/admin/webhooks/{webhook_id} [put]
+func (h *WebhookAdmin) Update(w http.ResponseWriter, r *http.Request) {
+	const op = "internal.server.handlers.admin.Update()"
+	logs := log.With().Str("fn", op).Logger()
+	logs.Info().Msg("Request for update webhook has been received")
+
+	webhookID, ok := webhookIDParam(w, r, logs)
+	if !ok {
+		return
+	}
+	req, ok := decodeWebhook(w, r, logs)
+	if !ok {
+		return
+	}
+
+	err := h.storage.UpdateWebhook(r.Context(), models.Webhook{
+		ID:     webhookID,
+		URL:    req.URL,
+		Secret: req.Secret,
+		Events: req.Events,
+		Active: req.Active == nil || *req.Active,
+	})
+	if err != nil {
+		if err == storage.ErrWebhookNotExists {
+			logs.Error().Msgf("Webhook - %s not found", webhookID)
+
+			w.WriteHeader(http.StatusNotFound) // 404
+			render.JSON(w, r, models.StatusError("webhook not found"))
+			return
+		}
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to update webhook")
+
+		auth.StorageFailed(w, r, err, "failed to update webhook")
+		return
+	}
+	logs.Info().Msgf("Webhook - %s updated", webhookID)
+
+	w.WriteHeader(http.StatusOK) // 200
+	render.JSON(w, r, models.StatusOK())
+}
+
+// @Summary      Delete webhook
+// @Tags         admin
+// @Description  Удаление подписки вместе с журналом ее доставок.
+// @Produce      json
+// @Security     AdminToken
+// @Param        webhook_id  path     string  true   "Webhook id"
+// @Success      200        {object}  models.Response    "Webhook deleted"
+// @Failure      400        {object}  models.Response     "Incorrect webhook id"
+// @Failure      401        {object}  models.Response     "Invalid admin token"
+// @Failure      404        {object}  models.Response     "Webhook not found"
+// @Failure      500        {object}  models.Response     "Server error(failed delete webhook)"
+// @Failure      504        {object}  models.Response     "Storage didn't answer in time"
+// @Router       /tokenapi/v1/admin/webhooks/{webhook_id} [delete]
+func (h *WebhookAdmin) Delete(w http.ResponseWriter, r *http.Request) {
+	const op = "internal.server.handlers.admin.Delete()"
+	logs := log.With().Str("fn", op).Logger()
+	logs.Info().Msg("Request for delete webhook has been received")
+
+	webhookID, ok := webhookIDParam(w, r, logs)
+	if !ok {
+		return
+	}
+
+	err := h.storage.DeleteWebhook(r.Context(), webhookID)
+	if err != nil {
+		if err == storage.ErrWebhookNotExists {
+			logs.Error().Msgf("Webhook - %s not found", webhookID)
+
+			w.WriteHeader(http.StatusNotFound) // 404
+			render.JSON(w, r, models.StatusError("webhook not found"))
+			return
+		}
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to delete webhook")
+
+		auth.StorageFailed(w, r, err, "failed to delete webhook")
+		return
+	}
+	logs.Info().Msgf("Webhook - %s deleted", webhookID)
+
+	w.WriteHeader(http.StatusOK) // 200
+	render.JSON(w, r, models.StatusOK())
+}
+
+// @Summary      List webhook deliveries
+// @Tags         admin
+// @Description  Журнал доставок подписки: статус (pending, delivered, dead), число попыток, код ответа и последняя ошибка.
+// @Produce      json
+// @Security     AdminToken
+// @Param        webhook_id  path     string  true   "Webhook id"
+// @Param        status      query    string  false  "Status of deliveries: pending, delivered or dead, all if omitted"
+// @Param        limit       query    int     false  "Max number of deliveries, 50 by default"
+// @Success      200        {object}  models.WebhookDeliveries  "Deliveries, newest first"
+// @Failure      400        {object}  models.Response     "Incorrect webhook id, status or limit"
+// @Failure      401        {object}  models.Response     "Invalid admin token"
+// @Failure      404        {object}  models.Response     "Webhook not found"
+// @Failure      500        {object}  models.Response     "Server error(failed get deliveries)"
+// @Failure      504        {object}  models.Response     "Storage didn't answer in time"
+// @Router       /tokenapi/v1/admin/webhooks/{webhook_id}/deliveries [get]
+func (h *WebhookAdmin) Deliveries(w http.ResponseWriter, r *http.Request) {
+	const op = "internal.server.handlers.admin.Deliveries()"
+	logs := log.With().Str("fn", op).Logger()
+	logs.Info().Msg("Request for list webhook deliveries has been received")
+
+	webhookID, ok := webhookIDParam(w, r, logs)
+	if !ok {
+		return
+	}
+
+	status := r.URL.Query().Get("status")
+	switch status {
+	case "", models.NotificationPending, models.NotificationDelivered, models.NotificationDead:
+	default:
+		logs.Error().Msgf("Unknown delivery status - %q", status)
+
+		w.WriteHeader(http.StatusBadRequest) // 400
+		render.JSON(w, r, models.StatusError("incorrect value of status"))
+		return
+	}
+
+	limit := defaultNotificationsLimit
+	if value := r.URL.Query().Get("limit"); value != "" {
+		var err error
+		limit, err = strconv.Atoi(value)
+		if err != nil || limit <= 0 || limit > maxNotificationsLimit {
+			logs.Error().Msgf("Invalid limit - %q", value)
+
+			w.WriteHeader(http.StatusBadRequest) // 400
+			render.JSON(w, r, models.StatusError("incorrect value of limit"))
+			return
+		}
+	}
+
+	deliveries, err := h.storage.GetWebhookDeliveries(r.Context(), webhookID, status, limit)
+	if err != nil {
+		if err == storage.ErrWebhookNotExists {
+			logs.Error().Msgf("Webhook - %s not found", webhookID)
+
+			w.WriteHeader(http.StatusNotFound) // 404
+			render.JSON(w, r, models.StatusError("webhook not found"))
+			return
+		}
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to get deliveries")
+
+		auth.StorageFailed(w, r, err, "failed to get deliveries")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK) // 200
+	render.JSON(w, r, models.WebhookDeliveries{Deliveries: deliveries})
+}
+
+func webhookIDParam(w http.ResponseWriter, r *http.Request, logs zerolog.Logger) (uuid.UUID, bool) {
+	webhookID, err := uuid.Parse(chi.URLParam(r, "webhook_id"))
+	if err != nil {
+		logs.Error().Msg("Failed to receive webhook id")
+
+		w.WriteHeader(http.StatusBadRequest) // 400
+		render.JSON(w, r, models.StatusError("incorrect value of webhook id"))
+		return uuid.Nil, false
+	}
+	return webhookID, true
+}
+
+// decodeWebhook reads and validates the webhook from the body, it writes the response on failure.
+func decodeWebhook(w http.ResponseWriter, r *http.Request, logs zerolog.Logger) (models.WebhookRequest, bool) {
+	var req models.WebhookRequest
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			logs.Error().Msg("request body is empty")
+		} else {
+			logs.Error().Err(err).Msg("failed to decode request body")
+		}
+
+		w.WriteHeader(http.StatusBadRequest) // 400
+		render.JSON(w, r, models.StatusError("incorrect request"))
+		return req, false
+	}
+
+	if err := validator.New().Struct(req); err != nil {
+		validatorErr := err.(validator.ValidationErrors)
+		logs.Error().Err(err).Msg("invalid types")
+
+		w.WriteHeader(http.StatusBadRequest) // 400
+		render.JSON(w, r, models.StatusError(validatorErr.Error()))
+		return req, false
+	}
+
+	parsed, err := url.Parse(req.URL)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		logs.Error().Msgf("Invalid webhook url - %q", req.URL)
+
+		w.WriteHeader(http.StatusBadRequest) // 400
+		render.JSON(w, r, models.StatusError("incorrect value of url"))
+		return req, false
+	}
+
+	if req.Events == nil {
+		req.Events = []string{}
+	}
+	for _, event := range req.Events {
+		if !webhook.KnownEvent(event) {
+			logs.Error().Msgf("Unknown event - %q", event)
+
+			w.WriteHeader(http.StatusBadRequest) // 400
+			render.JSON(w, r, models.StatusError("unknown event "+event))
+			return req, false
+		}
+	}
+	return req, true
+}
+
+func newWebhookSecret() (string, error) {
+	secret := make([]byte, 32)
+	_, err := rand.Read(secret)
+	if err != nil {
+		return "", err
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
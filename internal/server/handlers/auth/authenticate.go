@@ -0,0 +1,120 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/lib"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+type RevocationStorage interface {
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	GetRevokedBefore(ctx context.Context, userID uuid.UUID) (time.Time, error)
+}
+
+// IssuedBefore reports whether the token was issued before revokedBefore,
+// the time all tokens of the user were invalidated at.
+func IssuedBefore(claims *JWTClaims, revokedBefore time.Time) bool {
+	return !revokedBefore.IsZero() && claims.IssuedAt < revokedBefore.Unix()
+}
+
+// Authenticator checks access tokens of requests to the api's own protected routes.
+type Authenticator struct {
+	storage RevocationStorage
+	dpop    *DPoP
+}
+
+func NewAuthenticator(storage RevocationStorage, dpop *DPoP) *Authenticator {
+	return &Authenticator{
+		storage: storage,
+		dpop:    dpop,
+	}
+}
+
+type claimsKey struct{}
+
+// ClaimsFromContext returns the claims of the access token the request was authenticated with.
+func ClaimsFromContext(ctx context.Context) *JWTClaims {
+	claims, _ := ctx.Value(claimsKey{}).(*JWTClaims)
+	return claims
+}
+
+// VerifyAccessToken validates the access token of the request, its proof of possession
+// if the token is bound, and checks that the token wasn't revoked.
+func (a *Authenticator) VerifyAccessToken(r *http.Request) (*JWTClaims, error) {
+	const op = "internal.server.handlers.auth.VerifyAccessToken()"
+	var claims *JWTClaims
+	var err error
+	authorization := r.Header.Get("Authorization")
+	switch {
+	case strings.HasPrefix(authorization, TokenTypeDPoP+" "):
+		claims, err = a.dpop.VerifyBoundToken(r)
+	case strings.HasPrefix(authorization, TokenTypeBearer+" "):
+		claims, err = TokenValid(strings.TrimPrefix(authorization, TokenTypeBearer+" "))
+		if err == nil && claims.Cnf.jkt() != "" {
+			err = fmt.Errorf("%s:%s", op, "dpop bound token sent as bearer")
+		}
+		if err == nil && claims.Cnf.x5t() != "" {
+			_, err = VerifyCertificateBoundToken(r)
+		}
+	default:
+		return nil, fmt.Errorf("%s:%s", op, "no access token")
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+
+	revoked, err := a.storage.IsTokenRevoked(r.Context(), claims.Id)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	if revoked {
+		return nil, fmt.Errorf("%s:%s", op, "access token revoked")
+	}
+
+	userID, err := uuid.Parse(claims.Subject)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s", op, "invalid subject")
+	}
+	revokedBefore, err := a.storage.GetRevokedBefore(r.Context(), userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	if IssuedBefore(claims, revokedBefore) {
+		return nil, fmt.Errorf("%s:%s", op, "access token issued before logout everywhere")
+	}
+	return claims, nil
+}
+
+// Authenticate lets through only requests with a valid access token
+// and puts its claims into the request context.
+func (a *Authenticator) Authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		const op = "internal.server.handlers.auth.Authenticate()"
+		logs := log.With().Str("fn", op).Logger()
+
+		claims, err := a.VerifyAccessToken(r)
+		if err != nil && isCanceled(err) {
+			logs.Error().AnErr(lib.ErrReader(err)).Msg("Access token check canceled")
+			StorageFailed(w, r, err, "failed to check access token")
+			return
+		}
+		if err != nil {
+			logs.Error().AnErr(lib.ErrReader(err)).Msg("Unauthorized request")
+
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			w.WriteHeader(http.StatusUnauthorized) // 401
+			render.JSON(w, r, models.StatusError("invalid access token"))
+			return
+		}
+
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), claimsKey{}, claims)))
+	})
+}
@@ -0,0 +1,123 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// Names of the cookies and the header used in cookie delivery mode.
+const (
+	RefreshCookieName = "refresh_token"
+	CSRFCookieName    = "csrf_token"
+	CSRFHeader        = "X-CSRF-Token"
+)
+
+// RefreshCookie delivers refresh tokens to browsers in a Secure HttpOnly cookie
+// instead of the response body. The refresh endpoint then reads the token from the cookie
+// and requires the value of the CSRF cookie in the X-CSRF-Token header (double submit).
+type RefreshCookie struct {
+	// Enabled switches issuance and refresh to cookie delivery.
+	Enabled bool
+	// Path scopes the refresh cookie, the browser sends it only to the refresh endpoint.
+	Path     string
+	Domain   string
+	SameSite http.SameSite
+	// Secure can be switched off only for local development over http.
+	Secure bool
+}
+
+func NewRefreshCookie() (*RefreshCookie, error) {
+	const op = "internal.server.handlers.auth.NewRefreshCookie()"
+	cookie := &RefreshCookie{
+		Enabled: os.Getenv("REFRESH_TOKEN_DELIVERY") == "cookie",
+		Path:    os.Getenv("REFRESH_COOKIE_PATH"),
+		Domain:  os.Getenv("REFRESH_COOKIE_DOMAIN"),
+		Secure:  os.Getenv("REFRESH_COOKIE_SECURE") != "false",
+	}
+	if cookie.Path == "" {
+		cookie.Path = "/tokenapi/v1/auth/refresh"
+	}
+	switch strings.ToLower(os.Getenv("REFRESH_COOKIE_SAMESITE")) {
+	case "", "strict":
+		cookie.SameSite = http.SameSiteStrictMode
+	case "lax":
+		cookie.SameSite = http.SameSiteLaxMode
+	case "none":
+		if !cookie.Secure {
+			return nil, fmt.Errorf("%s:%s", op, "SameSite=None requires secure cookies")
+		}
+		cookie.SameSite = http.SameSiteNoneMode
+	default:
+		return nil, fmt.Errorf("%s:unknown same site mode %q", op, os.Getenv("REFRESH_COOKIE_SAMESITE"))
+	}
+	return cookie, nil
+}
+
+// Set writes the refresh cookie and a fresh CSRF cookie readable by scripts.
+func (c *RefreshCookie) Set(w http.ResponseWriter, refreshToken string, exp time.Time) error {
+	const op = "internal.server.handlers.auth.Set()"
+	csrf := make([]byte, 32)
+	_, err := rand.Read(csrf)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	http.SetCookie(w, &http.Cookie{
+		Name:     RefreshCookieName,
+		Value:    refreshToken,
+		Path:     c.Path,
+		Domain:   c.Domain,
+		Expires:  exp,
+		Secure:   c.Secure,
+		HttpOnly: true,
+		SameSite: c.SameSite,
+	})
+	http.SetCookie(w, &http.Cookie{
+		Name:     CSRFCookieName,
+		Value:    base64.RawURLEncoding.EncodeToString(csrf),
+		Path:     "/",
+		Domain:   c.Domain,
+		Expires:  exp,
+		Secure:   c.Secure,
+		SameSite: c.SameSite,
+	})
+	return nil
+}
+
+// Clear removes both cookies, for example after a failed refresh.
+func (c *RefreshCookie) Clear(w http.ResponseWriter) {
+	for _, cookie := range []*http.Cookie{
+		{Name: RefreshCookieName, Path: c.Path, HttpOnly: true},
+		{Name: CSRFCookieName, Path: "/"},
+	} {
+		cookie.Domain = c.Domain
+		cookie.MaxAge = -1
+		cookie.Secure = c.Secure
+		cookie.SameSite = c.SameSite
+		http.SetCookie(w, cookie)
+	}
+}
+
+// RefreshToken returns the refresh token from the cookie of r
+// after checking that the CSRF header matches the CSRF cookie.
+func (c *RefreshCookie) RefreshToken(r *http.Request) (string, error) {
+	const op = "internal.server.handlers.auth.RefreshToken()"
+	refresh, err := r.Cookie(RefreshCookieName)
+	if err != nil || refresh.Value == "" {
+		return "", fmt.Errorf("%s:%s", op, "no refresh token cookie")
+	}
+	csrf, err := r.Cookie(CSRFCookieName)
+	if err != nil || csrf.Value == "" {
+		return "", fmt.Errorf("%s:%s", op, "no csrf cookie")
+	}
+	header := r.Header.Get(CSRFHeader)
+	if subtle.ConstantTimeCompare([]byte(header), []byte(csrf.Value)) != 1 {
+		return "", fmt.Errorf("%s:%s", op, "csrf token doesn't match")
+	}
+	return refresh.Value, nil
+}
@@ -0,0 +1,287 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/nabishec/tokenapi/internal/lib"
+)
+
+// Token types returned to clients.
+const (
+	TokenTypeBearer = "Bearer"
+	TokenTypeDPoP   = "DPoP"
+)
+
+var ErrDPoPProofMissing = errors.New("dpop proof missing")
+
+type DPoPStorage interface {
+	// UseDPoPJTI saves the jti of a proof until exp and reports false if it was already used.
+	UseDPoPJTI(ctx context.Context, jti string, exp time.Time) (bool, error)
+}
+
+// DPoP verifies RFC 9449 proofs of possession and tokens bound to them.
+type DPoP struct {
+	storage DPoPStorage
+	// Required rejects token requests without a proof.
+	Required bool
+	// ProofLifetime is how far from now the iat of a proof may be.
+	ProofLifetime time.Duration
+}
+
+func NewDPoP(storage DPoPStorage) *DPoP {
+	return &DPoP{
+		storage:       storage,
+		Required:      os.Getenv("DPOP_REQUIRED") == "true",
+		ProofLifetime: lib.DurationEnv("DPOP_PROOF_LIFETIME", 2*time.Minute),
+	}
+}
+
+type dpopClaims struct {
+	HTM string `json:"htm"`
+	HTU string `json:"htu"`
+	ATH string `json:"ath,omitempty"`
+	jwt.StandardClaims
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	D   string `json:"d,omitempty"`
+}
+
+var dpopMethods = []string{"ES256", "ES384", "ES512", "RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "EdDSA"}
+
+// VerifyProof checks the DPoP header of r and returns the JWK thumbprint of the proof key.
+// If accessToken isn't empty, the proof must be bound to it with the ath claim.
+// ErrDPoPProofMissing is returned when the request has no proof.
+func (d *DPoP) VerifyProof(r *http.Request, accessToken string) (string, error) {
+	const op = "internal.server.handlers.auth.VerifyProof()"
+	proofs := r.Header.Values("DPoP")
+	if len(proofs) == 0 {
+		return "", ErrDPoPProofMissing
+	}
+	if len(proofs) > 1 {
+		return "", fmt.Errorf("%s:%s", op, "more than one proof")
+	}
+
+	var thumbprint string
+	var claims dpopClaims
+	parser := jwt.Parser{ValidMethods: dpopMethods, SkipClaimsValidation: true}
+	_, err := parser.ParseWithClaims(proofs[0], &claims, func(token *jwt.Token) (interface{}, error) {
+		if typ, _ := token.Header["typ"].(string); typ != "dpop+jwt" {
+			return nil, fmt.Errorf("unexpected typ %v", token.Header["typ"])
+		}
+		raw, err := json.Marshal(token.Header["jwk"])
+		if err != nil {
+			return nil, err
+		}
+		var key jwk
+		if err := json.Unmarshal(raw, &key); err != nil {
+			return nil, err
+		}
+		thumbprint, err = key.thumbprint()
+		if err != nil {
+			return nil, err
+		}
+		return key.publicKey()
+	})
+	if err != nil {
+		return "", fmt.Errorf("%s:%s", op, "invalid proof")
+	}
+
+	if claims.HTM != r.Method {
+		return "", fmt.Errorf("%s:%s", op, "htm doesn't match request method")
+	}
+	if !sameURI(claims.HTU, requestURI(r)) {
+		return "", fmt.Errorf("%s:%s", op, "htu doesn't match request uri")
+	}
+	issuedAt := time.Unix(claims.IssuedAt, 0)
+	if time.Since(issuedAt).Abs() > d.ProofLifetime {
+		return "", fmt.Errorf("%s:%s", op, "proof is too old or from the future")
+	}
+	if accessToken != "" {
+		ath := sha256.Sum256([]byte(accessToken))
+		expected := base64.RawURLEncoding.EncodeToString(ath[:])
+		if subtle.ConstantTimeCompare([]byte(claims.ATH), []byte(expected)) != 1 {
+			return "", fmt.Errorf("%s:%s", op, "ath doesn't match access token")
+		}
+	}
+	if claims.Id == "" {
+		return "", fmt.Errorf("%s:%s", op, "proof without jti")
+	}
+	fresh, err := d.storage.UseDPoPJTI(r.Context(), thumbprint+":"+claims.Id, issuedAt.Add(2*d.ProofLifetime))
+	if err != nil {
+		return "", fmt.Errorf("%s:%w", op, err)
+	}
+	if !fresh {
+		return "", fmt.Errorf("%s:%s", op, "proof replayed")
+	}
+	return thumbprint, nil
+}
+
+// VerifyBoundToken is meant for resource servers. It validates the access token
+// sent as "Authorization: DPoP <token>" together with the proof of possession of its key.
+func (d *DPoP) VerifyBoundToken(r *http.Request) (*JWTClaims, error) {
+	const op = "internal.server.handlers.auth.VerifyBoundToken()"
+	accessToken, found := strings.CutPrefix(r.Header.Get("Authorization"), TokenTypeDPoP+" ")
+	if !found {
+		return nil, fmt.Errorf("%s:%s", op, "no dpop access token")
+	}
+	claims, err := TokenValid(accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	if claims.Cnf == nil || claims.Cnf.JKT == "" {
+		return nil, fmt.Errorf("%s:%s", op, "access token isn't dpop bound")
+	}
+	jkt, err := d.VerifyProof(r, accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	if jkt != claims.Cnf.JKT {
+		return nil, fmt.Errorf("%s:%s", op, "proof key doesn't match token")
+	}
+	return claims, nil
+}
+
+// thumbprint returns the RFC 7638 SHA-256 thumbprint of the key.
+func (k jwk) thumbprint() (string, error) {
+	var members string
+	switch k.Kty {
+	case "EC":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"EC","x":%q,"y":%q}`, k.Crv, k.X, k.Y)
+	case "RSA":
+		members = fmt.Sprintf(`{"e":%q,"kty":"RSA","n":%q}`, k.E, k.N)
+	case "OKP":
+		members = fmt.Sprintf(`{"crv":%q,"kty":"OKP","x":%q}`, k.Crv, k.X)
+	default:
+		return "", fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+	sum := sha256.Sum256([]byte(members))
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func (k jwk) publicKey() (interface{}, error) {
+	if k.D != "" {
+		return nil, fmt.Errorf("private key in proof")
+	}
+	switch k.Kty {
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, errX := decodeBigInt(k.X)
+		y, errY := decodeBigInt(k.Y)
+		if errX != nil || errY != nil {
+			return nil, fmt.Errorf("invalid ec key")
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, fmt.Errorf("ec point isn't on curve")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	case "RSA":
+		n, errN := decodeBigInt(k.N)
+		e, errE := decodeBigInt(k.E)
+		if errN != nil || errE != nil || !e.IsInt64() {
+			return nil, fmt.Errorf("invalid rsa key")
+		}
+		if n.BitLen() < 2048 {
+			return nil, fmt.Errorf("rsa key is too short")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "OKP":
+		x, err := base64.RawURLEncoding.DecodeString(k.X)
+		if k.Crv != "Ed25519" || err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid okp key")
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+func decodeBigInt(value string) (*big.Int, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil || len(raw) == 0 {
+		return nil, fmt.Errorf("invalid number")
+	}
+	return new(big.Int).SetBytes(raw), nil
+}
+
+// PublicURL is the externally visible base url of the api, like https://auth.example.com.
+// When it is empty the url is taken from the request.
+var PublicURL string
+
+// requestURI returns the uri of r without query and fragment, as the client sees it.
+func requestURI(r *http.Request) string {
+	if PublicURL != "" {
+		return PublicURL + r.URL.Path
+	}
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+	return scheme + "://" + r.Host + r.URL.Path
+}
+
+// sameURI compares uris ignoring query, fragment and the case of scheme and host.
+func sameURI(htu string, uri string) bool {
+	htu, _, _ = strings.Cut(htu, "#")
+	htu, _, _ = strings.Cut(htu, "?")
+	htuScheme, htuRest, ok1 := strings.Cut(htu, "://")
+	uriScheme, uriRest, ok2 := strings.Cut(uri, "://")
+	if !ok1 || !ok2 || !strings.EqualFold(htuScheme, uriScheme) {
+		return false
+	}
+	htuHost, htuPath, _ := strings.Cut(htuRest, "/")
+	uriHost, uriPath, _ := strings.Cut(uriRest, "/")
+	return strings.EqualFold(htuHost, uriHost) && htuPath == uriPath
+}
+
+// proofKey verifies the optional proof of a token request and returns its key thumbprint.
+// boundJKT is the key the refreshed tokens are bound to, if any.
+func (d *DPoP) proofKey(r *http.Request, boundJKT string) (string, error) {
+	const op = "internal.server.handlers.auth.proofKey()"
+	jkt, err := d.VerifyProof(r, "")
+	if err == ErrDPoPProofMissing {
+		if d.Required || boundJKT != "" {
+			return "", fmt.Errorf("%s:%s", op, "dpop proof required")
+		}
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+	if boundJKT != "" && jkt != boundJKT {
+		return "", fmt.Errorf("%s:%s", op, "proof key doesn't match bound key")
+	}
+	return jkt, nil
+}
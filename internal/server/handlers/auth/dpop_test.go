@@ -0,0 +1,291 @@
+package auth
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/storage/memory"
+)
+
+// testTokenURL is the htu of token requests served by the test api.
+const testTokenURL = "http://example.com/tokenapi/v1/auth/token"
+
+type testDPoPKey struct {
+	key *ecdsa.PrivateKey
+}
+
+func newTestDPoPKey(t *testing.T) *testDPoPKey {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testDPoPKey{key: key}
+}
+
+func (k *testDPoPKey) jwk() map[string]string {
+	return map[string]string{
+		"kty": "EC",
+		"crv": "P-256",
+		"x":   base64.RawURLEncoding.EncodeToString(k.key.X.FillBytes(make([]byte, 32))),
+		"y":   base64.RawURLEncoding.EncodeToString(k.key.Y.FillBytes(make([]byte, 32))),
+	}
+}
+
+func (k *testDPoPKey) thumbprint(t *testing.T) string {
+	t.Helper()
+	jkt, err := jwk{Kty: "EC", Crv: "P-256", X: k.jwk()["x"], Y: k.jwk()["y"]}.thumbprint()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return jkt
+}
+
+// proof creates a valid proof for the request, change may break it before it is signed.
+func (k *testDPoPKey) proof(t *testing.T, htm string, htu string, change func(token *jwt.Token, claims *dpopClaims)) string {
+	t.Helper()
+	claims := &dpopClaims{
+		HTM: htm,
+		HTU: htu,
+		StandardClaims: jwt.StandardClaims{
+			Id:       uuid.NewString(),
+			IssuedAt: time.Now().Unix(),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+	token.Header["typ"] = "dpop+jwt"
+	token.Header["jwk"] = k.jwk()
+	var key interface{} = k.key
+	if change != nil {
+		change(token, claims)
+		if _, ok := token.Method.(*jwt.SigningMethodHMAC); ok {
+			key = []byte("shared secret")
+		}
+		if token.Method == jwt.SigningMethodNone {
+			key = jwt.UnsafeAllowNoneSignatureType
+		}
+	}
+	proof, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return proof
+}
+
+// withProof adds a proof for the token request of the test api.
+func withProof(t *testing.T, key *testDPoPKey) func(r *http.Request) {
+	return func(r *http.Request) {
+		r.Header.Set("DPoP", key.proof(t, r.Method, "http://"+r.Host+r.URL.Path, nil))
+	}
+}
+
+func accessTokenHash(accessToken string) string {
+	sum := sha256.Sum256([]byte(accessToken))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func TestVerifyProof(t *testing.T) {
+	key := newTestDPoPKey(t)
+	otherKey := newTestDPoPKey(t)
+
+	tests := []struct {
+		name   string
+		method string
+		htu    string
+		change func(token *jwt.Token, claims *dpopClaims)
+		valid  bool
+	}{
+		{name: "valid", valid: true},
+		{name: "htu with query and other host case", htu: "http://EXAMPLE.com/tokenapi/v1/auth/token?x=1", valid: true},
+		{name: "wrong htm", method: http.MethodGet},
+		{name: "wrong htu path", htu: "http://example.com/tokenapi/v1/auth/refresh"},
+		{name: "wrong htu host", htu: "http://evil.example/tokenapi/v1/auth/token"},
+		{name: "wrong htu scheme", htu: "https://example.com/tokenapi/v1/auth/token"},
+		{name: "stale iat", change: func(_ *jwt.Token, c *dpopClaims) { c.IssuedAt = time.Now().Add(-5 * time.Minute).Unix() }},
+		{name: "future iat", change: func(_ *jwt.Token, c *dpopClaims) { c.IssuedAt = time.Now().Add(5 * time.Minute).Unix() }},
+		{name: "no iat", change: func(_ *jwt.Token, c *dpopClaims) { c.IssuedAt = 0 }},
+		{name: "no jti", change: func(_ *jwt.Token, c *dpopClaims) { c.Id = "" }},
+		{name: "wrong typ", change: func(token *jwt.Token, _ *dpopClaims) { token.Header["typ"] = "JWT" }},
+		{name: "symmetric alg", change: func(token *jwt.Token, _ *dpopClaims) {
+			token.Method = jwt.SigningMethodHS256
+			token.Header["alg"] = "HS256"
+		}},
+		{name: "none alg", change: func(token *jwt.Token, _ *dpopClaims) {
+			token.Method = jwt.SigningMethodNone
+			token.Header["alg"] = "none"
+		}},
+		{name: "private key in jwk", change: func(token *jwt.Token, _ *dpopClaims) {
+			private := key.jwk()
+			private["d"] = base64.RawURLEncoding.EncodeToString(key.key.D.FillBytes(make([]byte, 32)))
+			token.Header["jwk"] = private
+		}},
+		{name: "jwk of another key", change: func(token *jwt.Token, _ *dpopClaims) { token.Header["jwk"] = otherKey.jwk() }},
+		{name: "no jwk", change: func(token *jwt.Token, _ *dpopClaims) { delete(token.Header, "jwk") }},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dpop := NewDPoP(memory.New())
+			dpop.ProofLifetime = 2 * time.Minute
+			method, htu := http.MethodPost, testTokenURL
+			if tt.method != "" {
+				method = tt.method
+			}
+			if tt.htu != "" {
+				htu = tt.htu
+			}
+			r := httptest.NewRequest(http.MethodPost, "/tokenapi/v1/auth/token", nil)
+			r.Header.Set("DPoP", key.proof(t, method, htu, tt.change))
+
+			jkt, err := dpop.VerifyProof(r, "")
+			if (err == nil) != tt.valid {
+				t.Fatalf("VerifyProof() error = %v, want valid %v", err, tt.valid)
+			}
+			if tt.valid && jkt != key.thumbprint(t) {
+				t.Fatalf("VerifyProof() = %s, want %s", jkt, key.thumbprint(t))
+			}
+		})
+	}
+}
+
+func TestVerifyProofReplay(t *testing.T) {
+	key := newTestDPoPKey(t)
+	dpop := NewDPoP(memory.New())
+	proof := key.proof(t, http.MethodPost, testTokenURL, nil)
+
+	for i, valid := range []bool{true, false} {
+		r := httptest.NewRequest(http.MethodPost, "/tokenapi/v1/auth/token", nil)
+		r.Header.Set("DPoP", proof)
+		_, err := dpop.VerifyProof(r, "")
+		if (err == nil) != valid {
+			t.Fatalf("use %d: error = %v, want valid %v", i+1, err, valid)
+		}
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/tokenapi/v1/auth/token", nil)
+	r.Header.Add("DPoP", proof)
+	r.Header.Add("DPoP", proof)
+	_, err := dpop.VerifyProof(r, "")
+	if err == nil {
+		t.Fatal("request with two proofs accepted")
+	}
+}
+
+func TestUseDPoPJTI(t *testing.T) {
+	store := memory.New()
+	exp := time.Now().Add(time.Minute)
+	for i, want := range []bool{true, false} {
+		fresh, err := store.UseDPoPJTI(context.Background(), "jkt:jti", exp)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if fresh != want {
+			t.Fatalf("use %d: fresh = %v, want %v", i+1, fresh, want)
+		}
+	}
+	fresh, err := store.UseDPoPJTI(context.Background(), "other-jkt:jti", exp)
+	if err != nil || !fresh {
+		t.Fatalf("jti of another key: fresh = %v, %v", fresh, err)
+	}
+}
+
+func TestVerifyBoundToken(t *testing.T) {
+	api := newTestAPI(t)
+	key := newTestDPoPKey(t)
+	otherKey := newTestDPoPKey(t)
+
+	code, tokens := api.issue(t, withProof(t, key))
+	if code != http.StatusOK {
+		t.Fatalf("issue: status %d", code)
+	}
+	if tokens.TokenType != TokenTypeDPoP {
+		t.Fatalf("token type %q, want %q", tokens.TokenType, TokenTypeDPoP)
+	}
+
+	const resourceURL = "http://example.com/resource"
+	tests := []struct {
+		name   string
+		scheme string
+		key    *testDPoPKey
+		change func(token *jwt.Token, claims *dpopClaims)
+		valid  bool
+	}{
+		{name: "bound key", scheme: TokenTypeDPoP, key: key, valid: true},
+		{name: "bearer scheme", scheme: TokenTypeBearer, key: key},
+		{name: "no proof", scheme: TokenTypeDPoP},
+		{name: "another key", scheme: TokenTypeDPoP, key: otherKey},
+		{name: "no ath", scheme: TokenTypeDPoP, key: key, change: func(_ *jwt.Token, c *dpopClaims) { c.ATH = "" }},
+		{name: "ath of another token", scheme: TokenTypeDPoP, key: key, change: func(_ *jwt.Token, c *dpopClaims) {
+			c.ATH = accessTokenHash(tokens.AccessToken + "x")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, resourceURL, nil)
+			r.Header.Set("Authorization", tt.scheme+" "+tokens.AccessToken)
+			if tt.key != nil {
+				r.Header.Set("DPoP", tt.key.proof(t, http.MethodGet, resourceURL, func(token *jwt.Token, claims *dpopClaims) {
+					claims.ATH = accessTokenHash(tokens.AccessToken)
+					if tt.change != nil {
+						tt.change(token, claims)
+					}
+				}))
+			}
+			claims, err := NewDPoP(api.store).VerifyBoundToken(r)
+			if (err == nil) != tt.valid {
+				t.Fatalf("VerifyBoundToken() error = %v, want valid %v", err, tt.valid)
+			}
+			if tt.valid && claims.Cnf.JKT != key.thumbprint(t) {
+				t.Fatalf("cnf.jkt = %s, want %s", claims.Cnf.JKT, key.thumbprint(t))
+			}
+		})
+	}
+}
+
+func TestRefreshBoundTokens(t *testing.T) {
+	api := newTestAPI(t)
+	key := newTestDPoPKey(t)
+	otherKey := newTestDPoPKey(t)
+
+	tests := []struct {
+		name    string
+		prepare func(r *http.Request)
+		want    int
+	}{
+		{"without proof", nil, http.StatusBadRequest},
+		{"another key", withProof(t, otherKey), http.StatusBadRequest},
+		{"bound key", withProof(t, key), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// rejected refresh tokens are deleted, so every case gets its own tokens
+			code, tokens := api.issue(t, withProof(t, key))
+			if code != http.StatusOK {
+				t.Fatalf("issue: status %d", code)
+			}
+			code, refreshed := api.refreshTokens(t, tokens, tt.prepare)
+			if code != tt.want {
+				t.Fatalf("refresh: status %d, want %d", code, tt.want)
+			}
+			if code != http.StatusOK {
+				return
+			}
+			claims, err := TokenValid(refreshed.AccessToken)
+			if err != nil {
+				t.Fatalf("refreshed access token: %v", err)
+			}
+			if claims.Cnf.jkt() != key.thumbprint(t) || refreshed.TokenType != TokenTypeDPoP {
+				t.Fatalf("refreshed token cnf = %+v, type %q, want the bound key", claims.Cnf, refreshed.TokenType)
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/client/notification"
+	"github.com/nabishec/tokenapi/internal/client/webhook"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/nabishec/tokenapi/internal/risk"
+	"github.com/nabishec/tokenapi/internal/storage/memory"
+)
+
+// testClientIP is the address test requests come from.
+const testClientIP = "203.0.113.7"
+
+type testAPI struct {
+	store    *memory.Memory
+	cookie   *RefreshCookie
+	issuance TokenIssuance
+	refresh  TokenRefresh
+	userID   uuid.UUID
+}
+
+// newTestAPI creates the token handlers over the memory storage with one user.
+func newTestAPI(t *testing.T) *testAPI {
+	t.Helper()
+	SigningKey = []byte("test signing key")
+	store := memory.New()
+	userID := uuid.New()
+	store.AddUser(userID, "user@example.com")
+
+	lockout := NewLockout(store)
+	engine := risk.NewEngine(store, nil)
+	dpop := NewDPoP(store)
+	cookie := &RefreshCookie{Path: "/tokenapi/v1/auth/refresh", SameSite: http.SameSiteStrictMode, Secure: true}
+	notifier := notification.NewOutbox(store)
+	events := webhook.NewEmitter(store)
+	return &testAPI{
+		store:    store,
+		cookie:   cookie,
+		issuance: NewTokenIssuance(store, lockout, engine, dpop, cookie, notifier, events),
+		refresh:  NewRefresh(store, lockout, engine, dpop, cookie, notifier, events),
+		userID:   userID,
+	}
+}
+
+// issue requests tokens for the user, prepare may change the request before it is served.
+func (a *testAPI) issue(t *testing.T, prepare func(r *http.Request)) (int, models.Tokens) {
+	t.Helper()
+	r := httptest.NewRequest(http.MethodPost, "/tokenapi/v1/auth/token?client_id="+a.userID.String(), nil)
+	r.RemoteAddr = testClientIP + ":40000"
+	if prepare != nil {
+		prepare(r)
+	}
+	w := httptest.NewRecorder()
+	a.issuance.ReturnToken(w, r)
+	return w.Code, decodeTokens(t, w)
+}
+
+// refreshTokens refreshes tokens, prepare may change the request before it is served.
+func (a *testAPI) refreshTokens(t *testing.T, tokens models.Tokens, prepare func(r *http.Request)) (int, models.Tokens) {
+	t.Helper()
+	w := a.serveRefresh(t, tokens, prepare)
+	return w.Code, decodeTokens(t, w)
+}
+
+// serveRefresh refreshes tokens and returns the whole response.
+func (a *testAPI) serveRefresh(t *testing.T, tokens models.Tokens, prepare func(r *http.Request)) *httptest.ResponseRecorder {
+	t.Helper()
+	body, err := json.Marshal(models.Tokens{AccessToken: tokens.AccessToken, RefreshToken: tokens.RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/tokenapi/v1/auth/refresh", bytes.NewReader(body))
+	r.RemoteAddr = testClientIP + ":40000"
+	if prepare != nil {
+		prepare(r)
+	}
+	w := httptest.NewRecorder()
+	a.refresh.RefreshToken(w, r)
+	return w
+}
+
+func tokensOf(accessToken string, refreshToken string) models.Tokens {
+	return models.Tokens{AccessToken: accessToken, RefreshToken: refreshToken}
+}
+
+func decodeTokens(t *testing.T, w *httptest.ResponseRecorder) models.Tokens {
+	t.Helper()
+	var tokens models.Tokens
+	if w.Code == http.StatusOK {
+		err := json.Unmarshal(w.Body.Bytes(), &tokens)
+		if err != nil {
+			t.Fatalf("decode tokens: %v", err)
+		}
+	}
+	return tokens
+}
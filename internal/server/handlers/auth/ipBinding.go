@@ -0,0 +1,99 @@
+package auth
+
+import (
+	"fmt"
+	"net"
+)
+
+// IPBinding is the policy applied when a refresh comes from another IP
+// than the one the tokens were issued to.
+type IPBinding string
+
+const (
+	// BindExact rejects the refresh and warns the user.
+	BindExact IPBinding = "exact"
+	// BindSubnet allows IPs of the same /24 (IPv4) or /64 (IPv6) network.
+	BindSubnet IPBinding = "subnet"
+	// BindASN allows IPs of the same autonomous system, e.g. of one mobile carrier.
+	BindASN IPBinding = "asn"
+	// BindNotify allows the refresh but warns the user.
+	BindNotify IPBinding = "notify"
+	// BindOff doesn't check the IP.
+	BindOff IPBinding = "off"
+)
+
+var IPBindingMode = BindExact
+
+// ASNFinder finds the autonomous system of an IP, it is required by BindASN.
+type ASNFinder interface {
+	ASN(ip net.IP) (uint, bool)
+}
+
+// ASNs is the database of autonomous systems used by BindASN.
+var ASNs ASNFinder
+
+func ParseIPBinding(value string) (IPBinding, error) {
+	const op = "internal.server.handlers.auth.ParseIPBinding()"
+	switch mode := IPBinding(value); mode {
+	case "":
+		return BindExact, nil
+	case BindExact, BindSubnet, BindASN, BindNotify, BindOff:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("%s:unknown ip binding %q", op, value)
+	}
+}
+
+// CheckIPBinding reports whether callerIP may refresh tokens bound to boundIP
+// and whether the user should be warned about it.
+func CheckIPBinding(mode IPBinding, boundIP string, callerIP string) (allowed bool, warn bool) {
+	switch mode {
+	case BindOff:
+		return true, false
+	case BindNotify:
+		return true, !SameIP(boundIP, callerIP)
+	case BindSubnet:
+		same := SameSubnet(boundIP, callerIP)
+		return same, !same
+	case BindASN:
+		same := SameIP(boundIP, callerIP) || SameASN(ASNs, boundIP, callerIP)
+		return same, !same
+	default:
+		same := SameIP(boundIP, callerIP)
+		return same, !same
+	}
+}
+
+// SameIP compares IPs regardless of their notation, e.g. ::ffff:1.2.3.4 equals 1.2.3.4.
+func SameIP(a string, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	return ipA != nil && ipB != nil && ipA.Equal(ipB)
+}
+
+// SameSubnet reports whether IPs are in the same /24 network for IPv4 or /64 for IPv6.
+func SameSubnet(a string, b string) bool {
+	ipA, ipB := net.ParseIP(a), net.ParseIP(b)
+	if ipA == nil || ipB == nil {
+		return false
+	}
+	if ipA4, ipB4 := ipA.To4(), ipB.To4(); ipA4 != nil || ipB4 != nil {
+		if ipA4 == nil || ipB4 == nil {
+			return false
+		}
+		mask := net.CIDRMask(24, 32)
+		return ipA4.Mask(mask).Equal(ipB4.Mask(mask))
+	}
+	mask := net.CIDRMask(64, 128)
+	return ipA.Mask(mask).Equal(ipB.Mask(mask))
+}
+
+// SameASN reports whether IPs belong to the same autonomous system.
+// IPs missing from the database are never in the same one.
+func SameASN(finder ASNFinder, a string, b string) bool {
+	if finder == nil {
+		return false
+	}
+	asnA, okA := finder.ASN(net.ParseIP(a))
+	asnB, okB := finder.ASN(net.ParseIP(b))
+	return okA && okB && asnA == asnB
+}
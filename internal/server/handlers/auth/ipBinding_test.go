@@ -0,0 +1,105 @@
+package auth
+
+import (
+	"net"
+	"testing"
+)
+
+// testASNs maps the /16 network of an IPv4 address to an autonomous system.
+type testASNs map[string]uint
+
+func (a testASNs) ASN(ip net.IP) (uint, bool) {
+	if ip.To4() == nil {
+		return 0, false
+	}
+	asn, ok := a[ip.Mask(net.CIDRMask(16, 32)).String()]
+	return asn, ok
+}
+
+func TestCheckIPBinding(t *testing.T) {
+	savedASNs := ASNs
+	ASNs = testASNs{"198.51.0.0": 64500, "203.0.0.0": 64500, "192.0.0.0": 64501}
+	t.Cleanup(func() { ASNs = savedASNs })
+
+	const bound = "198.51.100.7"
+	for _, tt := range []struct {
+		mode    IPBinding
+		caller  string
+		allowed bool
+		warn    bool
+	}{
+		{BindExact, bound, true, false},
+		{BindExact, "::ffff:198.51.100.7", true, false},
+		{BindExact, "198.51.100.8", false, true},
+		{BindSubnet, "198.51.100.200", true, false},
+		{BindSubnet, "198.51.101.7", false, true},
+		{BindASN, bound, true, false},
+		{BindASN, "198.51.7.1", true, false},
+		{BindASN, "203.0.113.7", true, false},
+		{BindASN, "192.0.2.1", false, true},
+		{BindASN, "10.0.0.1", false, true},
+		{BindNotify, bound, true, false},
+		{BindNotify, "192.0.2.1", true, true},
+		{BindOff, "192.0.2.1", true, false},
+		{BindOff, "not-an-ip", true, false},
+		{BindExact, "not-an-ip", false, true},
+	} {
+		allowed, warn := CheckIPBinding(tt.mode, bound, tt.caller)
+		if allowed != tt.allowed || warn != tt.warn {
+			t.Errorf("%s %s: allowed %v, warn %v, want %v, %v", tt.mode, tt.caller, allowed, warn, tt.allowed, tt.warn)
+		}
+	}
+}
+
+func TestCheckIPBindingWithoutASNs(t *testing.T) {
+	savedASNs := ASNs
+	ASNs = nil
+	t.Cleanup(func() { ASNs = savedASNs })
+
+	if allowed, _ := CheckIPBinding(BindASN, "198.51.100.7", "198.51.100.7"); !allowed {
+		t.Error("same IP rejected without an ASN database")
+	}
+	if allowed, warn := CheckIPBinding(BindASN, "198.51.100.7", "198.51.100.8"); allowed || !warn {
+		t.Errorf("other IP without an ASN database: allowed %v, warn %v", allowed, warn)
+	}
+}
+
+func TestSameSubnet(t *testing.T) {
+	for _, tt := range []struct {
+		a, b string
+		want bool
+	}{
+		{"198.51.100.1", "198.51.100.254", true},
+		{"198.51.100.1", "198.51.101.1", false},
+		{"::ffff:198.51.100.1", "198.51.100.2", true},
+		{"2001:db8:1:2::1", "2001:db8:1:2:ffff::1", true},
+		{"2001:db8:1:2::1", "2001:db8:1:3::1", false},
+		{"198.51.100.1", "::ffff:c633:6401", true},
+		{"198.51.100.1", "2001:db8::1", false},
+		{"198.51.100.1", "", false},
+	} {
+		if got := SameSubnet(tt.a, tt.b); got != tt.want {
+			t.Errorf("SameSubnet(%q, %q) = %v, want %v", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestParseIPBinding(t *testing.T) {
+	for value, want := range map[string]IPBinding{
+		"":       BindExact,
+		"exact":  BindExact,
+		"subnet": BindSubnet,
+		"asn":    BindASN,
+		"notify": BindNotify,
+		"off":    BindOff,
+	} {
+		got, err := ParseIPBinding(value)
+		if err != nil || got != want {
+			t.Errorf("ParseIPBinding(%q) = %q, %v, want %q", value, got, err, want)
+		}
+	}
+	_, err := ParseIPBinding("strict")
+	if err == nil {
+		t.Error("unknown binding was accepted")
+	}
+}
@@ -0,0 +1,310 @@
+package auth
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// Key management algorithms of encrypted tokens. Content is always encrypted with A256GCM.
+const (
+	JWEAlgRSAOAEP256 = "RSA-OAEP-256"
+	JWEAlgDir        = "dir"
+	jweEnc           = "A256GCM"
+)
+
+// maxAudienceLength limits the audience clients can ask tokens for.
+const maxAudienceLength = 256
+
+// JWEAudiences are the keys access tokens for the audiences are encrypted with.
+// Tokens for other audiences are only signed.
+var JWEAudiences = map[string]*JWEKey{}
+
+// JWEKey encrypts tokens with RSA-OAEP-256 or directly with a shared A256GCM key.
+// A key with only the public RSA part can encrypt but not decrypt.
+type JWEKey struct {
+	Alg     string
+	public  *rsa.PublicKey
+	private *rsa.PrivateKey
+	shared  []byte
+}
+
+type jweHeader struct {
+	Alg string `json:"alg"`
+	Enc string `json:"enc"`
+	Cty string `json:"cty,omitempty"`
+	Kid string `json:"kid,omitempty"`
+}
+
+// ParseJWEAudiences parses a comma separated list of audience=key pairs, see ParseJWEKey.
+func ParseJWEAudiences(list string) (map[string]*JWEKey, error) {
+	const op = "internal.server.handlers.auth.ParseJWEAudiences()"
+	audiences := map[string]*JWEKey{}
+	for _, value := range strings.Split(list, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		audience, keyValue, ok := strings.Cut(value, "=")
+		if !ok || audience == "" {
+			return nil, fmt.Errorf("%s:invalid audience %q", op, value)
+		}
+		key, err := ParseJWEKey(keyValue)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%w", op, err)
+		}
+		audiences[audience] = key
+	}
+	return audiences, nil
+}
+
+// ParseJWEKey parses "dir:<hex encoded 32 byte key>" or the path of a PEM file
+// with an RSA public or private key.
+func ParseJWEKey(value string) (*JWEKey, error) {
+	const op = "internal.server.handlers.auth.ParseJWEKey()"
+	if hexKey, found := strings.CutPrefix(value, "dir:"); found {
+		shared, err := hex.DecodeString(hexKey)
+		if err != nil || len(shared) != 32 {
+			return nil, fmt.Errorf("%s:%s", op, "dir key must be 32 hex encoded bytes")
+		}
+		return &JWEKey{Alg: JWEAlgDir, shared: shared}, nil
+	}
+
+	raw, err := os.ReadFile(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	block, _ := pem.Decode(raw)
+	if block == nil {
+		return nil, fmt.Errorf("%s:no pem data in %s", op, value)
+	}
+	key := &JWEKey{Alg: JWEAlgRSAOAEP256}
+	var parsed interface{}
+	switch block.Type {
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		parsed, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s:unsupported pem block %q", op, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	switch parsed := parsed.(type) {
+	case *rsa.PublicKey:
+		key.public = parsed
+	case *rsa.PrivateKey:
+		key.private = parsed
+		key.public = &parsed.PublicKey
+	default:
+		return nil, fmt.Errorf("%s:%s", op, "key isn't an rsa key")
+	}
+	if key.public.N.BitLen() < 2048 {
+		return nil, fmt.Errorf("%s:%s", op, "rsa key is too short")
+	}
+	return key, nil
+}
+
+// Encrypt wraps token in a compact JWE. kid names the key for the receiver and
+// cty is the content type of the token, "JWT" for nested JWTs.
+func (k *JWEKey) Encrypt(token string, kid string, cty string) (string, error) {
+	const op = "internal.server.handlers.auth.Encrypt()"
+	rawHeader, err := json.Marshal(jweHeader{Alg: k.Alg, Enc: jweEnc, Cty: cty, Kid: kid})
+	if err != nil {
+		return "", fmt.Errorf("%s:%w", op, err)
+	}
+	header := base64.RawURLEncoding.EncodeToString(rawHeader)
+
+	var contentKey, encryptedKey []byte
+	if k.Alg == JWEAlgDir {
+		contentKey = k.shared
+	} else {
+		contentKey = make([]byte, 32)
+		_, err = rand.Read(contentKey)
+		if err != nil {
+			return "", fmt.Errorf("%s:%w", op, err)
+		}
+		encryptedKey, err = rsa.EncryptOAEP(sha256.New(), rand.Reader, k.public, contentKey, nil)
+		if err != nil {
+			return "", fmt.Errorf("%s:%w", op, err)
+		}
+	}
+
+	gcm, err := newGCM(contentKey)
+	if err != nil {
+		return "", fmt.Errorf("%s:%w", op, err)
+	}
+	iv := make([]byte, gcm.NonceSize())
+	_, err = rand.Read(iv)
+	if err != nil {
+		return "", fmt.Errorf("%s:%w", op, err)
+	}
+	sealed := gcm.Seal(nil, iv, []byte(token), []byte(header))
+	ciphertext, tag := sealed[:len(sealed)-gcm.Overhead()], sealed[len(sealed)-gcm.Overhead():]
+
+	return strings.Join([]string{
+		header,
+		base64.RawURLEncoding.EncodeToString(encryptedKey),
+		base64.RawURLEncoding.EncodeToString(iv),
+		base64.RawURLEncoding.EncodeToString(ciphertext),
+		base64.RawURLEncoding.EncodeToString(tag),
+	}, "."), nil
+}
+
+// Decrypt returns the token wrapped in the compact JWE.
+func (k *JWEKey) Decrypt(token string) (string, error) {
+	const op = "internal.server.handlers.auth.Decrypt()"
+	parts := strings.Split(token, ".")
+	if len(parts) != 5 {
+		return "", fmt.Errorf("%s:%s", op, "token isn't a compact jwe")
+	}
+	header, err := parseJWEHeader(parts[0])
+	if err != nil {
+		return "", fmt.Errorf("%s:%w", op, err)
+	}
+	// the algorithm is fixed by the key, never chosen by the token
+	if header.Alg != k.Alg || header.Enc != jweEnc {
+		return "", fmt.Errorf("%s:unexpected algorithms %s/%s", op, header.Alg, header.Enc)
+	}
+	var decoded [4][]byte
+	for i, part := range parts[1:] {
+		decoded[i], err = base64.RawURLEncoding.DecodeString(part)
+		if err != nil {
+			return "", fmt.Errorf("%s:%s", op, "invalid jwe encoding")
+		}
+	}
+	encryptedKey, iv, ciphertext, tag := decoded[0], decoded[1], decoded[2], decoded[3]
+
+	var contentKey []byte
+	if k.Alg == JWEAlgDir {
+		if len(encryptedKey) != 0 {
+			return "", fmt.Errorf("%s:%s", op, "dir jwe with encrypted key")
+		}
+		contentKey = k.shared
+	} else {
+		if k.private == nil {
+			return "", fmt.Errorf("%s:%s", op, "no private key to decrypt")
+		}
+		contentKey, err = rsa.DecryptOAEP(sha256.New(), nil, k.private, encryptedKey, nil)
+		if err != nil {
+			return "", fmt.Errorf("%s:%s", op, "failed to decrypt content key")
+		}
+	}
+
+	gcm, err := newGCM(contentKey)
+	if err != nil {
+		return "", fmt.Errorf("%s:%w", op, err)
+	}
+	if len(iv) != gcm.NonceSize() || len(tag) != gcm.Overhead() {
+		return "", fmt.Errorf("%s:%s", op, "invalid iv or tag")
+	}
+	plaintext, err := gcm.Open(nil, iv, append(ciphertext, tag...), []byte(parts[0]))
+	if err != nil {
+		return "", fmt.Errorf("%s:%s", op, "failed to decrypt token")
+	}
+	return string(plaintext), nil
+}
+
+// DecryptAndVerify is meant for trusted resource servers holding the key of their audience.
+// It decrypts the token, verifies the wrapped token and checks that it was issued for the audience.
+func DecryptAndVerify(token string, audience string, key *JWEKey) (*JWTClaims, error) {
+	const op = "internal.server.handlers.auth.DecryptAndVerify()"
+	inner, err := key.Decrypt(token)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	if isJWE(inner) {
+		return nil, fmt.Errorf("%s:%s", op, "nested encryption")
+	}
+	claims, err := TokenValid(inner)
+	if err != nil && err != ErrAccessTokenExpired {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	if claims.Audience != audience {
+		return nil, fmt.Errorf("%s:%s", op, "token was issued for another audience")
+	}
+	return claims, err
+}
+
+// encryptForAudience encrypts the token if its audience asked for encrypted tokens.
+func encryptForAudience(token string, audience string, format TokenFormat) (string, error) {
+	key, ok := JWEAudiences[audience]
+	if !ok || audience == "" {
+		return token, nil
+	}
+	cty := ""
+	if _, ok := format.(JWT); ok {
+		cty = "JWT"
+	}
+	return key.Encrypt(token, audience, cty)
+}
+
+// decryptForAudience decrypts a token encrypted for one of JWEAudiences.
+func decryptForAudience(token string) (*JWTClaims, error) {
+	const op = "internal.server.handlers.auth.decryptForAudience()"
+	header, err := parseJWEHeader(strings.SplitN(token, ".", 2)[0])
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	key, ok := JWEAudiences[header.Kid]
+	if !ok {
+		return nil, fmt.Errorf("%s:unknown jwe key %q", op, header.Kid)
+	}
+	return DecryptAndVerify(token, header.Kid, key)
+}
+
+// jweAudience returns the audience a token was encrypted for without decrypting it,
+// audiences may have given only their public key.
+func jweAudience(token string) (string, error) {
+	const op = "internal.server.handlers.auth.jweAudience()"
+	header, err := parseJWEHeader(strings.SplitN(token, ".", 2)[0])
+	if err != nil {
+		return "", fmt.Errorf("%s:%w", op, err)
+	}
+	key, ok := JWEAudiences[header.Kid]
+	if !ok || header.Alg != key.Alg || header.Enc != jweEnc {
+		return "", fmt.Errorf("%s:unknown jwe key %q", op, header.Kid)
+	}
+	return header.Kid, nil
+}
+
+func parseJWEHeader(encoded string) (jweHeader, error) {
+	var header jweHeader
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return header, fmt.Errorf("invalid jwe header encoding")
+	}
+	err = json.Unmarshal(raw, &header)
+	if err != nil {
+		return header, fmt.Errorf("invalid jwe header")
+	}
+	return header, nil
+}
+
+// isJWE reports whether the token is in the five part compact JWE serialization.
+func isJWE(token string) bool {
+	return strings.Count(token, ".") == 4
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
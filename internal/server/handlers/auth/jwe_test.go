@@ -0,0 +1,157 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func newRSAJWEKey(t *testing.T) *JWEKey {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &JWEKey{Alg: JWEAlgRSAOAEP256, public: &private.PublicKey, private: private}
+}
+
+func newDirJWEKey(t *testing.T) *JWEKey {
+	t.Helper()
+	key, err := ParseJWEKey("dir:" + strings.Repeat("ab", 32))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+// useJWEAudiences sets JWEAudiences for the test.
+func useJWEAudiences(t *testing.T, audiences map[string]*JWEKey) {
+	t.Helper()
+	saved := JWEAudiences
+	JWEAudiences = audiences
+	t.Cleanup(func() { JWEAudiences = saved })
+}
+
+func TestJWERoundTrip(t *testing.T) {
+	for name, key := range map[string]*JWEKey{
+		JWEAlgDir:        newDirJWEKey(t),
+		JWEAlgRSAOAEP256: newRSAJWEKey(t),
+	} {
+		t.Run(name, func(t *testing.T) {
+			token, err := key.Encrypt("inner.token.value", "billing", "JWT")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !isJWE(token) {
+				t.Fatalf("token %q isn't a compact jwe", token)
+			}
+			header, err := parseJWEHeader(strings.Split(token, ".")[0])
+			if err != nil {
+				t.Fatal(err)
+			}
+			if header.Alg != name || header.Enc != jweEnc || header.Kid != "billing" || header.Cty != "JWT" {
+				t.Fatalf("unexpected header %+v", header)
+			}
+			inner, err := key.Decrypt(token)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inner != "inner.token.value" {
+				t.Fatalf("decrypted %q", inner)
+			}
+		})
+	}
+}
+
+func TestJWERejectsTamperedToken(t *testing.T) {
+	key := newRSAJWEKey(t)
+	token, err := key.Encrypt("inner.token.value", "billing", "JWT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+	ciphertext, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext[0] ^= 1
+	tampered := append([]string(nil), parts...)
+	tampered[3] = base64.RawURLEncoding.EncodeToString(ciphertext)
+	_, err = key.Decrypt(strings.Join(tampered, "."))
+	if err == nil {
+		t.Fatal("tampered ciphertext was decrypted")
+	}
+
+	// the header is authenticated too
+	tampered = append([]string(nil), parts...)
+	tampered[0] = base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RSA-OAEP-256","enc":"A256GCM","kid":"reports"}`))
+	_, err = key.Decrypt(strings.Join(tampered, "."))
+	if err == nil {
+		t.Fatal("token with a changed header was decrypted")
+	}
+}
+
+func TestJWERejectsAlgorithmMismatch(t *testing.T) {
+	dir := newDirJWEKey(t)
+	rsaKey := newRSAJWEKey(t)
+	token, err := rsaKey.Encrypt("inner.token.value", "billing", "JWT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = dir.Decrypt(token)
+	if err == nil {
+		t.Fatal("RSA-OAEP-256 token was decrypted with a dir key")
+	}
+
+	token, err = dir.Encrypt("inner.token.value", "billing", "JWT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, err = rsaKey.Decrypt(token)
+	if err == nil {
+		t.Fatal("dir token was decrypted with an RSA key")
+	}
+}
+
+func TestRefreshWithPublicOnlyJWEAudience(t *testing.T) {
+	key := newRSAJWEKey(t)
+	useJWEAudiences(t, map[string]*JWEKey{
+		"billing": {Alg: JWEAlgRSAOAEP256, public: key.public},
+		"reports": newDirJWEKey(t),
+	})
+	api := newTestAPI(t)
+	forAudience := func(audience string) func(r *http.Request) {
+		return func(r *http.Request) {
+			r.URL.RawQuery += "&audience=" + audience
+		}
+	}
+
+	code, tokens := api.issue(t, forAudience("billing"))
+	if code != http.StatusOK {
+		t.Fatalf("issue: status %d", code)
+	}
+	code, refreshed := api.refreshTokens(t, tokens, nil)
+	if code != http.StatusOK {
+		t.Fatalf("refresh: status %d", code)
+	}
+	claims, err := DecryptAndVerify(refreshed.AccessToken, "billing", key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != api.userID.String() || claims.Audience != "billing" {
+		t.Fatalf("unexpected claims %+v", claims)
+	}
+
+	// an access token of another audience doesn't belong to the refresh token
+	code, other := api.issue(t, forAudience("reports"))
+	if code != http.StatusOK {
+		t.Fatalf("issue: status %d", code)
+	}
+	code, _ = api.refreshTokens(t, tokensOf(other.AccessToken, refreshed.RefreshToken), nil)
+	if code != http.StatusBadRequest {
+		t.Fatalf("refresh with access token of another audience: status %d, want 400", code)
+	}
+}
@@ -0,0 +1,124 @@
+package auth
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/nabishec/tokenapi/internal/lib"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/rs/zerolog"
+)
+
+type LockoutStorage interface {
+	AddFailure(ctx context.Context, key string, window time.Duration) (int, error)
+	SetLockout(ctx context.Context, key string, until time.Time) error
+	GetLockout(ctx context.Context, key string) (time.Time, error)
+	DeleteFailures(ctx context.Context, key string) error
+}
+
+// Lockout counts failed attempts per user and per IP and locks them out
+// with exponential backoff once Threshold failures are reached.
+type Lockout struct {
+	storage   LockoutStorage
+	Threshold int
+	BaseDelay time.Duration
+	MaxDelay  time.Duration
+	Window    time.Duration
+}
+
+func NewLockout(storage LockoutStorage) *Lockout {
+	return &Lockout{
+		storage:   storage,
+		Threshold: lib.IntEnv("LOCKOUT_THRESHOLD", 5),
+		BaseDelay: lib.DurationEnv("LOCKOUT_BASE_DELAY", time.Minute),
+		MaxDelay:  lib.DurationEnv("LOCKOUT_MAX_DELAY", time.Hour),
+		Window:    lib.DurationEnv("LOCKOUT_WINDOW", 15*time.Minute),
+	}
+}
+
+func UserKey(userGUID string) string {
+	return "user:" + userGUID
+}
+
+func IPKey(userIP string) string {
+	return "ip:" + userIP
+}
+
+// RetryAfter returns how long the caller has to wait until none of keys is locked.
+func (l *Lockout) RetryAfter(ctx context.Context, keys ...string) (time.Duration, error) {
+	const op = "internal.server.handlers.auth.RetryAfter()"
+	var wait time.Duration
+	for _, key := range keys {
+		lockedUntil, err := l.storage.GetLockout(ctx, key)
+		if err != nil {
+			return 0, fmt.Errorf("%s:%w", op, err)
+		}
+		if d := time.Until(lockedUntil); d > wait {
+			wait = d
+		}
+	}
+	return wait, nil
+}
+
+// Fail registers a failed attempt for key. It returns the lockout duration
+// (zero if key isn't locked) and whether this failure is the one that locked key.
+func (l *Lockout) Fail(ctx context.Context, key string) (time.Duration, bool, error) {
+	const op = "internal.server.handlers.auth.Fail()"
+	failures, err := l.storage.AddFailure(ctx, key, l.Window)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s:%w", op, err)
+	}
+	if failures < l.Threshold {
+		return 0, false, nil
+	}
+
+	delay := l.delay(failures)
+	err = l.storage.SetLockout(ctx, key, time.Now().Add(delay))
+	if err != nil {
+		return 0, false, fmt.Errorf("%s:%w", op, err)
+	}
+	return delay, failures == l.Threshold, nil
+}
+
+func (l *Lockout) Reset(ctx context.Context, key string) error {
+	const op = "internal.server.handlers.auth.Reset()"
+	err := l.storage.DeleteFailures(ctx, key)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	return nil
+}
+
+// delay doubles BaseDelay for every failure over Threshold, up to MaxDelay.
+func (l *Lockout) delay(failures int) time.Duration {
+	exp := float64(failures - l.Threshold)
+	delay := float64(l.BaseDelay) * math.Pow(2, exp)
+	if delay > float64(l.MaxDelay) {
+		return l.MaxDelay
+	}
+	return time.Duration(delay)
+}
+
+// locked writes 429 and returns true if any of keys is locked.
+func (l *Lockout) locked(w http.ResponseWriter, r *http.Request, logs zerolog.Logger, keys ...string) bool {
+	wait, err := l.RetryAfter(r.Context(), keys...)
+	if err != nil {
+		// don't lock users out because of storage failures
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to check lockout")
+		return false
+	}
+	if wait <= 0 {
+		return false
+	}
+	logs.Error().Msgf("Locked out for %s", wait)
+
+	w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	w.WriteHeader(http.StatusTooManyRequests) // 429
+	render.JSON(w, r, models.StatusError("too many failed attempts"))
+	return true
+}
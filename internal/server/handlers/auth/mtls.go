@@ -0,0 +1,64 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/google/uuid"
+)
+
+var ErrForeignCertificate = errors.New("client certificate was issued to another user")
+
+// ClientCertificate returns the verified client certificate of the request or nil.
+func ClientCertificate(r *http.Request) *x509.Certificate {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	return r.TLS.VerifiedChains[0][0]
+}
+
+// CertificateThumbprint returns the x5t#S256 thumbprint of the certificate (RFC 8705).
+func CertificateThumbprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// CertificateClientID returns the user GUID the certificate was issued to, taken from its common name.
+func CertificateClientID(cert *x509.Certificate) (uuid.UUID, error) {
+	const op = "internal.server.handlers.auth.CertificateClientID()"
+	userGUID, err := uuid.Parse(cert.Subject.CommonName)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s:%s", op, "certificate common name isn't a user GUID")
+	}
+	return userGUID, nil
+}
+
+// VerifyCertificateBoundToken is meant for resource servers. It validates the access token
+// sent as "Authorization: Bearer <token>" and checks that it is bound to the client certificate.
+func VerifyCertificateBoundToken(r *http.Request) (*JWTClaims, error) {
+	const op = "internal.server.handlers.auth.VerifyCertificateBoundToken()"
+	accessToken, found := strings.CutPrefix(r.Header.Get("Authorization"), TokenTypeBearer+" ")
+	if !found {
+		return nil, fmt.Errorf("%s:%s", op, "no bearer access token")
+	}
+	claims, err := TokenValid(accessToken)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	if claims.Cnf == nil || claims.Cnf.X5T == "" {
+		return nil, fmt.Errorf("%s:%s", op, "access token isn't certificate bound")
+	}
+	cert := ClientCertificate(r)
+	if cert == nil {
+		return nil, fmt.Errorf("%s:%s", op, "no client certificate")
+	}
+	if CertificateThumbprint(cert) != claims.Cnf.X5T {
+		return nil, fmt.Errorf("%s:%s", op, "certificate doesn't match token")
+	}
+	return claims, nil
+}
@@ -0,0 +1,149 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net/http"
+	"testing"
+	"time"
+)
+
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "tokenapi test ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+// issue creates a client certificate with the common name and verifies it
+// against the CA the way the TLS server does.
+func (ca *testCA) issue(t *testing.T, commonName string, serial int64) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: commonName},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	_, err = cert.Verify(x509.VerifyOptions{Roots: roots, KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth}})
+	if err != nil {
+		t.Fatalf("verify client certificate: %v", err)
+	}
+	return cert
+}
+
+// withCertificate presents the verified client certificate in the request.
+func withCertificate(cert *x509.Certificate) func(r *http.Request) {
+	return func(r *http.Request) {
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+	}
+}
+
+func TestCertificateBoundTokens(t *testing.T) {
+	api := newTestAPI(t)
+	ca := newTestCA(t)
+	cert := ca.issue(t, api.userID.String(), 2)
+	// the same user, but another certificate
+	otherCert := ca.issue(t, api.userID.String(), 3)
+
+	code, tokens := api.issue(t, withCertificate(cert))
+	if code != http.StatusOK {
+		t.Fatalf("issue: status %d", code)
+	}
+	claims, err := TokenValid(tokens.AccessToken)
+	if err != nil {
+		t.Fatalf("access token: %v", err)
+	}
+	if claims.Cnf == nil || claims.Cnf.X5T != CertificateThumbprint(cert) {
+		t.Fatalf("access token cnf = %+v, want x5t#S256 %s", claims.Cnf, CertificateThumbprint(cert))
+	}
+
+	tests := []struct {
+		name    string
+		prepare func(r *http.Request)
+		want    int
+	}{
+		{"without certificate", nil, http.StatusBadRequest},
+		{"another certificate", withCertificate(otherCert), http.StatusBadRequest},
+		{"bound certificate", withCertificate(cert), http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// rejected refresh tokens are deleted, so every case gets its own tokens
+			code, tokens := api.issue(t, withCertificate(cert))
+			if code != http.StatusOK {
+				t.Fatalf("issue: status %d", code)
+			}
+			code, refreshed := api.refreshTokens(t, tokens, tt.prepare)
+			if code != tt.want {
+				t.Fatalf("refresh: status %d, want %d", code, tt.want)
+			}
+			if code != http.StatusOK {
+				return
+			}
+			claims, err := TokenValid(refreshed.AccessToken)
+			if err != nil {
+				t.Fatalf("refreshed access token: %v", err)
+			}
+			if claims.Cnf == nil || claims.Cnf.X5T != CertificateThumbprint(cert) {
+				t.Fatalf("refreshed access token cnf = %+v, want the bound certificate", claims.Cnf)
+			}
+		})
+	}
+}
+
+func TestCertificateOfAnotherUser(t *testing.T) {
+	api := newTestAPI(t)
+	ca := newTestCA(t)
+	foreign := ca.issue(t, "6f1c4a4e-3c1e-4e0a-9a51-8f0d1c2b3a4d", 2)
+
+	code, _ := api.issue(t, withCertificate(foreign))
+	if code != http.StatusForbidden {
+		t.Fatalf("issue with a certificate of another user: status %d, want %d", code, http.StatusForbidden)
+	}
+}
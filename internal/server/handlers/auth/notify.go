@@ -0,0 +1,67 @@
+package auth
+
+import (
+	"context"
+
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/client/notification"
+	"github.com/nabishec/tokenapi/internal/lib"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/rs/zerolog"
+)
+
+// UserContacts returns what is needed to notify the user.
+type UserContacts interface {
+	GetMail(ctx context.Context, userID uuid.UUID) (string, error)
+	GetLocale(ctx context.Context, userID uuid.UUID) (string, error)
+	GetNotificationPreferences(ctx context.Context, userID uuid.UUID) (*models.NotificationPreferences, error)
+}
+
+// notifyUser sends the message to the user with a "this wasn't me" link, failures are only logged.
+// Channels that don't need the mail still get the message if it can't be retrieved.
+func notifyUser(ctx context.Context, notifier notification.Notifier, contacts UserContacts, logs zerolog.Logger, msg notification.Message) {
+	err := sendToUser(ctx, notifier, contacts, logs, msg)
+	if err != nil {
+		logs.Error().Err(err).Msgf("Failed send %s message to user - %s", msg.Event, msg.UserID)
+	}
+}
+
+func sendToUser(ctx context.Context, notifier notification.Notifier, contacts UserContacts, logs zerolog.Logger, msg notification.Message) error {
+	return notifier.Notify(addressUser(ctx, contacts, logs, msg))
+}
+
+// pendingNotifications prepares messages about the tokens being saved, so that
+// they are added to the outbox in the same transaction as the tokens.
+func pendingNotifications(ctx context.Context, contacts UserContacts, logs zerolog.Logger, msgs []notification.Message) []models.Notification {
+	var notifications []models.Notification
+	for _, msg := range msgs {
+		n, err := notification.ToOutbox(addressUser(ctx, contacts, logs, msg))
+		if err != nil {
+			logs.Error().AnErr(lib.ErrReader(err)).Msgf("Failed to prepare %s message to user - %s", msg.Event, msg.UserID)
+			continue
+		}
+		notifications = append(notifications, n)
+	}
+	return notifications
+}
+
+func addressUser(ctx context.Context, contacts UserContacts, logs zerolog.Logger, msg notification.Message) notification.Message {
+	userMail, err := contacts.GetMail(ctx, msg.UserID)
+	if err != nil {
+		logs.Error().Err(err).Msgf("Failed to get mail of user - %s", msg.UserID)
+	}
+	locale, err := contacts.GetLocale(ctx, msg.UserID)
+	if err != nil {
+		logs.Error().Err(err).Msgf("Failed to get locale of user - %s", msg.UserID)
+	}
+	// without the preferences the message goes to the default channels
+	preferences, err := contacts.GetNotificationPreferences(ctx, msg.UserID)
+	if err != nil {
+		logs.Error().Err(err).Msgf("Failed to get notification preferences of user - %s", msg.UserID)
+	}
+	msg.Email = userMail
+	msg.Locale = locale
+	msg.RevokeURL = RevokeLink(msg.UserID)
+	msg.Preferences = preferences
+	return msg
+}
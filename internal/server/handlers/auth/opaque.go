@@ -0,0 +1,80 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Formats of issued refresh tokens.
+const (
+	// RefreshFormatJWT is the base64 encoded signed JWT with the user IP.
+	RefreshFormatJWT = "jwt"
+	// RefreshFormatOpaque is a random selector.verifier pair without any payload.
+	RefreshFormatOpaque = "opaque"
+)
+
+// RefreshFormat is the format of issued refresh tokens.
+// Tokens of both formats are accepted on refresh regardless of it.
+var RefreshFormat = RefreshFormatJWT
+
+func ParseRefreshFormat(value string) (string, error) {
+	const op = "internal.server.handlers.auth.ParseRefreshFormat()"
+	switch strings.ToLower(value) {
+	case "", RefreshFormatJWT:
+		return RefreshFormatJWT, nil
+	case RefreshFormatOpaque:
+		return RefreshFormatOpaque, nil
+	default:
+		return "", fmt.Errorf("%s:unknown refresh token format %q", op, value)
+	}
+}
+
+// Random bytes of an opaque token. The selector is stored as is to find the token,
+// so all the guessing resistance is in the verifier stored as a hash.
+const (
+	selectorSize = 16
+	verifierSize = 32
+)
+
+// CreateOpaqueRefresh creates an opaque refresh token and returns it
+// together with its selector and the hash of its verifier.
+func CreateOpaqueRefresh() (token string, selector string, verifierHash string, err error) {
+	const op = "internal.server.handlers.auth.CreateOpaqueRefresh()"
+	random := make([]byte, selectorSize+verifierSize)
+	_, err = rand.Read(random)
+	if err != nil {
+		return "", "", "", fmt.Errorf("%s:%w", op, err)
+	}
+	selector = base64.RawURLEncoding.EncodeToString(random[:selectorSize])
+	verifier := base64.RawURLEncoding.EncodeToString(random[selectorSize:])
+	return selector + "." + verifier, selector, HashVerifier(verifier), nil
+}
+
+// SplitOpaqueRefresh splits an opaque token into selector and verifier.
+// ok is false for JWT refresh tokens, whose base64 form has no dot.
+func SplitOpaqueRefresh(token string) (selector string, verifier string, ok bool) {
+	selector, verifier, ok = strings.Cut(token, ".")
+	if !ok || selector == "" || verifier == "" {
+		return "", "", false
+	}
+	return selector, verifier, true
+}
+
+func HashVerifier(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return hex.EncodeToString(sum[:])
+}
+
+// CheckVerifier compares the verifier with the saved hash in constant time.
+func CheckVerifier(verifierHash string, verifier string) error {
+	const op = "internal.server.handlers.auth.CheckVerifier()"
+	if subtle.ConstantTimeCompare([]byte(verifierHash), []byte(HashVerifier(verifier))) != 1 {
+		return fmt.Errorf("%s:%s", op, "verifier doesn't match")
+	}
+	return nil
+}
@@ -0,0 +1,70 @@
+package auth
+
+import (
+	"context"
+	"encoding/base64"
+	"net/http"
+	"testing"
+)
+
+func TestOpaqueRefresh(t *testing.T) {
+	api := newTestAPI(t)
+	RefreshFormat = RefreshFormatOpaque
+	t.Cleanup(func() { RefreshFormat = RefreshFormatJWT })
+
+	code, tokens := api.issue(t, nil)
+	if code != http.StatusOK {
+		t.Fatalf("issue: status %d", code)
+	}
+	selector, _, ok := SplitOpaqueRefresh(tokens.RefreshToken)
+	if !ok {
+		t.Fatalf("refresh token %q isn't opaque", tokens.RefreshToken)
+	}
+	saved, err := api.store.GetTokenBySelector(context.Background(), selector)
+	if err != nil {
+		t.Fatalf("saved token by selector: %v", err)
+	}
+	if saved.Selector != selector || saved.Hash == selector {
+		t.Fatalf("saved selector %q and hash %q are mixed up", saved.Selector, saved.Hash)
+	}
+
+	code, refreshed := api.refreshTokens(t, tokens, nil)
+	if code != http.StatusOK {
+		t.Fatalf("refresh: status %d, want %d", code, http.StatusOK)
+	}
+	if _, _, ok := SplitOpaqueRefresh(refreshed.RefreshToken); !ok {
+		t.Fatalf("refreshed token %q isn't opaque", refreshed.RefreshToken)
+	}
+
+	code, _ = api.refreshTokens(t, refreshed, nil)
+	if code != http.StatusOK {
+		t.Fatalf("second refresh: status %d, want %d", code, http.StatusOK)
+	}
+	code, _ = api.refreshTokens(t, tokens, nil)
+	if code != http.StatusNotFound {
+		t.Fatalf("reused refresh: status %d, want %d", code, http.StatusNotFound)
+	}
+}
+
+func TestCreateOpaqueRefresh(t *testing.T) {
+	token, selector, verifierHash, err := CreateOpaqueRefresh()
+	if err != nil {
+		t.Fatal(err)
+	}
+	gotSelector, verifier, ok := SplitOpaqueRefresh(token)
+	if !ok || gotSelector != selector {
+		t.Fatalf("token %q doesn't start with selector %q", token, selector)
+	}
+	for part, size := range map[string]int{selector: 16, verifier: 32} {
+		raw, err := base64.RawURLEncoding.DecodeString(part)
+		if err != nil || len(raw) != size {
+			t.Fatalf("%q has %d random bytes, want %d", part, len(raw), size)
+		}
+	}
+	if err := CheckVerifier(verifierHash, verifier); err != nil {
+		t.Fatal(err)
+	}
+	if err := CheckVerifier(verifierHash, selector); err == nil {
+		t.Fatal("selector accepted as verifier")
+	}
+}
@@ -0,0 +1,308 @@
+package auth
+
+import (
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"golang.org/x/crypto/blake2b"
+	"golang.org/x/crypto/chacha20"
+)
+
+// LoadPASETOKeys adds the PASETO formats whose hex encoded keys are set: the 32 byte
+// Ed25519 seed of v4.public and the 32 byte symmetric key of v4.local.
+func LoadPASETOKeys(publicSeed string, localKey string) error {
+	const op = "internal.server.handlers.auth.LoadPASETOKeys()"
+	if publicSeed != "" {
+		seed, err := hex.DecodeString(publicSeed)
+		if err != nil || len(seed) != ed25519.SeedSize {
+			return fmt.Errorf("%s:%s", op, "v4.public key must be a hex encoded 32 byte seed")
+		}
+		TokenFormats[FormatPASETOPublic] = PASETOPublic{key: ed25519.NewKeyFromSeed(seed)}
+	}
+	if localKey != "" {
+		key, err := hex.DecodeString(localKey)
+		if err != nil || len(key) != 32 {
+			return fmt.Errorf("%s:%s", op, "v4.local key must be 32 hex encoded bytes")
+		}
+		TokenFormats[FormatPASETOLocal] = PASETOLocal{key: key}
+	}
+	return nil
+}
+
+// pasetoClaims is the payload of PASETO tokens, times are RFC 3339 strings as the spec requires.
+type pasetoClaims struct {
+	UserIP    string        `json:"user_ip,omitempty"`
+	Cnf       *Confirmation `json:"cnf,omitempty"`
+	SessionID string        `json:"sid,omitempty"`
+	Subject   string        `json:"sub,omitempty"`
+	Audience  string        `json:"aud,omitempty"`
+	ID        string        `json:"jti,omitempty"`
+	IssuedAt  string        `json:"iat,omitempty"`
+	ExpiresAt string        `json:"exp,omitempty"`
+}
+
+func marshalPASETOClaims(claims *JWTClaims) ([]byte, error) {
+	payload := pasetoClaims{
+		UserIP:    claims.UserIP,
+		Cnf:       claims.Cnf,
+		SessionID: claims.SessionID,
+		Subject:   claims.Subject,
+		Audience:  claims.Audience,
+		ID:        claims.Id,
+	}
+	if claims.IssuedAt != 0 {
+		payload.IssuedAt = time.Unix(claims.IssuedAt, 0).UTC().Format(time.RFC3339)
+	}
+	if claims.ExpiresAt != 0 {
+		payload.ExpiresAt = time.Unix(claims.ExpiresAt, 0).UTC().Format(time.RFC3339)
+	}
+	return json.Marshal(payload)
+}
+
+func unmarshalPASETOClaims(raw []byte) (*JWTClaims, error) {
+	var payload pasetoClaims
+	err := json.Unmarshal(raw, &payload)
+	if err != nil {
+		return nil, err
+	}
+	claims := &JWTClaims{
+		UserIP:    payload.UserIP,
+		Cnf:       payload.Cnf,
+		SessionID: payload.SessionID,
+		StandardClaims: jwt.StandardClaims{
+			Subject:  payload.Subject,
+			Audience: payload.Audience,
+			Id:       payload.ID,
+		},
+	}
+	if payload.IssuedAt != "" {
+		issuedAt, err := time.Parse(time.RFC3339, payload.IssuedAt)
+		if err != nil {
+			return nil, err
+		}
+		claims.IssuedAt = issuedAt.Unix()
+	}
+	if payload.ExpiresAt != "" {
+		expiresAt, err := time.Parse(time.RFC3339, payload.ExpiresAt)
+		if err != nil {
+			return nil, err
+		}
+		claims.ExpiresAt = expiresAt.Unix()
+	}
+	return claims, nil
+}
+
+// PASETOPublic is the v4.public format, signed with Ed25519.
+type PASETOPublic struct {
+	key ed25519.PrivateKey
+}
+
+func (f PASETOPublic) Sign(claims *JWTClaims) (string, error) {
+	const op = "internal.server.handlers.auth.Sign()"
+	message, err := marshalPASETOClaims(claims)
+	if err != nil {
+		return "", fmt.Errorf("%s:%w", op, err)
+	}
+	return f.sign(message, nil, nil), nil
+}
+
+func (f PASETOPublic) Parse(token string) (*JWTClaims, error) {
+	const op = "internal.server.handlers.auth.Parse()"
+	message, err := f.verify(token, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	claims, err := unmarshalPASETOClaims(message)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s", op, "invalid payload")
+	}
+	return claims, nil
+}
+
+// sign creates the token with the message, the optional footer and implicit assertion.
+func (f PASETOPublic) sign(message []byte, footer []byte, implicit []byte) string {
+	const header = FormatPASETOPublic + "."
+	signature := ed25519.Sign(f.key, pae([]byte(header), message, footer, implicit))
+	body := append(append([]byte(nil), message...), signature...)
+	return header + base64.RawURLEncoding.EncodeToString(body) + encodeFooter(footer)
+}
+
+// verify checks the signature of the token and returns its message.
+func (f PASETOPublic) verify(token string, implicit []byte) ([]byte, error) {
+	const header = FormatPASETOPublic + "."
+	body, footer, err := splitPASETO(token, header)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < ed25519.SignatureSize {
+		return nil, fmt.Errorf("token is too short")
+	}
+	message := body[:len(body)-ed25519.SignatureSize]
+	signature := body[len(body)-ed25519.SignatureSize:]
+	publicKey := f.key.Public().(ed25519.PublicKey)
+	if !ed25519.Verify(publicKey, pae([]byte(header), message, footer, implicit), signature) {
+		return nil, fmt.Errorf("invalid signature")
+	}
+	return message, nil
+}
+
+// PASETOLocal is the v4.local format, encrypted with XChaCha20 and authenticated with BLAKE2b.
+type PASETOLocal struct {
+	key []byte
+}
+
+func (f PASETOLocal) Sign(claims *JWTClaims) (string, error) {
+	const op = "internal.server.handlers.auth.Sign()"
+	message, err := marshalPASETOClaims(claims)
+	if err != nil {
+		return "", fmt.Errorf("%s:%w", op, err)
+	}
+	nonce := make([]byte, 32)
+	_, err = rand.Read(nonce)
+	if err != nil {
+		return "", fmt.Errorf("%s:%w", op, err)
+	}
+	token, err := f.encrypt(message, nonce, nil, nil)
+	if err != nil {
+		return "", fmt.Errorf("%s:%w", op, err)
+	}
+	return token, nil
+}
+
+func (f PASETOLocal) Parse(token string) (*JWTClaims, error) {
+	const op = "internal.server.handlers.auth.Parse()"
+	message, err := f.decrypt(token, nil)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	claims, err := unmarshalPASETOClaims(message)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%s", op, "invalid payload")
+	}
+	return claims, nil
+}
+
+// encrypt creates the token with the message, the random 32 byte nonce,
+// the optional footer and implicit assertion.
+func (f PASETOLocal) encrypt(message []byte, nonce []byte, footer []byte, implicit []byte) (string, error) {
+	const header = FormatPASETOLocal + "."
+	encryptionKey, counterNonce, authKey, err := f.splitKey(nonce)
+	if err != nil {
+		return "", err
+	}
+	cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return "", err
+	}
+	ciphertext := make([]byte, len(message))
+	cipher.XORKeyStream(ciphertext, message)
+	tag, err := blake2bMAC(authKey, pae([]byte(header), nonce, ciphertext, footer, implicit))
+	if err != nil {
+		return "", err
+	}
+	body := append(append(append([]byte(nil), nonce...), ciphertext...), tag...)
+	return header + base64.RawURLEncoding.EncodeToString(body) + encodeFooter(footer), nil
+}
+
+// decrypt checks the authentication tag of the token and returns its message.
+func (f PASETOLocal) decrypt(token string, implicit []byte) ([]byte, error) {
+	const header = FormatPASETOLocal + "."
+	body, footer, err := splitPASETO(token, header)
+	if err != nil {
+		return nil, err
+	}
+	if len(body) < 64 {
+		return nil, fmt.Errorf("token is too short")
+	}
+	nonce := body[:32]
+	ciphertext := body[32 : len(body)-32]
+	tag := body[len(body)-32:]
+	encryptionKey, counterNonce, authKey, err := f.splitKey(nonce)
+	if err != nil {
+		return nil, err
+	}
+	expected, err := blake2bMAC(authKey, pae([]byte(header), nonce, ciphertext, footer, implicit))
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal(tag, expected) {
+		return nil, fmt.Errorf("invalid authentication tag")
+	}
+	cipher, err := chacha20.NewUnauthenticatedCipher(encryptionKey, counterNonce)
+	if err != nil {
+		return nil, err
+	}
+	message := make([]byte, len(ciphertext))
+	cipher.XORKeyStream(message, ciphertext)
+	return message, nil
+}
+
+// splitKey derives the encryption key, the XChaCha20 nonce and the authentication key from the token nonce.
+func (f PASETOLocal) splitKey(nonce []byte) ([]byte, []byte, []byte, error) {
+	hash, err := blake2b.New(56, f.key)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	hash.Write([]byte("paseto-encryption-key"))
+	hash.Write(nonce)
+	derived := hash.Sum(nil)
+	authKey, err := blake2bMAC(f.key, append([]byte("paseto-auth-key-for-aead"), nonce...))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	return derived[:32], derived[32:], authKey, nil
+}
+
+func blake2bMAC(key []byte, message []byte) ([]byte, error) {
+	hash, err := blake2b.New256(key)
+	if err != nil {
+		return nil, err
+	}
+	hash.Write(message)
+	return hash.Sum(nil), nil
+}
+
+// splitPASETO checks the header of the token and decodes its body and optional footer.
+func splitPASETO(token string, header string) ([]byte, []byte, error) {
+	rest, found := strings.CutPrefix(token, header)
+	if !found {
+		return nil, nil, fmt.Errorf("unexpected token header")
+	}
+	encodedBody, encodedFooter, _ := strings.Cut(rest, ".")
+	body, err := base64.RawURLEncoding.DecodeString(encodedBody)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid token encoding")
+	}
+	footer, err := base64.RawURLEncoding.DecodeString(encodedFooter)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid footer encoding")
+	}
+	return body, footer, nil
+}
+
+// encodeFooter returns the footer part of a token, empty when there is no footer.
+func encodeFooter(footer []byte) string {
+	if len(footer) == 0 {
+		return ""
+	}
+	return "." + base64.RawURLEncoding.EncodeToString(footer)
+}
+
+// pae is the pre-authentication encoding of PASETO.
+func pae(pieces ...[]byte) []byte {
+	output := binary.LittleEndian.AppendUint64(nil, uint64(len(pieces)))
+	for _, piece := range pieces {
+		output = binary.LittleEndian.AppendUint64(output, uint64(len(piece))&(1<<63-1))
+		output = append(output, piece...)
+	}
+	return output
+}
@@ -0,0 +1,155 @@
+package auth
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/hex"
+	"testing"
+)
+
+// Test vectors of the PASETO specification, https://github.com/paseto-standard/test-vectors/blob/master/v4.json
+
+const (
+	vectorLocalKey  = "707172737475767778797a7b7c7d7e7f808182838485868788898a8b8c8d8e8f"
+	vectorSecretKey = "b4cbfb43df4ce210727d953e4a713307fa19bb7d9f85041438d9e11b942a3774" +
+		"1eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2"
+	vectorPublicKey = "1eb9dbbbbc047c03fd70604e0071f0987e16b28b757225c11f00415d0e20b1a2"
+	vectorKeyFooter = `{"kid":"zVhMiPBP9fRf2snEcT7gFTioeA9COcNy9DfgL1W60haN"}`
+)
+
+var localVectors = []struct {
+	name     string
+	nonce    string
+	payload  string
+	footer   string
+	implicit string
+	token    string
+}{
+	{
+		name:    "4-E-1",
+		nonce:   "0000000000000000000000000000000000000000000000000000000000000000",
+		payload: `{"data":"this is a secret message","exp":"2022-01-01T00:00:00+00:00"}`,
+		token:   "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvSwscFlAl1pk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XJ5hOb_4v9RmDkneN0S92dx0OW4pgy7omxgf3S8c3LlQg",
+	},
+	{
+		name:    "4-E-2",
+		nonce:   "0000000000000000000000000000000000000000000000000000000000000000",
+		payload: `{"data":"this is a hidden message","exp":"2022-01-01T00:00:00+00:00"}`,
+		token:   "v4.local.AAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAQAr68PS4AXe7If_ZgesdkUMvS2csCgglvpk5HC0e8kApeaqMfGo_7OpBnwJOAbY9V7WU6abu74MmcUE8YWAiaArVI8XIemu9chy3WVKvRBfg6t8wwYHK0ArLxxfZP73W_vfwt5A",
+	},
+	{
+		name:    "4-E-3",
+		nonce:   "df654812bac492663825520ba2f6e67cf5ca5bdc13d4e7507a98cc4c2fcc3ad8",
+		payload: `{"data":"this is a secret message","exp":"2022-01-01T00:00:00+00:00"}`,
+		token:   "v4.local.32VIErrEkmY4JVILovbmfPXKW9wT1OdQepjMTC_MOtjA4kiqw7_tcaOM5GNEcnTxl60WkwMsYXw6FSNb_UdJPXjpzm0KW9ojM5f4O2mRvE2IcweP-PRdoHjd5-RHCiExR1IK6t6-tyebyWG6Ov7kKvBdkrrAJ837lKP3iDag2hzUPHuMKA",
+	},
+}
+
+var publicVectors = []struct {
+	name     string
+	payload  string
+	footer   string
+	implicit string
+	token    string
+}{
+	{
+		name:    "4-S-1",
+		payload: `{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`,
+		token:   "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9bg_XBBzds8lTZShVlwwKSgeKpLT3yukTw6JUz3W4h_ExsQV-P0V54zemZDcAxFaSeef1QlXEFtkqxT1ciiQEDA",
+	},
+	{
+		name:    "4-S-2",
+		payload: `{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`,
+		footer:  vectorKeyFooter,
+		token:   "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9v3Jt8mx_TdM2ceTGoqwrh4yDFn0XsHvvV_D0DtwQxVrJEBMl0F2caAdgnpKlt4p7xBnx1HcO-SPo8FPp214HDw.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+	},
+	{
+		name:     "4-S-3",
+		payload:  `{"data":"this is a signed message","exp":"2022-01-01T00:00:00+00:00"}`,
+		footer:   vectorKeyFooter,
+		implicit: `{"test-vector":"4-S-3"}`,
+		token:    "v4.public.eyJkYXRhIjoidGhpcyBpcyBhIHNpZ25lZCBtZXNzYWdlIiwiZXhwIjoiMjAyMi0wMS0wMVQwMDowMDowMCswMDowMCJ9NPWciuD3d0o5eXJXG5pJy-DiVEoyPYWs1YSTwWHNJq6DZD3je5gf-0M4JR9ipdUSJbIovzmBECeaWmaqcaP0DQ.eyJraWQiOiJ6VmhNaVBCUDlmUmYyc25FY1Q3Z0ZUaW9lQTlDT2NOeTlEZmdMMVc2MGhhTiJ9",
+	},
+}
+
+func decodeHex(t *testing.T, value string) []byte {
+	t.Helper()
+	b, err := hex.DecodeString(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+func TestPASETOLocalVectors(t *testing.T) {
+	format := PASETOLocal{key: decodeHex(t, vectorLocalKey)}
+	for _, v := range localVectors {
+		t.Run(v.name, func(t *testing.T) {
+			token, err := format.encrypt([]byte(v.payload), decodeHex(t, v.nonce), []byte(v.footer), []byte(v.implicit))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if token != v.token {
+				t.Fatalf("encrypt:\n got %s\nwant %s", token, v.token)
+			}
+			message, err := format.decrypt(v.token, []byte(v.implicit))
+			if err != nil {
+				t.Fatalf("decrypt: %v", err)
+			}
+			if !bytes.Equal(message, []byte(v.payload)) {
+				t.Fatalf("decrypt: got %s, want %s", message, v.payload)
+			}
+		})
+	}
+}
+
+func TestPASETOPublicVectors(t *testing.T) {
+	secretKey := ed25519.PrivateKey(decodeHex(t, vectorSecretKey))
+	// the key is loaded from the seed, as LoadPASETOKeys does
+	format := PASETOPublic{key: ed25519.NewKeyFromSeed(secretKey.Seed())}
+	if !bytes.Equal(format.key.Public().(ed25519.PublicKey), decodeHex(t, vectorPublicKey)) {
+		t.Fatal("public key derived from the seed doesn't match the vector")
+	}
+	for _, v := range publicVectors {
+		t.Run(v.name, func(t *testing.T) {
+			token := format.sign([]byte(v.payload), []byte(v.footer), []byte(v.implicit))
+			if token != v.token {
+				t.Fatalf("sign:\n got %s\nwant %s", token, v.token)
+			}
+			message, err := format.verify(v.token, []byte(v.implicit))
+			if err != nil {
+				t.Fatalf("verify: %v", err)
+			}
+			if !bytes.Equal(message, []byte(v.payload)) {
+				t.Fatalf("verify: got %s, want %s", message, v.payload)
+			}
+		})
+	}
+}
+
+func TestPASETORejectsModifiedTokens(t *testing.T) {
+	local := PASETOLocal{key: decodeHex(t, vectorLocalKey)}
+	public := PASETOPublic{key: ed25519.NewKeyFromSeed(decodeHex(t, vectorSecretKey)[:ed25519.SeedSize])}
+	localToken := localVectors[0].token
+	publicToken := publicVectors[2].token
+
+	if _, err := local.decrypt(localToken, []byte(`{"test-vector":"4-E-1"}`)); err == nil {
+		t.Error("v4.local accepted with a wrong implicit assertion")
+	}
+	if _, err := local.decrypt(localToken[:len(localToken)-2]+"AA", nil); err == nil {
+		t.Error("v4.local accepted with a modified tag")
+	}
+	if _, err := local.decrypt(localToken+"."+"eyJraWQiOiJvdGhlciJ9", nil); err == nil {
+		t.Error("v4.local accepted with an added footer")
+	}
+	if _, err := public.verify(publicToken, nil); err == nil {
+		t.Error("v4.public accepted without the implicit assertion")
+	}
+	if _, err := public.verify("v4.local."+publicToken[len("v4.public."):], []byte(publicVectors[2].implicit)); err == nil {
+		t.Error("v4.public accepted with another header")
+	}
+	// 4-F-1 style: a local key must not verify public tokens
+	if _, err := local.decrypt(publicVectors[0].token, nil); err == nil {
+		t.Error("v4.local accepted a v4.public token")
+	}
+}
@@ -0,0 +1,140 @@
+package auth
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt"
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/models"
+)
+
+func TestRefreshRejectsRefreshJWTAsAccessToken(t *testing.T) {
+	api := newTestAPI(t)
+	code, tokens := api.issue(t, nil)
+	if code != http.StatusOK {
+		t.Fatalf("issue: status %d", code)
+	}
+	refreshJWT, err := DecodeRefresh(tokens.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the refresh JWT is signed with the same key but has no subject
+	code, _ = api.refreshTokens(t, tokensOf(refreshJWT, tokens.RefreshToken), nil)
+	if code != http.StatusBadRequest {
+		t.Fatalf("refresh: status %d, want %d", code, http.StatusBadRequest)
+	}
+	if _, err := TokenValid(refreshJWT); err == nil {
+		t.Fatal("refresh JWT accepted as access token")
+	}
+}
+
+func TestRefreshBaselineTokens(t *testing.T) {
+	api := newTestAPI(t)
+
+	// tokens issued before the upgrade: the access token has no iat and no aud,
+	// the refresh JWT has a random jti and is saved without a selector
+	jti := uuid.NewString()
+	accessToken, err := jwt.NewWithClaims(jwt.SigningMethodHS512, JWTClaims{
+		UserIP: testClientIP,
+		StandardClaims: jwt.StandardClaims{
+			Id:        jti,
+			Subject:   api.userID.String(),
+			ExpiresAt: time.Now().Add(-time.Minute).Unix(),
+		},
+	}).SignedString(SigningKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshJWT, err := jwt.NewWithClaims(jwt.SigningMethodHS256, JWTClaims{
+		UserIP:         testClientIP,
+		StandardClaims: jwt.StandardClaims{Id: uuid.NewString()},
+	}).SignedString(SigningKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	refHash, err := CreateHashRef(refreshJWT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = api.store.AddNewToken(context.Background(), models.RefreshToken{
+		Hash:      refHash,
+		UserID:    api.userID,
+		IP:        testClientIP,
+		JTI:       jti,
+		Exp:       time.Now().Add(RefreshTokenLifetime),
+		SessionID: uuid.New(),
+		CreatedAt: time.Now().Add(-time.Hour),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	code, tokens := api.refreshTokens(t, tokensOf(accessToken, EncodeRefresh(refreshJWT)), nil)
+	if code != http.StatusOK {
+		t.Fatalf("refresh of baseline tokens: status %d", code)
+	}
+	// the new pair has the current shape and refreshes by its selector
+	code, _ = api.refreshTokens(t, tokens, nil)
+	if code != http.StatusOK {
+		t.Fatalf("refresh of rotated tokens: status %d", code)
+	}
+	// the baseline pair was rotated away
+	code, _ = api.refreshTokens(t, tokensOf(accessToken, EncodeRefresh(refreshJWT)), nil)
+	if code == http.StatusOK {
+		t.Fatal("baseline tokens refreshed twice")
+	}
+}
+
+func TestRefreshClearsCookieOfRejectedToken(t *testing.T) {
+	api := newTestAPI(t)
+	api.cookie.Enabled = true
+
+	w := httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodPost, "/tokenapi/v1/auth/token?client_id="+api.userID.String(), nil)
+	r.RemoteAddr = testClientIP + ":40000"
+	api.issuance.ReturnToken(w, r)
+	if w.Code != http.StatusOK {
+		t.Fatalf("issue: status %d", w.Code)
+	}
+	tokens := decodeTokens(t, w)
+	cookies := w.Result().Cookies()
+
+	withCookies := func(r *http.Request) {
+		for _, cookie := range cookies {
+			r.AddCookie(&http.Cookie{Name: cookie.Name, Value: cookie.Value})
+			if cookie.Name == CSRFCookieName {
+				r.Header.Set(CSRFHeader, cookie.Value)
+			}
+		}
+	}
+	w = api.serveRefresh(t, tokensOf(tokens.AccessToken, ""), withCookies)
+	if w.Code != http.StatusOK {
+		t.Fatalf("first refresh: status %d", w.Code)
+	}
+	if cleared(w, RefreshCookieName) {
+		t.Fatal("refresh cookie cleared after a successful refresh")
+	}
+
+	// the old cookie is reused
+	w = api.serveRefresh(t, tokensOf(tokens.AccessToken, ""), withCookies)
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("reused refresh: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+	if !cleared(w, RefreshCookieName) || !cleared(w, CSRFCookieName) {
+		t.Fatal("cookies of the reused refresh token weren't cleared")
+	}
+}
+
+func cleared(w *httptest.ResponseRecorder, name string) bool {
+	for _, cookie := range w.Result().Cookies() {
+		if cookie.Name == name && cookie.MaxAge < 0 {
+			return true
+		}
+	}
+	return false
+}
@@ -1,29 +1,48 @@
 package auth
 
 import (
+	"context"
 	"net/http"
 	"time"
 
 	"github.com/go-chi/render"
 
 	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/client/notification"
+	"github.com/nabishec/tokenapi/internal/client/webhook"
 	"github.com/nabishec/tokenapi/internal/lib"
 	"github.com/nabishec/tokenapi/internal/models"
-	"github.com/nabishec/tokenapi/internal/storage/postgresql/db"
+	"github.com/nabishec/tokenapi/internal/risk"
+	"github.com/nabishec/tokenapi/internal/storage"
 	"github.com/rs/zerolog/log"
 )
 
 type PostToken interface {
-	AddNewToken(refHash string, userID uuid.UUID, userIP string, jti string, exp time.Time) error
+	AddNewToken(ctx context.Context, token models.RefreshToken, notifications ...models.Notification) error
+	UserContacts
+	TokenFormatGetter
 }
 
 type TokenIssuance struct {
 	postToken PostToken
+	lockout   *Lockout
+	risk      *risk.Engine
+	dpop      *DPoP
+	cookie    *RefreshCookie
+	notifier  notification.Notifier
+	events    *webhook.Emitter
 }
 
-func NewTokenIssuance(postToken PostToken) TokenIssuance {
+func NewTokenIssuance(postToken PostToken, lockout *Lockout, riskEngine *risk.Engine, dpop *DPoP,
+	cookie *RefreshCookie, notifier notification.Notifier, events *webhook.Emitter) TokenIssuance {
 	return TokenIssuance{
 		postToken: postToken,
+		lockout:   lockout,
+		risk:      riskEngine,
+		dpop:      dpop,
+		cookie:    cookie,
+		notifier:  notifier,
+		events:    events,
 	}
 }
 
@@ -32,18 +51,43 @@ func NewTokenIssuance(postToken PostToken) TokenIssuance {
 // @Description  Генерация и выдача access и refresh токенов для клиента.
 // @Accept       json
 // @Produce      json
-// @Param        client_id  query     string  true   "GUID user"  Example: "123e4567-e89b-12d3-a456-426614174000"
-// @Success      200        {object}  models.Tokens    "Tokens created successful"
-// @Failure      400        {object}  models.Response     "Incorrect value of user id"
-// @Failure      403        {object}  models.Response     "Failed to determine IP"
+// @Param        client_id  query     string  false  "GUID user, can be omitted when a client certificate is used"  Example: "123e4567-e89b-12d3-a456-426614174000"
+// @Param        audience   query     string  false  "Audience of the access token, tokens for audiences with an encryption key are issued as JWE"
+// @Param        DPoP       header    string  false  "DPoP proof (RFC 9449) to bind tokens to the client key"
+// @Success      200        {object}  models.Tokens    "Tokens created successful. In cookie delivery mode the refresh token is set in the refresh_token HttpOnly cookie together with the csrf_token cookie"
+// @Failure      400        {object}  models.Response     "Incorrect value of user id or audience, or invalid DPoP proof"
+// @Failure      403        {object}  models.Response     "Failed to determine IP, login blocked or foreign client certificate"
 // @Failure      404        {object}  models.Response     "User not found"
+// @Failure      429        {object}  models.Response     "Too many failed attempts"
 // @Failure      500        {object}  models.Response     "Server error(failed create tokens)"
+// @Failure      504        {object}  models.Response     "Storage didn't answer in time"
 // @Router       /tokenapi/v1/auth/token [post]
 func (h *TokenIssuance) ReturnToken(w http.ResponseWriter, r *http.Request) {
 	const op = "internal.server.handlers.auth.ReturnToken()"
 	logs := log.With().Str("fn", op).Logger()
 	logs.Info().Msg("Request for the issuance of tokens has been received")
-	userGUID, err := uuid.Parse(r.URL.Query().Get("client_id"))
+	clientID := r.URL.Query().Get("client_id")
+	cert := ClientCertificate(r)
+	if cert != nil {
+		certGUID, err := CertificateClientID(cert)
+		if err == nil && clientID != "" {
+			var queryGUID uuid.UUID
+			queryGUID, err = uuid.Parse(clientID)
+			if err == nil && queryGUID != certGUID {
+				err = ErrForeignCertificate
+			}
+		}
+		if err != nil {
+			logs.Error().Msgf("Client certificate wasn't issued to user - %s", clientID)
+
+			w.WriteHeader(http.StatusForbidden) // 403
+			render.JSON(w, r, models.StatusError("client certificate wasn't issued to this user"))
+			return
+		}
+		clientID = certGUID.String()
+	}
+
+	userGUID, err := uuid.Parse(clientID)
 	if userGUID == uuid.Nil || err != nil {
 		logs.Error().Msg("Failed to receive user GUID")
 
@@ -53,6 +97,15 @@ func (h *TokenIssuance) ReturnToken(w http.ResponseWriter, r *http.Request) {
 	}
 	logs.Debug().Msgf("User GUID - %s was received", userGUID)
 
+	audience := r.URL.Query().Get("audience")
+	if len(audience) > maxAudienceLength {
+		logs.Error().Msg("Audience is too long")
+
+		w.WriteHeader(http.StatusBadRequest) // 400
+		render.JSON(w, r, models.StatusError("incorrect value of audience"))
+		return
+	}
+
 	userIP, err := GetIP(r)
 	if err != nil {
 		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to determine user IP")
@@ -63,57 +116,118 @@ func (h *TokenIssuance) ReturnToken(w http.ResponseWriter, r *http.Request) {
 	}
 	logs.Debug().Msgf("IP was defined as - %s", userIP)
 
-	accessToken, jti, err := CreateAccessToken(userGUID.String(), userIP)
+	if h.lockout.locked(w, r, logs, IPKey(userIP), UserKey(userGUID.String())) {
+		return
+	}
+
+	userAgent, deviceID := GetDevice(r)
+	attempt := risk.Attempt{
+		UserID:    userGUID,
+		IP:        userIP,
+		UserAgent: userAgent,
+		Time:      time.Now(),
+	}
+	assessment, ok := checkRisk(r, logs, h.risk, h.postToken, h.notifier, attempt)
+	if !ok {
+		riskBlocked(w, r)
+		return
+	}
+
+	cnf, tokenType, err := bindTokens(r, h.dpop, nil)
 	if err != nil {
-		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to create access-token")
+		logs.Error().Err(err).Msg("Invalid DPoP proof")
 
-		w.WriteHeader(http.StatusInternalServerError) // 500
-		render.JSON(w, r, models.StatusError("failed to create access-token"))
+		w.WriteHeader(http.StatusBadRequest) // 400
+		render.JSON(w, r, models.StatusError("invalid DPoP proof"))
 		return
 	}
-	logs.Debug().Msgf("Access token for user - %s created successfull", userGUID)
 
-	refreshToken, err := CreateRefreshToken(userIP)
+	format, err := clientTokenFormat(r.Context(), h.postToken, userGUID)
 	if err != nil {
-		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to create refresh-token")
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to get token format of user")
+
+		StorageFailed(w, r, err, "failed to create access-token")
+		return
+	}
+
+	sessionID := uuid.New()
+	accessToken, jti, err := CreateAccessToken(format, audience, userGUID.String(), sessionID, userIP, cnf)
+	if err != nil {
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to create access-token")
 
 		w.WriteHeader(http.StatusInternalServerError) // 500
-		render.JSON(w, r, models.StatusError("failed to create refresh-token"))
+		render.JSON(w, r, models.StatusError("failed to create access-token"))
 		return
 	}
-	logs.Debug().Msgf("Refresh token for user - %s created successfull", userGUID)
+	logs.Debug().Msgf("Access token for user - %s created successfull", userGUID)
 
-	refHash, err := CreateHashRef(refreshToken)
+	refreshToken, refHash, selector, err := issueRefreshToken(userIP)
 	if err != nil {
-		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to create refresh hash")
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to create refresh-token")
 
 		w.WriteHeader(http.StatusInternalServerError) // 500
 		render.JSON(w, r, models.StatusError("failed to create refresh-token"))
 		return
 	}
-	logs.Debug().Msgf("Refresh hash for user - %s created successfull", userGUID)
+	logs.Debug().Msgf("Refresh token for user - %s created successfull", userGUID)
 
-	expRef := time.Now().Unix() + 86400 // one day
-	err = h.postToken.AddNewToken(refHash, userGUID, userIP, jti, time.Unix(expRef, 0))
+	expRef := time.Now().Add(RefreshTokenLifetime).Unix()
+	err = h.postToken.AddNewToken(r.Context(), models.RefreshToken{
+		Hash:      refHash,
+		UserID:    userGUID,
+		IP:        userIP,
+		JTI:       jti,
+		Exp:       time.Unix(expRef, 0),
+		UserAgent: userAgent,
+		DeviceID:  deviceID,
+		JKT:       cnf.jkt(),
+		X5T:       cnf.x5t(),
+		SessionID: sessionID,
+		CreatedAt: time.Now(),
+		Selector:  selector,
+		Audience:  audience,
+	}, pendingNotifications(r.Context(), h.postToken, logs, riskyLogin(attempt, assessment))...)
 	if err != nil {
-		if err == db.ErrUserNotExists {
+		if err == storage.ErrUserNotExists {
 			log.Error().Msgf("User id - %s not found", userGUID)
+			// unknown ids are counted to slow down enumeration
+			_, _, err = h.lockout.Fail(context.WithoutCancel(r.Context()), IPKey(userIP))
+			if err != nil {
+				logs.Error().AnErr(lib.ErrReader(err)).Msgf("Failed to register failure for IP - %s", userIP)
+			}
 			w.WriteHeader(http.StatusNotFound) // 404
 			render.JSON(w, r, models.StatusError("user id not fount"))
 			return
 		}
 		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to save refresh hash")
-		w.WriteHeader(http.StatusInternalServerError) // 500
-		render.JSON(w, r, models.StatusError("failed to save refresh-token"))
+		StorageFailed(w, r, err, "failed to save refresh-token")
 		return
 	}
 	logs.Debug().Msgf("Refresh hash for user - %s saved successfull", userGUID)
+	h.events.Emit(webhook.TokenIssued(userGUID, sessionID, userIP, userAgent))
+
+	err = h.risk.Record(context.WithoutCancel(r.Context()), attempt, assessment)
+	if err != nil {
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to save login")
+	}
 
 	logs.Info().Msgf("Tokens created for user - %s", userGUID)
-	refreshToken = EncodeRefresh(refreshToken)
+	if h.cookie.Enabled {
+		err = h.cookie.Set(w, refreshToken, time.Unix(expRef, 0))
+		if err != nil {
+			logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to set refresh cookie")
+
+			w.WriteHeader(http.StatusInternalServerError) // 500
+			render.JSON(w, r, models.StatusError("failed to create refresh-token"))
+			return
+		}
+		// the token is kept out of reach of scripts
+		refreshToken = ""
+	}
 	resp := models.Tokens{
 		AccessToken:  accessToken,
 		RefreshToken: refreshToken,
+		TokenType:    tokenType,
 	}
 	w.WriteHeader(http.StatusOK) //200
 	render.JSON(w, r, resp)
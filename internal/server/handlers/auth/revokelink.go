@@ -0,0 +1,66 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// RevokeLinkLifetime is how long the "this wasn't me" links of notifications work.
+var RevokeLinkLifetime = 7 * 24 * time.Hour
+
+// RevokeLinkPath is the endpoint of "this wasn't me" links.
+const RevokeLinkPath = "/tokenapi/v1/auth/revoke"
+
+// RevokeLink returns the link revoking all tokens of the user, empty when PublicURL isn't set.
+func RevokeLink(userID uuid.UUID) string {
+	if PublicURL == "" {
+		return ""
+	}
+	exp := time.Now().Add(RevokeLinkLifetime).Unix()
+	payload := userID.String() + "." + strconv.FormatInt(exp, 10)
+	return PublicURL + RevokeLinkPath + "?token=" + url.QueryEscape(payload+"."+revokeLinkMAC(payload))
+}
+
+// VerifyRevokeToken checks the token of a revoke link and returns the user it was issued for.
+func VerifyRevokeToken(token string) (uuid.UUID, error) {
+	const op = "internal.server.handlers.auth.VerifyRevokeToken()"
+	cut := strings.LastIndex(token, ".")
+	if cut < 0 {
+		return uuid.Nil, fmt.Errorf("%s:%s", op, "invalid revoke token")
+	}
+	payload, mac := token[:cut], token[cut+1:]
+	if !hmac.Equal([]byte(mac), []byte(revokeLinkMAC(payload))) {
+		return uuid.Nil, fmt.Errorf("%s:%s", op, "invalid revoke token signature")
+	}
+	userGUID, expValue, _ := strings.Cut(payload, ".")
+	exp, err := strconv.ParseInt(expValue, 10, 64)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s:%s", op, "invalid revoke token")
+	}
+	if time.Now().Unix() > exp {
+		return uuid.Nil, fmt.Errorf("%s:%s", op, "revoke token expired")
+	}
+	userID, err := uuid.Parse(userGUID)
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s:%s", op, "invalid revoke token")
+	}
+	return userID, nil
+}
+
+// revokeLinkMAC signs the payload with a key separate from the one of tokens,
+// so a link can never be taken for a token.
+func revokeLinkMAC(payload string) string {
+	key := hmac.New(sha256.New, SigningKey)
+	key.Write([]byte("revoke-link"))
+	mac := hmac.New(sha256.New, key.Sum(nil))
+	mac.Write([]byte(payload))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
@@ -0,0 +1,59 @@
+package auth
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/go-chi/render"
+	"github.com/nabishec/tokenapi/internal/client/notification"
+	"github.com/nabishec/tokenapi/internal/lib"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/nabishec/tokenapi/internal/risk"
+	"github.com/rs/zerolog"
+)
+
+// checkRisk assesses the attempt and applies the action its score maps to.
+// It returns false when the attempt is rejected, the caller then responds with riskBlocked.
+// The user is notified about rejected attempts here, about allowed ones
+// together with the issued tokens, see riskyLogin.
+func checkRisk(r *http.Request, logs zerolog.Logger, engine *risk.Engine, contacts UserContacts,
+	notifier notification.Notifier, attempt risk.Attempt) (risk.Assessment, bool) {
+	assessment, err := engine.Assess(r.Context(), attempt)
+	if err != nil {
+		// storage failures shouldn't lock everybody out
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to assess risk")
+		return assessment, true
+	}
+	logs.Debug().Msgf("Risk score - %d, signals - %v, action - %s", assessment.Score, assessment.Signals, assessment.Action)
+	if assessment.Action == risk.ActionAllow || assessment.Action == risk.ActionNotify {
+		return assessment, true
+	}
+
+	logs.Error().Msg("Attempt blocked by risk score")
+	notifyUser(r.Context(), notifier, contacts, logs,
+		notification.RiskyLogin(attempt.UserID, attempt.IP, locationName(assessment.Location), attempt.UserAgent))
+	return assessment, false
+}
+
+// riskBlocked responds to an attempt rejected by checkRisk.
+func riskBlocked(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusForbidden) // 403
+	render.JSON(w, r, models.StatusError("login blocked"))
+}
+
+// riskyLogin returns the warning about the allowed attempt if its score requires one.
+func riskyLogin(attempt risk.Attempt, assessment risk.Assessment) []notification.Message {
+	if assessment.Action != risk.ActionNotify {
+		return nil
+	}
+	return []notification.Message{
+		notification.RiskyLogin(attempt.UserID, attempt.IP, locationName(assessment.Location), attempt.UserAgent),
+	}
+}
+
+func locationName(location *risk.Location) string {
+	if location == nil {
+		return ""
+	}
+	return strings.Trim(location.City+", "+location.Country, ", ")
+}
@@ -0,0 +1,35 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/nabishec/tokenapi/internal/models"
+)
+
+// StatusClientClosedRequest is the nginx status of requests the client disconnected from.
+// The client never gets it, it is only seen in logs and metrics.
+const StatusClientClosedRequest = 499
+
+// isCanceled reports whether err comes from a storage call stopped by the request context.
+func isCanceled(err error) bool {
+	return errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded)
+}
+
+// StorageFailed responds to a failed storage call. Queries canceled by the client
+// and stopped by the query timeout get their own statuses, the others are server errors with message.
+func StorageFailed(w http.ResponseWriter, r *http.Request, err error, message string) {
+	switch {
+	case errors.Is(err, context.Canceled):
+		w.WriteHeader(StatusClientClosedRequest) // 499
+		render.JSON(w, r, models.StatusError("request canceled"))
+	case errors.Is(err, context.DeadlineExceeded):
+		w.WriteHeader(http.StatusGatewayTimeout) // 504
+		render.JSON(w, r, models.StatusError("storage timeout"))
+	default:
+		w.WriteHeader(http.StatusInternalServerError) // 500
+		render.JSON(w, r, models.StatusError(message))
+	}
+}
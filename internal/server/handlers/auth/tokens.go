@@ -3,6 +3,7 @@ package auth
 import (
 	"encoding/base64"
 	"fmt"
+	"net/http"
 	"os"
 	"strings"
 
@@ -15,49 +16,119 @@ import (
 )
 
 var SigningKey = []byte(os.Getenv("SIGNING_KEY"))
+
+const (
+	AccessTokenLifetime  = 15 * time.Minute
+	RefreshTokenLifetime = 24 * time.Hour
+)
+
 var ErrAccessTokenExpired = fmt.Errorf("token expired")
 
 type JWTClaims struct {
-	UserIP string `json:"user_ip"`
+	UserIP string        `json:"user_ip"`
+	Cnf    *Confirmation `json:"cnf,omitempty"`
+	// SessionID is the session the access token was issued for, it stays the same across refreshes.
+	SessionID string `json:"sid,omitempty"`
 	jwt.StandardClaims
 }
 
-func CreateAccessToken(userGUID string, userIP string) (string, string, error) {
+// Confirmation binds a token to a key of the client (RFC 7800).
+type Confirmation struct {
+	// JKT is the thumbprint of the DPoP proof key.
+	JKT string `json:"jkt,omitempty"`
+	// X5T is the thumbprint of the client certificate.
+	X5T string `json:"x5t#S256,omitempty"`
+}
+
+func (c *Confirmation) jkt() string {
+	if c == nil {
+		return ""
+	}
+	return c.JKT
+}
+
+func (c *Confirmation) x5t() string {
+	if c == nil {
+		return ""
+	}
+	return c.X5T
+}
+
+// bindTokens checks the DPoP proof and the client certificate of a token request and
+// returns the confirmation and the type of the tokens to issue. bound is the confirmation
+// of the refreshed tokens, which must be proven again, or nil for new tokens.
+func bindTokens(r *http.Request, dpop *DPoP, bound *Confirmation) (*Confirmation, string, error) {
+	const op = "internal.server.handlers.auth.bindTokens()"
+	var cnf Confirmation
+	var err error
+	cnf.JKT, err = dpop.proofKey(r, bound.jkt())
+	if err != nil {
+		return nil, "", err
+	}
+
+	if cert := ClientCertificate(r); cert != nil {
+		cnf.X5T = CertificateThumbprint(cert)
+	}
+	if bound.x5t() != "" && cnf.X5T != bound.x5t() {
+		return nil, "", fmt.Errorf("%s:%s", op, "client certificate doesn't match bound certificate")
+	}
+
+	tokenType := TokenTypeBearer
+	if cnf.JKT != "" {
+		tokenType = TokenTypeDPoP
+	}
+	if cnf == (Confirmation{}) {
+		return nil, tokenType, nil
+	}
+	return &cnf, tokenType, nil
+}
+
+// CreateAccessToken creates an access token of the session in the format, cnf may be nil for unbound tokens.
+// Tokens for audiences in JWEAudiences are encrypted, audience may be empty.
+func CreateAccessToken(format TokenFormat, audience string, userGUID string, sessionID uuid.UUID, userIP string, cnf *Confirmation) (string, string, error) {
 	const op = "internal.server.handlers.auth.CreateAccessToken()"
 	jti := uuid.New().String()
-	exp := time.Now().Unix() + 900 // 15 minutes
+	exp := time.Now().Add(AccessTokenLifetime).Unix()
 	claims := JWTClaims{
 		userIP,
+		cnf,
+		sessionID.String(),
 		jwt.StandardClaims{
 			Id:        jti,
 			Subject:   userGUID,
+			Audience:  audience,
+			IssuedAt:  time.Now().Unix(),
 			ExpiresAt: exp,
 		},
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS512, claims)
-
-	tokenString, err := token.SignedString(SigningKey)
-
+	tokenString, err := format.Sign(&claims)
+	if err != nil {
+		return "", "", fmt.Errorf("%s:%w", op, err)
+	}
+	tokenString, err = encryptForAudience(tokenString, audience, format)
 	if err != nil {
 		return "", "", fmt.Errorf("%s:%w", op, err)
 	}
 	return tokenString, jti, nil
 }
 
-func CreateRefreshToken(userIP string) (string, error) {
+// CreateRefreshToken creates a JWT refresh token, its jti is the selector the token is saved with.
+func CreateRefreshToken(userIP string) (string, string, error) {
 	const op = "internal.server.handlers.auth.CreateRefreshToken()"
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, JWTClaims{
+	jti := uuid.NewString()
+	tokenString, err := refreshJWT.Sign(&JWTClaims{
 		userIP,
+		nil,
+		"",
 		jwt.StandardClaims{
-			Id: uuid.NewString(),
+			Id: jti,
 		},
 	})
-	tokenString, err := token.SignedString(SigningKey)
 	if err != nil {
-		return "", fmt.Errorf("%s:%w", op, err)
+		return "", "", fmt.Errorf("%s:%w", op, err)
 	}
 
-	return tokenString, nil
+	return tokenString, jti, nil
 }
 
 func EncodeRefresh(tokenString string) string {
@@ -104,42 +175,35 @@ func CheckRefHash(refHash string, refToken string) error {
 	return nil
 }
 
-func JWTTokenValid(tokenString string) (*JWTClaims, error) {
-	const op = "internal.server.handlers.auth.JWTTokenValid()"
-	token, err := jwt.ParseWithClaims(tokenString, &JWTClaims{}, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("%s:unexpected signing method: %v", op, token.Header["alg"])
-		}
-		return SigningKey, nil
-	})
+// ParseRefreshJWT verifies a decoded JWT refresh token and returns its claims.
+func ParseRefreshJWT(refToken string) (*JWTClaims, error) {
+	const op = "internal.server.handlers.auth.ParseRefreshJWT()"
+	// only HS256 refresh tokens are accepted, access tokens are signed with HS512
+	claims, err := refreshJWT.Parse(refToken)
 	if err != nil {
-		if valErr, ok := err.(*jwt.ValidationError); ok && valErr.Errors == jwt.ValidationErrorExpired {
-			if claims, ok := token.Claims.(*JWTClaims); ok {
-				return claims, ErrAccessTokenExpired
-			} else {
-				return nil, fmt.Errorf("%s:%s", op, "failed conversion of jwt claims")
-			}
-		}
-		return nil, fmt.Errorf("%s:%s", op, "bad jwt token")
-	}
-	if !token.Valid {
-		return nil, fmt.Errorf("%s,%s", op, "invalid token")
-	}
-	if claims, ok := token.Claims.(*JWTClaims); ok {
-		return claims, nil
-	} else {
-		return nil, fmt.Errorf("%s:%s", op, "failed conversion of jwt claims")
+		return nil, fmt.Errorf("%s:%w", op, err)
 	}
+	return claims, nil
 }
 
-func DecodeUserIPFromRefTok(refToken string) (string, error) {
-	const op = "internal.server.handlers.auth.DecodeUserIPFromRefTok()"
-	refPayload, err := JWTTokenValid(string(refToken))
+// issueRefreshToken creates a refresh token in RefreshFormat. It returns the token to give
+// to the client, the hash to save and the selector to find it by.
+func issueRefreshToken(userIP string) (token string, hash string, selector string, err error) {
+	if RefreshFormat == RefreshFormatOpaque {
+		var verifierHash string
+		token, selector, verifierHash, err = CreateOpaqueRefresh()
+		if err != nil {
+			return "", "", "", err
+		}
+		return token, verifierHash, selector, nil
+	}
+	token, selector, err = CreateRefreshToken(userIP)
 	if err != nil {
-		return "", fmt.Errorf("%s:%w", op, err)
+		return "", "", "", err
 	}
-
-	userIP := refPayload.UserIP
-	return userIP, nil
-
+	hash, err = CreateHashRef(token)
+	if err != nil {
+		return "", "", "", err
+	}
+	return EncodeRefresh(token), hash, selector, nil
 }
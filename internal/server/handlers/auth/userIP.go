@@ -7,33 +7,185 @@ import (
 	"strings"
 )
 
-func GetIP(r *http.Request) (string, error) {
-	op := "internal.server.handlers.auth.GetIP()"
-	//Get IP from the X-REAL-IP header
-	ip := r.Header.Get("X-REAL-IP")
-	netIP := net.ParseIP(ip)
-	if netIP != nil {
-		return ip, nil
+// TrustedProxies are the networks whose forwarding headers are believed.
+// Headers of requests coming from any other peer are ignored.
+var TrustedProxies []*net.IPNet
+
+// ProxyHeader is the header trusted proxies put the client address in.
+type ProxyHeader string
+
+const (
+	// ProxyHeaderXFF is X-Forwarded-For, the proxies append the address they got the request from.
+	ProxyHeaderXFF ProxyHeader = "xff"
+	// ProxyHeaderForwarded is the RFC 7239 Forwarded header, appended like X-Forwarded-For.
+	ProxyHeaderForwarded ProxyHeader = "forwarded"
+	// ProxyHeaderXRealIP is X-Real-IP, the proxy must overwrite it with the address of the client.
+	ProxyHeaderXRealIP ProxyHeader = "x-real-ip"
+)
+
+// TrustedProxyHeader is the only header the client address is read from. Proxies pass
+// other headers of the client through, so reading them would let clients choose their IP.
+var TrustedProxyHeader = ProxyHeaderXFF
+
+func ParseProxyHeader(value string) (ProxyHeader, error) {
+	const op = "internal.server.handlers.auth.ParseProxyHeader()"
+	switch header := ProxyHeader(strings.ToLower(value)); header {
+	case "":
+		return ProxyHeaderXFF, nil
+	case ProxyHeaderXFF, ProxyHeaderForwarded, ProxyHeaderXRealIP:
+		return header, nil
+	default:
+		return "", fmt.Errorf("%s:unknown trusted proxy header %q", op, value)
 	}
+}
 
-	//Get IP from X-FORWARDED-FOR header
-	ips := r.Header.Get("X-FORWARDED-FOR")
-	splitIps := strings.Split(ips, ",")
-	for _, ip := range splitIps {
-		netIP := net.ParseIP(ip)
-		if netIP != nil {
-			return ip, nil
+// ParseTrustedProxies parses a comma separated list of CIDRs or single IPs.
+func ParseTrustedProxies(list string) ([]*net.IPNet, error) {
+	const op = "internal.server.handlers.auth.ParseTrustedProxies()"
+	var proxies []*net.IPNet
+	for _, value := range strings.Split(list, ",") {
+		value = strings.TrimSpace(value)
+		if value == "" {
+			continue
+		}
+		if !strings.Contains(value, "/") {
+			ip := net.ParseIP(value)
+			if ip == nil {
+				return nil, fmt.Errorf("%s:invalid proxy ip %q", op, value)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip = ip.To4()
+				bits = 8 * net.IPv4len
+			}
+			proxies = append(proxies, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
 		}
+		_, network, err := net.ParseCIDR(value)
+		if err != nil {
+			return nil, fmt.Errorf("%s:invalid proxy cidr %q", op, value)
+		}
+		proxies = append(proxies, network)
 	}
+	return proxies, nil
+}
 
+func GetIP(r *http.Request) (string, error) {
+	op := "internal.server.handlers.auth.GetIP()"
 	//Get IP from RemoteAddr
 	ip, _, err := net.SplitHostPort(r.RemoteAddr)
 	if err != nil {
 		return "", fmt.Errorf("%s:%w", op, err)
 	}
-	netIP = net.ParseIP(ip)
-	if netIP != nil {
-		return ip, nil
+	peerIP := net.ParseIP(ip)
+	if peerIP == nil {
+		return "", fmt.Errorf("%s:%s", op, "no valid ip found")
+	}
+	if !isTrustedProxy(peerIP) {
+		return peerIP.String(), nil
+	}
+
+	var chain []net.IP
+	switch TrustedProxyHeader {
+	case ProxyHeaderXRealIP:
+		//Get IP from the X-REAL-IP header, set by the trusted proxy itself
+		if realIP := net.ParseIP(strings.TrimSpace(r.Header.Get("X-REAL-IP"))); realIP != nil {
+			return realIP.String(), nil
+		}
+		return peerIP.String(), nil
+	case ProxyHeaderForwarded:
+		chain = forwardedChain(r.Header.Values("Forwarded"))
+	default:
+		chain = xForwardedForChain(r.Header.Values("X-FORWARDED-FOR"))
+	}
+
+	// walk the chain from the nearest hop, the first untrusted address is the client
+	clientIP := peerIP
+	for i := len(chain) - 1; i >= 0; i-- {
+		hop := chain[i]
+		if hop == nil {
+			// unparseable hop: everything to the left of it can't be trusted
+			break
+		}
+		clientIP = hop
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return clientIP.String(), nil
+}
+
+// maxDeviceLength limits client supplied device data saved with refresh tokens.
+const maxDeviceLength = 512
+
+// GetDevice returns the user agent and the optional device ID sent in the X-Device-ID header.
+func GetDevice(r *http.Request) (userAgent string, deviceID string) {
+	return truncate(r.UserAgent()), truncate(strings.TrimSpace(r.Header.Get("X-Device-ID")))
+}
+
+func truncate(value string) string {
+	if len(value) > maxDeviceLength {
+		value = value[:maxDeviceLength]
+	}
+	return strings.ToValidUTF8(value, "")
+}
+
+func isTrustedProxy(ip net.IP) bool {
+	for _, network := range TrustedProxies {
+		if network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// xForwardedForChain returns the addresses of X-Forwarded-For headers, nil for invalid ones.
+func xForwardedForChain(headers []string) []net.IP {
+	var chain []net.IP
+	for _, header := range headers {
+		for _, value := range strings.Split(header, ",") {
+			chain = append(chain, net.ParseIP(strings.TrimSpace(value)))
+		}
+	}
+	return chain
+}
+
+// forwardedChain returns the "for" addresses of RFC 7239 Forwarded headers,
+// nil for obfuscated, unknown or invalid ones.
+func forwardedChain(headers []string) []net.IP {
+	var chain []net.IP
+	for _, header := range headers {
+		for _, element := range strings.Split(header, ",") {
+			var hop net.IP
+			found := false
+			for _, pair := range strings.Split(element, ";") {
+				name, value, ok := strings.Cut(strings.TrimSpace(pair), "=")
+				if !ok || !strings.EqualFold(name, "for") {
+					continue
+				}
+				found = true
+				hop = parseForwardedNode(value)
+			}
+			if found {
+				chain = append(chain, hop)
+			}
+		}
+	}
+	return chain
+}
+
+// parseForwardedNode parses node values like 192.0.2.43, "192.0.2.43:47011" or "[2001:db8::17]:4711".
+func parseForwardedNode(value string) net.IP {
+	value = strings.Trim(strings.TrimSpace(value), `"`)
+	if strings.HasPrefix(value, "[") {
+		end := strings.Index(value, "]")
+		if end < 0 {
+			return nil
+		}
+		return net.ParseIP(value[1:end])
+	}
+	if host, _, err := net.SplitHostPort(value); err == nil {
+		value = host
 	}
-	return "", fmt.Errorf("%s:%s", op, "no valid ip found")
+	return net.ParseIP(value)
 }
@@ -0,0 +1,162 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestGetIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.0.2.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	savedProxies, savedHeader := TrustedProxies, TrustedProxyHeader
+	TrustedProxies = proxies
+	t.Cleanup(func() { TrustedProxies, TrustedProxyHeader = savedProxies, savedHeader })
+
+	for _, tt := range []struct {
+		name    string
+		header  ProxyHeader
+		peer    string
+		headers map[string]string
+		want    string
+		wantErr bool
+	}{
+		{
+			name:    "untrusted peer headers are ignored",
+			peer:    "203.0.113.9:5000",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4", "Forwarded": "for=1.2.3.4", "X-Real-IP": "1.2.3.4"},
+			want:    "203.0.113.9",
+		},
+		{
+			name: "trusted proxy without header",
+			peer: "10.0.0.1:5000",
+			want: "10.0.0.1",
+		},
+		{
+			name:    "client address appended by the proxy",
+			peer:    "10.0.0.1:5000",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.7"},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "spoofed X-Forwarded-For passed through by the proxy",
+			peer:    "10.0.0.1:5000",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4, 198.51.100.7"},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "spoofed Forwarded passed through in xff mode",
+			peer:    "10.0.0.1:5000",
+			headers: map[string]string{"Forwarded": "for=1.2.3.4", "X-Forwarded-For": "198.51.100.7"},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "spoofed X-Real-IP passed through in xff mode",
+			peer:    "10.0.0.1:5000",
+			headers: map[string]string{"X-Real-IP": "1.2.3.4"},
+			want:    "10.0.0.1",
+		},
+		{
+			name:    "multi-hop chain of trusted proxies",
+			peer:    "10.0.0.1:5000",
+			headers: map[string]string{"X-Forwarded-For": "198.51.100.7, 192.0.2.1, 10.0.0.2"},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "malformed X-Forwarded-For",
+			peer:    "10.0.0.1:5000",
+			headers: map[string]string{"X-Forwarded-For": "not-an-ip"},
+			want:    "10.0.0.1",
+		},
+		{
+			name:    "malformed hop stops the walk",
+			peer:    "10.0.0.1:5000",
+			headers: map[string]string{"X-Forwarded-For": "1.2.3.4, junk, 10.0.0.2"},
+			want:    "10.0.0.2",
+		},
+		{
+			name:    "forwarded mode",
+			header:  ProxyHeaderForwarded,
+			peer:    "10.0.0.1:5000",
+			headers: map[string]string{"Forwarded": `for="[2001:db8::17]:4711";proto=https`, "X-Forwarded-For": "1.2.3.4"},
+			want:    "2001:db8::17",
+		},
+		{
+			name:    "spoofed Forwarded passed through in forwarded mode",
+			header:  ProxyHeaderForwarded,
+			peer:    "10.0.0.1:5000",
+			headers: map[string]string{"Forwarded": "for=1.2.3.4, for=198.51.100.7"},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "obfuscated Forwarded node",
+			header:  ProxyHeaderForwarded,
+			peer:    "10.0.0.1:5000",
+			headers: map[string]string{"Forwarded": "for=_hidden"},
+			want:    "10.0.0.1",
+		},
+		{
+			name:    "x-real-ip mode",
+			header:  ProxyHeaderXRealIP,
+			peer:    "10.0.0.1:5000",
+			headers: map[string]string{"X-Real-IP": "198.51.100.7", "X-Forwarded-For": "1.2.3.4"},
+			want:    "198.51.100.7",
+		},
+		{
+			name:    "malformed X-Real-IP",
+			header:  ProxyHeaderXRealIP,
+			peer:    "10.0.0.1:5000",
+			headers: map[string]string{"X-Real-IP": "198.51.100.7:80"},
+			want:    "10.0.0.1",
+		},
+		{
+			name: "IPv6 peer",
+			peer: "[2001:db8::1]:5000",
+			want: "2001:db8::1",
+		},
+		{
+			name:    "malformed peer address",
+			peer:    "10.0.0.1",
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			TrustedProxyHeader = tt.header
+			if TrustedProxyHeader == "" {
+				TrustedProxyHeader = ProxyHeaderXFF
+			}
+			r := httptest.NewRequest(http.MethodPost, "/", nil)
+			r.RemoteAddr = tt.peer
+			for name, value := range tt.headers {
+				r.Header.Set(name, value)
+			}
+			got, err := GetIP(r)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("GetIP() error = %v, want error %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Fatalf("GetIP() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseProxyHeader(t *testing.T) {
+	for value, want := range map[string]ProxyHeader{
+		"":          ProxyHeaderXFF,
+		"xff":       ProxyHeaderXFF,
+		"Forwarded": ProxyHeaderForwarded,
+		"x-real-ip": ProxyHeaderXRealIP,
+	} {
+		got, err := ParseProxyHeader(value)
+		if err != nil || got != want {
+			t.Errorf("ParseProxyHeader(%q) = %q, %v, want %q", value, got, err, want)
+		}
+	}
+	_, err := ParseProxyHeader("x-client-ip")
+	if err == nil {
+		t.Error("unknown header was accepted")
+	}
+}
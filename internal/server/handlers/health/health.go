@@ -0,0 +1,44 @@
+package health
+
+import (
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+// Checker reports the result of the last health probe of the storage.
+type Checker interface {
+	Healthy() bool
+}
+
+type Health struct {
+	checker Checker
+}
+
+func NewHealth(checker Checker) Health {
+	return Health{
+		checker: checker,
+	}
+}
+
+// @Summary      Health check
+// @Tags         health
+// @Description  Проверка доступности сервиса и базы данных для балансировщика и оркестратора. Состояние базы берется из последней периодической проверки, сам запрос к базе не обращается.
+// @Produce      json
+// @Success      200        {object}  models.Response     "Service is ready"
+// @Failure      503        {object}  models.Response     "Database is unavailable"
+// @Router       /tokenapi/v1/health [get]
+func (h *Health) Get(w http.ResponseWriter, r *http.Request) {
+	const op = "internal.server.handlers.health.Get()"
+	if !h.checker.Healthy() {
+		log.Debug().Str("fn", op).Msg("Health check failed, database is unavailable")
+
+		w.WriteHeader(http.StatusServiceUnavailable) // 503
+		render.JSON(w, r, models.StatusError("database unavailable"))
+		return
+	}
+	w.WriteHeader(http.StatusOK) // 200
+	render.JSON(w, r, models.StatusOK())
+}
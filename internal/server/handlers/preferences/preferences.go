@@ -0,0 +1,266 @@
+package preferences
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+
+	"github.com/go-chi/render"
+	"github.com/go-playground/validator/v10"
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/client/notification"
+	"github.com/nabishec/tokenapi/internal/lib"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/nabishec/tokenapi/internal/server/handlers/auth"
+	"github.com/nabishec/tokenapi/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+type PreferenceStorage interface {
+	GetNotificationPreferences(ctx context.Context, userID uuid.UUID) (*models.NotificationPreferences, error)
+	SetNotificationPreferences(ctx context.Context, userID uuid.UUID, preferences *models.NotificationPreferences) error
+}
+
+type Preferences struct {
+	storage PreferenceStorage
+	// push tells whether the push gateway is configured.
+	push bool
+}
+
+func NewPreferences(storage PreferenceStorage, push bool) Preferences {
+	return Preferences{
+		storage: storage,
+		push:    push,
+	}
+}
+
+// @Summary      Get notification preferences
+// @Tags         notifications
+// @Description  Настройки уведомлений пользователя: о каких событиях и по каким каналам (email, webhook, push) уведомлять. События, которых нет в списке, отправляются по email.
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200        {object}  models.NotificationPreferences    "Notification preferences"
+// @Failure      401        {object}  models.Response     "Invalid access token"
+// @Failure      404        {object}  models.Response     "User not found"
+// @Failure      500        {object}  models.Response     "Server error(failed get preferences)"
+// @Failure      504        {object}  models.Response     "Storage didn't answer in time"
+// @Router       /tokenapi/v1/notifications/preferences [get]
+func (h *Preferences) Get(w http.ResponseWriter, r *http.Request) {
+	const op = "internal.server.handlers.preferences.Get()"
+	logs := log.With().Str("fn", op).Logger()
+	claims := auth.ClaimsFromContext(r.Context())
+	logs.Info().Msgf("Request for notification preferences of user - %s has been received", claims.Subject)
+
+	preferences, err := h.storage.GetNotificationPreferences(r.Context(), uuid.MustParse(claims.Subject))
+	if err != nil {
+		if err == storage.ErrUserNotExists {
+			logs.Error().Msgf("User id - %s not found", claims.Subject)
+
+			w.WriteHeader(http.StatusNotFound) // 404
+			render.JSON(w, r, models.StatusError("user id not fount"))
+			return
+		}
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to get notification preferences")
+
+		auth.StorageFailed(w, r, err, "failed to get preferences")
+		return
+	}
+	if preferences == nil {
+		preferences = &models.NotificationPreferences{}
+	}
+	if preferences.Events == nil {
+		preferences.Events = map[string][]string{}
+	}
+
+	w.WriteHeader(http.StatusOK) // 200
+	render.JSON(w, r, preferences)
+}
+
+// @Summary      Set notification preferences
+// @Tags         notifications
+// @Description  Выбор событий (suspicious_login, risky_login, lockout) и каналов для них. Пустой список каналов отключает уведомления о событии. Канал webhook требует webhook_url (https с публичным адресом), доставки подписываются webhook_secret; канал push - push_recipient (id чата или номер телефона для шлюза).
+// @Accept       json
+// @Produce      json
+// @Security     BearerAuth
+// @Param        preferences  body     models.NotificationPreferences  true   "Notification preferences"
+// @Success      200        {object}  models.Response    "Preferences saved"
+// @Failure      400        {object}  models.Response     "Incorrect event, channel or its settings"
+// @Failure      401        {object}  models.Response     "Invalid access token"
+// @Failure      404        {object}  models.Response     "User not found"
+// @Failure      500        {object}  models.Response     "Server error(failed save preferences)"
+// @Failure      504        {object}  models.Response     "Storage didn't answer in time"
+// @Router       /tokenapi/v1/notifications/preferences [put]
+func (h *Preferences) Set(w http.ResponseWriter, r *http.Request) {
+	const op = "internal.server.handlers.preferences.Set()"
+	logs := log.With().Str("fn", op).Logger()
+	claims := auth.ClaimsFromContext(r.Context())
+	logs.Info().Msgf("Request for set notification preferences of user - %s has been received", claims.Subject)
+
+	var req models.NotificationPreferences
+	err := json.NewDecoder(r.Body).Decode(&req)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			logs.Error().Msg("request body is empty")
+		} else {
+			logs.Error().Err(err).Msg("failed to decode request body")
+		}
+
+		w.WriteHeader(http.StatusBadRequest) // 400
+		render.JSON(w, r, models.StatusError("incorrect request"))
+		return
+	}
+
+	if err := validator.New().Struct(req); err != nil {
+		validatorErr := err.(validator.ValidationErrors)
+		logs.Error().Err(err).Msg("invalid types")
+
+		w.WriteHeader(http.StatusBadRequest) // 400
+		render.JSON(w, r, models.StatusError(validatorErr.Error()))
+		return
+	}
+
+	if msg := h.check(r.Context(), req); msg != "" {
+		logs.Error().Msgf("Invalid notification preferences - %s", msg)
+
+		w.WriteHeader(http.StatusBadRequest) // 400
+		render.JSON(w, r, models.StatusError(msg))
+		return
+	}
+
+	if req.WebhookURL != "" && req.WebhookSecret == "" {
+		req.WebhookSecret, err = h.webhookSecret(r.Context(), uuid.MustParse(claims.Subject))
+		if err != nil {
+			logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to get webhook secret")
+
+			auth.StorageFailed(w, r, err, "failed to save preferences")
+			return
+		}
+	}
+
+	err = h.storage.SetNotificationPreferences(r.Context(), uuid.MustParse(claims.Subject), &req)
+	if err != nil {
+		if err == storage.ErrUserNotExists {
+			logs.Error().Msgf("User id - %s not found", claims.Subject)
+
+			w.WriteHeader(http.StatusNotFound) // 404
+			render.JSON(w, r, models.StatusError("user id not fount"))
+			return
+		}
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to save notification preferences")
+
+		auth.StorageFailed(w, r, err, "failed to save preferences")
+		return
+	}
+	logs.Info().Msgf("Notification preferences of user - %s saved", claims.Subject)
+
+	w.WriteHeader(http.StatusOK) // 200
+	render.JSON(w, r, models.StatusOK())
+}
+
+// @Summary      Reset notification preferences
+// @Tags         notifications
+// @Description  Сброс настроек уведомлений: все события снова отправляются по каналам по умолчанию.
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200        {object}  models.Response    "Preferences reset"
+// @Failure      401        {object}  models.Response     "Invalid access token"
+// @Failure      404        {object}  models.Response     "User not found"
+// @Failure      500        {object}  models.Response     "Server error(failed reset preferences)"
+// @Failure      504        {object}  models.Response     "Storage didn't answer in time"
+// @Router       /tokenapi/v1/notifications/preferences [delete]
+func (h *Preferences) Reset(w http.ResponseWriter, r *http.Request) {
+	const op = "internal.server.handlers.preferences.Reset()"
+	logs := log.With().Str("fn", op).Logger()
+	claims := auth.ClaimsFromContext(r.Context())
+	logs.Info().Msgf("Request for reset notification preferences of user - %s has been received", claims.Subject)
+
+	err := h.storage.SetNotificationPreferences(r.Context(), uuid.MustParse(claims.Subject), nil)
+	if err != nil {
+		if err == storage.ErrUserNotExists {
+			logs.Error().Msgf("User id - %s not found", claims.Subject)
+
+			w.WriteHeader(http.StatusNotFound) // 404
+			render.JSON(w, r, models.StatusError("user id not fount"))
+			return
+		}
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to reset notification preferences")
+
+		auth.StorageFailed(w, r, err, "failed to reset preferences")
+		return
+	}
+
+	w.WriteHeader(http.StatusOK) // 200
+	render.JSON(w, r, models.StatusOK())
+}
+
+// check returns what is wrong with the preferences, empty if they are valid.
+func (h *Preferences) check(ctx context.Context, preferences models.NotificationPreferences) string {
+	used := map[string]bool{}
+	for event, channels := range preferences.Events {
+		if !knownEvent(event) {
+			return "unknown event " + event
+		}
+		for _, channel := range channels {
+			switch channel {
+			case models.ChannelEmail, models.ChannelWebhook, models.ChannelPush:
+				used[channel] = true
+			default:
+				return "unknown channel " + channel
+			}
+		}
+	}
+
+	if preferences.WebhookURL != "" {
+		// the url is called by the server, plain http would leak the notifications
+		// and internal addresses would let users reach the network of the server
+		err := notification.CheckWebhookURL(ctx, preferences.WebhookURL)
+		if err != nil {
+			_, msg := lib.ErrReader(err)
+			return msg.Error()
+		}
+	}
+	if used[models.ChannelWebhook] && preferences.WebhookURL == "" {
+		return "webhook channel requires webhook url"
+	}
+	if used[models.ChannelPush] {
+		if !h.push {
+			return "push notifications aren't available"
+		}
+		if preferences.PushRecipient == "" {
+			return "push channel requires push recipient"
+		}
+	}
+	return ""
+}
+
+// webhookSecret returns the saved webhook secret of the user, or a new one if there is none.
+func (h *Preferences) webhookSecret(ctx context.Context, userID uuid.UUID) (string, error) {
+	const op = "internal.server.handlers.preferences.webhookSecret()"
+	saved, err := h.storage.GetNotificationPreferences(ctx, userID)
+	if err != nil && err != storage.ErrUserNotExists {
+		return "", fmt.Errorf("%s:%w", op, err)
+	}
+	if saved != nil && saved.WebhookSecret != "" {
+		return saved.WebhookSecret, nil
+	}
+	secret := make([]byte, 32)
+	_, err = rand.Read(secret)
+	if err != nil {
+		return "", fmt.Errorf("%s:%w", op, err)
+	}
+	return "whsec_" + hex.EncodeToString(secret), nil
+}
+
+func knownEvent(event string) bool {
+	for _, known := range notification.Events {
+		if string(known) == event {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,345 @@
+package sessions
+
+import (
+	"context"
+	"fmt"
+	"html/template"
+	"net/http"
+	"time"
+
+	"github.com/go-chi/chi/v5"
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/client/webhook"
+	"github.com/nabishec/tokenapi/internal/lib"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/nabishec/tokenapi/internal/server/handlers/auth"
+	"github.com/nabishec/tokenapi/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+type SessionStorage interface {
+	GetSessions(ctx context.Context, userID uuid.UUID) ([]models.Session, error)
+	RevokeSession(ctx context.Context, userID uuid.UUID, sessionID uuid.UUID, exp time.Time) error
+	RevokeOtherSessions(ctx context.Context, userID uuid.UUID, currentSession uuid.UUID, exp time.Time) (int, error)
+	RevokeUserTokens(ctx context.Context, userID uuid.UUID, exp time.Time) (time.Time, error)
+}
+
+type Sessions struct {
+	storage SessionStorage
+	events  *webhook.Emitter
+	cookie  *auth.RefreshCookie
+}
+
+func NewSessions(storage SessionStorage, events *webhook.Emitter, cookie *auth.RefreshCookie) Sessions {
+	return Sessions{
+		storage: storage,
+		events:  events,
+		cookie:  cookie,
+	}
+}
+
+// clearCookie removes the refresh cookie of the browser whose session ended.
+// It must be called before the response is written.
+func (h *Sessions) clearCookie(w http.ResponseWriter) {
+	if h.cookie.Enabled {
+		h.cookie.Clear(w)
+	}
+}
+
+// @Summary      List sessions
+// @Tags         sessions
+// @Description  Список активных сессий пользователя: устройство, IP, время создания и последнего использования.
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200        {object}  models.Sessions    "Active sessions"
+// @Failure      401        {object}  models.Response     "Invalid access token"
+// @Failure      500        {object}  models.Response     "Server error(failed get sessions)"
+// @Failure      504        {object}  models.Response     "Storage didn't answer in time"
+// @Router       /tokenapi/v1/sessions [get]
+func (h *Sessions) List(w http.ResponseWriter, r *http.Request) {
+	const op = "internal.server.handlers.sessions.List()"
+	logs := log.With().Str("fn", op).Logger()
+	claims := auth.ClaimsFromContext(r.Context())
+	logs.Info().Msgf("Request for sessions of user - %s has been received", claims.Subject)
+
+	sessions, err := h.storage.GetSessions(r.Context(), uuid.MustParse(claims.Subject))
+	if err != nil {
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to get sessions")
+
+		auth.StorageFailed(w, r, err, "failed to get sessions")
+		return
+	}
+	current := currentSession(claims, sessions)
+	for i := range sessions {
+		sessions[i].Current = sessions[i].ID == current
+	}
+
+	w.WriteHeader(http.StatusOK) // 200
+	render.JSON(w, r, models.Sessions{Sessions: sessions})
+}
+
+// @Summary      Revoke session
+// @Tags         sessions
+// @Description  Завершение одной сессии пользователя, access токен сессии перестает приниматься.
+// @Produce      json
+// @Security     BearerAuth
+// @Param        session_id  path     string  true   "Session id"
+// @Success      200        {object}  models.Response    "Session revoked"
+// @Failure      400        {object}  models.Response     "Incorrect session id"
+// @Failure      401        {object}  models.Response     "Invalid access token"
+// @Failure      404        {object}  models.Response     "Session not found"
+// @Failure      500        {object}  models.Response     "Server error(failed revoke session)"
+// @Failure      504        {object}  models.Response     "Storage didn't answer in time"
+// @Router       /tokenapi/v1/sessions/{session_id} [delete]
+func (h *Sessions) Revoke(w http.ResponseWriter, r *http.Request) {
+	const op = "internal.server.handlers.sessions.Revoke()"
+	logs := log.With().Str("fn", op).Logger()
+	claims := auth.ClaimsFromContext(r.Context())
+	logs.Info().Msgf("Request for revoke session of user - %s has been received", claims.Subject)
+
+	sessionID, err := uuid.Parse(chi.URLParam(r, "session_id"))
+	if err != nil {
+		logs.Error().Msg("Failed to receive session id")
+
+		w.WriteHeader(http.StatusBadRequest) // 400
+		render.JSON(w, r, models.StatusError("incorrect value of session id"))
+		return
+	}
+
+	// the current session is found before it is revoked
+	current, err := h.sessionOf(r.Context(), claims)
+	if err != nil {
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to get sessions")
+
+		auth.StorageFailed(w, r, err, "failed to revoke session")
+		return
+	}
+
+	err = h.storage.RevokeSession(r.Context(), uuid.MustParse(claims.Subject), sessionID, time.Now().Add(auth.AccessTokenLifetime))
+	if err != nil {
+		if err == storage.ErrSessionNotExists {
+			logs.Error().Msgf("Session - %s not found", sessionID)
+
+			w.WriteHeader(http.StatusNotFound) // 404
+			render.JSON(w, r, models.StatusError("session not found"))
+			return
+		}
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to revoke session")
+
+		auth.StorageFailed(w, r, err, "failed to revoke session")
+		return
+	}
+	logs.Info().Msgf("Session - %s revoked", sessionID)
+	h.events.Emit(webhook.TokenRevoked(uuid.MustParse(claims.Subject), &sessionID, webhook.RevokedSession))
+	if current == sessionID {
+		h.clearCookie(w)
+	}
+
+	w.WriteHeader(http.StatusOK) // 200
+	render.JSON(w, r, models.StatusOK())
+}
+
+// @Summary      Revoke other sessions
+// @Tags         sessions
+// @Description  Завершение всех сессий пользователя, кроме текущей.
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200        {object}  models.Response    "Sessions revoked"
+// @Failure      401        {object}  models.Response     "Invalid access token"
+// @Failure      500        {object}  models.Response     "Server error(failed revoke sessions)"
+// @Failure      504        {object}  models.Response     "Storage didn't answer in time"
+// @Router       /tokenapi/v1/sessions/others [delete]
+func (h *Sessions) RevokeOthers(w http.ResponseWriter, r *http.Request) {
+	const op = "internal.server.handlers.sessions.RevokeOthers()"
+	logs := log.With().Str("fn", op).Logger()
+	claims := auth.ClaimsFromContext(r.Context())
+	logs.Info().Msgf("Request for revoke other sessions of user - %s has been received", claims.Subject)
+
+	current, err := h.sessionOf(r.Context(), claims)
+	if err != nil {
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to get sessions")
+
+		auth.StorageFailed(w, r, err, "failed to revoke sessions")
+		return
+	}
+	if current == uuid.Nil {
+		logs.Error().Msgf("Session of access token - %s not found", claims.Id)
+
+		w.WriteHeader(http.StatusUnauthorized) // 401
+		render.JSON(w, r, models.StatusError("session of access token not found, refresh tokens"))
+		return
+	}
+
+	revoked, err := h.storage.RevokeOtherSessions(r.Context(), uuid.MustParse(claims.Subject), current, time.Now().Add(auth.AccessTokenLifetime))
+	if err != nil {
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to revoke sessions")
+
+		auth.StorageFailed(w, r, err, "failed to revoke sessions")
+		return
+	}
+	logs.Info().Msgf("%d sessions of user - %s revoked", revoked, claims.Subject)
+	if revoked > 0 {
+		h.events.Emit(webhook.TokenRevoked(uuid.MustParse(claims.Subject), nil, webhook.RevokedOtherSessions))
+	}
+
+	w.WriteHeader(http.StatusOK) // 200
+	render.JSON(w, r, models.StatusOK())
+}
+
+// @Summary      Logout everywhere
+// @Tags         sessions
+// @Description  Завершение всех сессий пользователя, включая текущую. Все выданные ранее токены перестают приниматься.
+// @Produce      json
+// @Security     BearerAuth
+// @Success      200        {object}  models.Response    "All tokens revoked"
+// @Failure      401        {object}  models.Response     "Invalid access token"
+// @Failure      500        {object}  models.Response     "Server error(failed revoke tokens)"
+// @Failure      504        {object}  models.Response     "Storage didn't answer in time"
+// @Router       /tokenapi/v1/sessions [delete]
+func (h *Sessions) RevokeAll(w http.ResponseWriter, r *http.Request) {
+	const op = "internal.server.handlers.sessions.RevokeAll()"
+	logs := log.With().Str("fn", op).Logger()
+	claims := auth.ClaimsFromContext(r.Context())
+	logs.Info().Msgf("Request for logout everywhere of user - %s has been received", claims.Subject)
+
+	_, err := h.storage.RevokeUserTokens(r.Context(), uuid.MustParse(claims.Subject), time.Now().Add(auth.AccessTokenLifetime))
+	if err != nil {
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to revoke tokens")
+
+		auth.StorageFailed(w, r, err, "failed to revoke tokens")
+		return
+	}
+	logs.Info().Msgf("Tokens of user - %s revoked", claims.Subject)
+	h.events.Emit(webhook.TokenRevoked(uuid.MustParse(claims.Subject), nil, webhook.RevokedLogout))
+	h.clearCookie(w)
+
+	w.WriteHeader(http.StatusOK) // 200
+	render.JSON(w, r, models.StatusOK())
+}
+
+// sessionOf returns the session of the access token with claims, uuid.Nil if it isn't found.
+func (h *Sessions) sessionOf(ctx context.Context, claims *auth.JWTClaims) (uuid.UUID, error) {
+	const op = "internal.server.handlers.sessions.sessionOf()"
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err == nil {
+		return sessionID, nil
+	}
+	sessions, err := h.storage.GetSessions(ctx, uuid.MustParse(claims.Subject))
+	if err != nil {
+		return uuid.Nil, fmt.Errorf("%s:%w", op, err)
+	}
+	return currentSession(claims, sessions), nil
+}
+
+// currentSession returns the session of the access token with claims among sessions.
+// Access tokens issued before they had a sid are matched by jti, which only
+// the newest access token of a session has.
+func currentSession(claims *auth.JWTClaims, sessions []models.Session) uuid.UUID {
+	sessionID, err := uuid.Parse(claims.SessionID)
+	if err == nil {
+		return sessionID
+	}
+	for _, session := range sessions {
+		if session.JTI == claims.Id {
+			return session.ID
+		}
+	}
+	return uuid.Nil
+}
+
+var revokePage = template.Must(template.New("revoke").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Sign out everywhere / Выйти на всех устройствах</title></head>
+<body style="font-family: Arial, sans-serif;">
+{{if .Done}}<p>All sessions have been ended. Sign in again and change your password.</p>
+<p>Все сессии завершены. Войдите заново и смените пароль.</p>
+{{else}}<p>Sign out of your account on all devices?</p>
+<p>Выйти из аккаунта на всех устройствах?</p>
+<form method="post"><input type="hidden" name="token" value="{{.Token}}">
+<button type="submit">Sign out everywhere / Выйти везде</button></form>
+{{end}}</body>
+</html>`))
+
+// @Summary      Confirm revoke by link
+// @Tags         sessions
+// @Description  Страница подтверждения для ссылки "это был не я" из уведомлений. Сама страница ничего не отзывает, чтобы предпросмотр ссылок почтовыми клиентами не завершал сессии.
+// @Produce      html
+// @Param        token  query     string  true   "Token of the link"
+// @Success      200        {string}  string    "Confirmation page"
+// @Failure      400        {object}  models.Response     "Invalid or expired link"
+// @Router       /tokenapi/v1/auth/revoke [get]
+func (h *Sessions) ConfirmRevokeLink(w http.ResponseWriter, r *http.Request) {
+	const op = "internal.server.handlers.sessions.ConfirmRevokeLink()"
+	logs := log.With().Str("fn", op).Logger()
+	logs.Info().Msg("Request for revoke link page has been received")
+
+	token := r.URL.Query().Get("token")
+	_, err := auth.VerifyRevokeToken(token)
+	if err != nil {
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Invalid revoke link")
+
+		w.WriteHeader(http.StatusBadRequest) // 400
+		render.JSON(w, r, models.StatusError("invalid or expired link"))
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK) // 200
+	revokePage.Execute(w, struct {
+		Done  bool
+		Token string
+	}{Token: token})
+}
+
+// @Summary      Revoke by link
+// @Tags         sessions
+// @Description  Отзыв всех токенов пользователя по ссылке "это был не я" из уведомлений.
+// @Accept       x-www-form-urlencoded
+// @Produce      html
+// @Param        token  formData  string  true   "Token of the link"
+// @Success      200        {string}  string    "All tokens revoked"
+// @Failure      400        {object}  models.Response     "Invalid or expired link"
+// @Failure      404        {object}  models.Response     "User not found"
+// @Failure      500        {object}  models.Response     "Server error(failed revoke tokens)"
+// @Failure      504        {object}  models.Response     "Storage didn't answer in time"
+// @Router       /tokenapi/v1/auth/revoke [post]
+func (h *Sessions) RevokeByLink(w http.ResponseWriter, r *http.Request) {
+	const op = "internal.server.handlers.sessions.RevokeByLink()"
+	logs := log.With().Str("fn", op).Logger()
+	logs.Info().Msg("Request for revoke by link has been received")
+
+	userID, err := auth.VerifyRevokeToken(r.PostFormValue("token"))
+	if err != nil {
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Invalid revoke link")
+
+		w.WriteHeader(http.StatusBadRequest) // 400
+		render.JSON(w, r, models.StatusError("invalid or expired link"))
+		return
+	}
+
+	_, err = h.storage.RevokeUserTokens(r.Context(), userID, time.Now().Add(auth.AccessTokenLifetime))
+	if err != nil {
+		if err == storage.ErrUserNotExists {
+			logs.Error().Msgf("User id - %s not found", userID)
+
+			w.WriteHeader(http.StatusNotFound) // 404
+			render.JSON(w, r, models.StatusError("user id not fount"))
+			return
+		}
+		logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to revoke tokens")
+
+		auth.StorageFailed(w, r, err, "failed to revoke tokens")
+		return
+	}
+	logs.Info().Msgf("Tokens of user - %s revoked by link", userID)
+	h.events.Emit(webhook.TokenRevoked(userID, nil, webhook.RevokedLink))
+	h.clearCookie(w)
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(http.StatusOK) // 200
+	revokePage.Execute(w, struct {
+		Done  bool
+		Token string
+	}{Done: true})
+}
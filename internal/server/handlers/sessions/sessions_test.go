@@ -0,0 +1,89 @@
+package sessions
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/client/webhook"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/nabishec/tokenapi/internal/server/handlers/auth"
+	"github.com/nabishec/tokenapi/internal/storage/memory"
+)
+
+func TestOlderAccessTokenKeepsItsSession(t *testing.T) {
+	auth.SigningKey = []byte("test signing key")
+	format, err := auth.GetTokenFormat(auth.FormatJWT)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := memory.New()
+	userID := uuid.New()
+	store.AddUser(userID, "user@example.com")
+
+	// the session was refreshed, so the row has the jti of its newest access token
+	current, other := uuid.New(), uuid.New()
+	older, _, err := auth.CreateAccessToken(format, "", userID.String(), current, "203.0.113.7", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, newerJTI, err := auth.CreateAccessToken(format, "", userID.String(), current, "203.0.113.7", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for sessionID, jti := range map[uuid.UUID]string{current: newerJTI, other: uuid.NewString()} {
+		err = store.AddNewToken(context.Background(), models.RefreshToken{
+			Hash:      "hash-" + jti,
+			UserID:    userID,
+			JTI:       jti,
+			Exp:       time.Now().Add(auth.RefreshTokenLifetime),
+			SessionID: sessionID,
+			CreatedAt: time.Now(),
+			Selector:  jti,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h := NewSessions(store, webhook.NewEmitter(store), &auth.RefreshCookie{})
+	authenticator := auth.NewAuthenticator(store, auth.NewDPoP(store))
+	serve := func(method string, handler http.HandlerFunc) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "/tokenapi/v1/sessions", nil)
+		r.Header.Set("Authorization", auth.TokenTypeBearer+" "+older)
+		w := httptest.NewRecorder()
+		authenticator.Authenticate(handler).ServeHTTP(w, r)
+		return w
+	}
+
+	w := serve(http.MethodGet, h.List)
+	if w.Code != http.StatusOK {
+		t.Fatalf("list: status %d", w.Code)
+	}
+	var list models.Sessions
+	err = json.Unmarshal(w.Body.Bytes(), &list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, session := range list.Sessions {
+		if session.Current != (session.ID == current) {
+			t.Fatalf("session %s current = %v", session.ID, session.Current)
+		}
+	}
+
+	w = serve(http.MethodDelete, h.RevokeOthers)
+	if w.Code != http.StatusOK {
+		t.Fatalf("revoke others: status %d", w.Code)
+	}
+	sessions, err := store.GetSessions(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 || sessions[0].ID != current {
+		t.Fatalf("sessions left %+v, want only %s", sessions, current)
+	}
+}
@@ -0,0 +1,84 @@
+package ratelimit
+
+import (
+	"container/list"
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Memory keeps buckets in the process memory. It suits single instance deployments.
+type Memory struct {
+	mu      sync.Mutex
+	buckets map[string]*list.Element
+	// recent orders the buckets from the most to the least recently used one
+	recent     *list.List
+	maxBuckets int
+}
+
+type bucket struct {
+	key     string
+	tokens  float64
+	updated time.Time
+}
+
+// NewMemory creates the backend keeping at most maxBuckets buckets, 0 means no limit.
+// Keys come from requests, so the limit keeps clients from growing the map without end:
+// the least recently used bucket makes room for a new one.
+func NewMemory(maxBuckets int) *Memory {
+	return &Memory{
+		buckets:    make(map[string]*list.Element),
+		recent:     list.New(),
+		maxBuckets: maxBuckets,
+	}
+}
+
+func (m *Memory) TakeRateToken(ctx context.Context, key string, rate float64, burst int) (float64, bool, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var b *bucket
+	if elem, ok := m.buckets[key]; ok {
+		m.recent.MoveToFront(elem)
+		b = elem.Value.(*bucket)
+	} else {
+		if m.maxBuckets > 0 && len(m.buckets) >= m.maxBuckets {
+			m.remove(m.recent.Back())
+		}
+		b = &bucket{
+			key:     key,
+			tokens:  float64(burst),
+			updated: now,
+		}
+		m.buckets[key] = m.recent.PushFront(b)
+	}
+
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+	if b.tokens < 1 {
+		return b.tokens, false, nil
+	}
+	b.tokens--
+	return b.tokens, true, nil
+}
+
+func (m *Memory) DeleteIdleRateLimits(idle time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// the least recently used buckets are at the back, so the sweep stops at the first busy one
+	for elem := m.recent.Back(); elem != nil; elem = m.recent.Back() {
+		if time.Since(elem.Value.(*bucket).updated) <= idle {
+			break
+		}
+		m.remove(elem)
+	}
+	return nil
+}
+
+func (m *Memory) remove(elem *list.Element) {
+	m.recent.Remove(elem)
+	delete(m.buckets, elem.Value.(*bucket).key)
+}
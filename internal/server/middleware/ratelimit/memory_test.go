@@ -0,0 +1,67 @@
+package ratelimit
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryEvictsLeastRecentlyUsed(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(2)
+	take := func(key string) bool {
+		_, allowed, err := m.TakeRateToken(ctx, key, 1.0/60, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return allowed
+	}
+
+	take("a")
+	take("b")
+	if take("a") {
+		t.Fatal("empty bucket allowed a request")
+	}
+	// a was used last, so b makes room for c
+	take("c")
+	if len(m.buckets) != 2 || m.recent.Len() != 2 {
+		t.Fatalf("%d buckets, %d in the list, want 2", len(m.buckets), m.recent.Len())
+	}
+	if _, ok := m.buckets["b"]; ok {
+		t.Fatal("recently used bucket was evicted instead of the least recently used one")
+	}
+	if take("a") {
+		t.Fatal("bucket of a was evicted")
+	}
+	// an evicted bucket starts full again
+	if !take("b") {
+		t.Fatal("evicted bucket wasn't recreated")
+	}
+}
+
+func TestMemoryDeleteIdleRateLimits(t *testing.T) {
+	ctx := context.Background()
+	m := NewMemory(0)
+	for _, key := range []string{"old", "older", "new"} {
+		_, _, err := m.TakeRateToken(ctx, key, 1, 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	m.buckets["old"].Value.(*bucket).updated = time.Now().Add(-2 * time.Hour)
+	m.buckets["older"].Value.(*bucket).updated = time.Now().Add(-3 * time.Hour)
+	// keep the list in the order of use
+	m.recent.MoveToBack(m.buckets["old"])
+	m.recent.MoveToBack(m.buckets["older"])
+
+	err := m.DeleteIdleRateLimits(time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(m.buckets) != 1 || m.recent.Len() != 1 {
+		t.Fatalf("%d buckets, %d in the list, want 1", len(m.buckets), m.recent.Len())
+	}
+	if _, ok := m.buckets["new"]; !ok {
+		t.Fatal("busy bucket was deleted")
+	}
+}
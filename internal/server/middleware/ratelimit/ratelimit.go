@@ -0,0 +1,222 @@
+package ratelimit
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-chi/render"
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/lib"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/nabishec/tokenapi/internal/server/handlers/auth"
+	"github.com/rs/zerolog/log"
+)
+
+// Backend keeps token buckets. Implementations must refill and take
+// a token atomically, so that the backend can be shared by several instances.
+type Backend interface {
+	// TakeRateToken refills the bucket of key with rate tokens per second up to burst
+	// and takes one token from it if possible. It returns the tokens left in the bucket.
+	TakeRateToken(ctx context.Context, key string, rate float64, burst int) (tokens float64, allowed bool, err error)
+	DeleteIdleRateLimits(idle time.Duration) error
+}
+
+// KeyFunc returns the key that requests are limited by,
+// an empty key means that the rule doesn't apply to the request.
+type KeyFunc func(r *http.Request) (string, error)
+
+// Limit allows Requests per Period with bursts up to Burst requests.
+type Limit struct {
+	Requests int
+	Period   time.Duration
+	Burst    int
+}
+
+type Rule struct {
+	Scope string
+	Key   KeyFunc
+	Limit Limit
+}
+
+type Limiter struct {
+	backend Backend
+}
+
+func New(backend Backend) *Limiter {
+	return &Limiter{
+		backend: backend,
+	}
+}
+
+// ParseLimit parses limits like "10/1m" or "10/1m:20", where 20 is the burst.
+// The burst defaults to the number of requests.
+func ParseLimit(value string) (Limit, error) {
+	const op = "internal.server.middleware.ratelimit.ParseLimit()"
+	var limit Limit
+	value, burst, hasBurst := strings.Cut(value, ":")
+	requests, period, found := strings.Cut(value, "/")
+	if !found {
+		return limit, fmt.Errorf("%s:%s", op, "limit must look like requests/period")
+	}
+
+	var err error
+	limit.Requests, err = strconv.Atoi(requests)
+	if err != nil || limit.Requests <= 0 {
+		return limit, fmt.Errorf("%s:%s", op, "invalid number of requests")
+	}
+	limit.Period, err = time.ParseDuration(period)
+	if err != nil || limit.Period <= 0 {
+		return limit, fmt.Errorf("%s:%s", op, "invalid period")
+	}
+	limit.Burst = limit.Requests
+	if hasBurst {
+		limit.Burst, err = strconv.Atoi(burst)
+		if err != nil || limit.Burst <= 0 {
+			return limit, fmt.Errorf("%s:%s", op, "invalid burst")
+		}
+	}
+	return limit, nil
+}
+
+// RuleFromEnv builds a rule with the limit from the env variable name.
+// The rule is disabled when the variable isn't set or is invalid.
+func RuleFromEnv(name string, scope string, key KeyFunc) Rule {
+	rule := Rule{
+		Scope: scope,
+		Key:   key,
+	}
+	value := os.Getenv(name)
+	if value == "" {
+		return rule
+	}
+	limit, err := ParseLimit(value)
+	if err != nil {
+		log.Warn().AnErr(lib.ErrReader(err)).Msgf("%s has invalid value, rule is disabled", name)
+		return rule
+	}
+	rule.Limit = limit
+	return rule
+}
+
+// ByIP limits requests by the client IP.
+func ByIP(r *http.Request) (string, error) {
+	return auth.GetIP(r)
+}
+
+// ByClientID limits requests by the client. The user GUID of a verified client certificate
+// is preferred, otherwise the client_id query parameter is used. Values that aren't
+// a GUID get no bucket, the handler rejects them anyway.
+func ByClientID(r *http.Request) (string, error) {
+	if cert := auth.ClientCertificate(r); cert != nil {
+		userGUID, err := auth.CertificateClientID(cert)
+		if err == nil {
+			return userGUID.String(), nil
+		}
+	}
+	userGUID, err := uuid.Parse(r.URL.Query().Get("client_id"))
+	if err != nil || userGUID == uuid.Nil {
+		return "", nil
+	}
+	// the canonical form keeps spellings of one GUID in one bucket
+	return userGUID.String(), nil
+}
+
+func (l Limit) rate() float64 {
+	return float64(l.Requests) / l.Period.Seconds()
+}
+
+// Limit returns middleware applying rules to the requests of route.
+// Requests are allowed only when every rule allows them.
+func (l *Limiter) Limit(route string, rules ...Rule) func(http.Handler) http.Handler {
+	var active []Rule
+	for _, rule := range rules {
+		if rule.Limit.Requests > 0 {
+			active = append(active, rule)
+		}
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			const op = "internal.server.middleware.ratelimit.Limit()"
+			logs := log.With().Str("fn", op).Logger()
+
+			var (
+				limit      Limit
+				remaining  = math.MaxInt
+				reset      time.Duration
+				retryAfter time.Duration
+				denied     bool
+			)
+			for _, rule := range active {
+				key, err := rule.Key(r)
+				if err != nil {
+					logs.Error().AnErr(lib.ErrReader(err)).Msgf("Failed to get %s key", rule.Scope)
+					continue
+				}
+				if key == "" {
+					continue
+				}
+
+				rate := rule.Limit.rate()
+				tokens, allowed, err := l.backend.TakeRateToken(r.Context(), route+":"+rule.Scope+":"+key, rate, rule.Limit.Burst)
+				if err != nil {
+					// a broken backend shouldn't take the api down
+					logs.Error().AnErr(lib.ErrReader(err)).Msg("Failed to take rate token")
+					continue
+				}
+
+				if !allowed {
+					denied = true
+					wait := time.Duration((1 - tokens) / rate * float64(time.Second))
+					if wait > retryAfter {
+						retryAfter = wait
+					}
+					logs.Info().Msgf("Rate limit of %s exceeded by %s - %s", route, rule.Scope, key)
+				}
+				if int(tokens) < remaining {
+					limit = rule.Limit
+					remaining = int(tokens)
+					reset = time.Duration((float64(rule.Limit.Burst) - tokens) / rate * float64(time.Second))
+				}
+			}
+
+			if remaining != math.MaxInt {
+				w.Header().Set("RateLimit-Limit", strconv.Itoa(limit.Burst))
+				w.Header().Set("RateLimit-Remaining", strconv.Itoa(remaining))
+				w.Header().Set("RateLimit-Reset", seconds(reset))
+			}
+			if denied {
+				w.Header().Set("Retry-After", seconds(retryAfter))
+				w.WriteHeader(http.StatusTooManyRequests) // 429
+				render.JSON(w, r, models.StatusError("too many requests"))
+				return
+			}
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// SweepEvery deletes buckets idle longer than idle every interval.
+// It never returns and is meant to be run in its own goroutine.
+func (l *Limiter) SweepEvery(interval time.Duration, idle time.Duration) {
+	const op = "internal.server.middleware.ratelimit.SweepEvery()"
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		err := l.backend.DeleteIdleRateLimits(idle)
+		if err != nil {
+			log.Error().Str("fn", op).AnErr(lib.ErrReader(err)).Msg("Failed to delete idle rate limits")
+		}
+	}
+}
+
+func seconds(d time.Duration) string {
+	return strconv.Itoa(int(math.Ceil(d.Seconds())))
+}
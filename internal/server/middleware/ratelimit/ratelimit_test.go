@@ -0,0 +1,80 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestParseLimit(t *testing.T) {
+	for value, want := range map[string]Limit{
+		"10/1m":    {Requests: 10, Period: time.Minute, Burst: 10},
+		"10/1m:20": {Requests: 10, Period: time.Minute, Burst: 20},
+		"1/500ms":  {Requests: 1, Period: 500 * time.Millisecond, Burst: 1},
+	} {
+		got, err := ParseLimit(value)
+		if err != nil || got != want {
+			t.Errorf("ParseLimit(%q) = %+v, %v, want %+v", value, got, err, want)
+		}
+	}
+	for _, value := range []string{"", "10", "10/", "/1m", "0/1m", "-1/1m", "x/1m", "10/0s", "10/-1m", "10/1x", "10/1m:", "10/1m:0", "10/1m:x"} {
+		_, err := ParseLimit(value)
+		if err == nil {
+			t.Errorf("ParseLimit(%q) was accepted", value)
+		}
+	}
+}
+
+func TestLimitHeaders(t *testing.T) {
+	key := func(r *http.Request) (string, error) { return r.Header.Get("X-Key"), nil }
+	rules := []Rule{
+		{Scope: "key", Key: key, Limit: Limit{Requests: 2, Period: time.Minute, Burst: 2}},
+		{Scope: "off", Key: key},
+	}
+	handler := New(NewMemory(0)).Limit("test", rules...)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	serve := func(key string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/", nil)
+		r.Header.Set("X-Key", key)
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, r)
+		return w
+	}
+
+	for i, want := range []struct {
+		status     int
+		remaining  string
+		reset      string
+		retryAfter string
+	}{
+		{status: http.StatusOK, remaining: "1", reset: "30"},
+		{status: http.StatusOK, remaining: "0", reset: "60"},
+		{status: http.StatusTooManyRequests, remaining: "0", reset: "60", retryAfter: "30"},
+	} {
+		w := serve("a")
+		if w.Code != want.status {
+			t.Fatalf("request %d: status %d, want %d", i, w.Code, want.status)
+		}
+		for name, value := range map[string]string{
+			"RateLimit-Limit":     "2",
+			"RateLimit-Remaining": want.remaining,
+			"RateLimit-Reset":     want.reset,
+			"Retry-After":         want.retryAfter,
+		} {
+			if got := w.Header().Get(name); got != value {
+				t.Errorf("request %d: %s = %q, want %q", i, name, got, value)
+			}
+		}
+	}
+
+	// another key has its own bucket
+	w := serve("b")
+	if w.Code != http.StatusOK || w.Header().Get("RateLimit-Remaining") != "1" {
+		t.Fatalf("other key: status %d, remaining %q", w.Code, w.Header().Get("RateLimit-Remaining"))
+	}
+	// requests without a key aren't limited and get no headers
+	w = serve("")
+	if w.Code != http.StatusOK || w.Header().Get("RateLimit-Limit") != "" {
+		t.Fatalf("no key: status %d, limit %q", w.Code, w.Header().Get("RateLimit-Limit"))
+	}
+}
@@ -0,0 +1,52 @@
+package mtls
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"os"
+)
+
+// ServerTLSConfig builds the tls config of the server from env variables.
+// It returns nil when TLS_CERT_FILE isn't set and the server should serve plain http.
+// With TLS_CLIENT_CA_FILE client certificates are verified against that CA,
+// TLS_CLIENT_AUTH=require rejects connections without a certificate.
+func ServerTLSConfig() (*tls.Config, error) {
+	const op = "internal.server.mtls.ServerTLSConfig()"
+	certFile := os.Getenv("TLS_CERT_FILE")
+	if certFile == "" {
+		return nil, nil
+	}
+
+	cert, err := tls.LoadX509KeyPair(certFile, os.Getenv("TLS_KEY_FILE"))
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	config := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		Certificates: []tls.Certificate{cert},
+	}
+
+	caFile := os.Getenv("TLS_CLIENT_CA_FILE")
+	if caFile == "" {
+		return config, nil
+	}
+	caPEM, err := os.ReadFile(caFile)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	config.ClientCAs = x509.NewCertPool()
+	if !config.ClientCAs.AppendCertsFromPEM(caPEM) {
+		return nil, fmt.Errorf("%s:%s", op, "no certificates found in client ca file")
+	}
+
+	switch clientAuth := os.Getenv("TLS_CLIENT_AUTH"); clientAuth {
+	case "", "request":
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	case "require":
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	default:
+		return nil, fmt.Errorf("%s:unknown client auth %q", op, clientAuth)
+	}
+	return config, nil
+}
@@ -0,0 +1,174 @@
+package memory
+
+import (
+	"context"
+	"math"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/models"
+)
+
+// AddFailure increments the failure counter of key and returns its new value.
+// The counter starts over when the previous failure is older than window.
+func (m *Memory) AddFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	const op = "internal.storage.memory.AddFailure()"
+	err := done(ctx, op)
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	f, ok := m.failures[key]
+	if !ok {
+		f = &failure{}
+		m.failures[key] = f
+	}
+	if f.lastFailure.Before(now.Add(-window)) {
+		f.failures = 0
+	}
+	f.failures++
+	f.lastFailure = now
+	return f.failures, nil
+}
+
+func (m *Memory) SetLockout(ctx context.Context, key string, until time.Time) error {
+	const op = "internal.storage.memory.SetLockout()"
+	err := done(ctx, op)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if f, ok := m.failures[key]; ok {
+		f.lockedUntil = until
+	}
+	return nil
+}
+
+// GetLockout returns the end of the lockout of key, or zero time if key isn't locked.
+func (m *Memory) GetLockout(ctx context.Context, key string) (time.Time, error) {
+	const op = "internal.storage.memory.GetLockout()"
+	err := done(ctx, op)
+	if err != nil {
+		return time.Time{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	f, ok := m.failures[key]
+	if !ok {
+		return time.Time{}, nil
+	}
+	return f.lockedUntil, nil
+}
+
+func (m *Memory) DeleteFailures(ctx context.Context, key string) error {
+	const op = "internal.storage.memory.DeleteFailures()"
+	err := done(ctx, op)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.failures, key)
+	return nil
+}
+
+func (m *Memory) TakeRateToken(ctx context.Context, key string, rate float64, burst int) (float64, bool, error) {
+	const op = "internal.storage.memory.TakeRateToken()"
+	err := done(ctx, op)
+	if err != nil {
+		return 0, false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	b, ok := m.buckets[key]
+	if !ok {
+		b = &bucket{
+			tokens:  float64(burst),
+			updated: now,
+		}
+		m.buckets[key] = b
+	}
+
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.updated).Seconds()*rate)
+	b.updated = now
+	if b.tokens < 1 {
+		return b.tokens, false, nil
+	}
+	b.tokens--
+	return b.tokens, true, nil
+}
+
+func (m *Memory) DeleteIdleRateLimits(idle time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key, b := range m.buckets {
+		if time.Since(b.updated) > idle {
+			delete(m.buckets, key)
+		}
+	}
+	return nil
+}
+
+// GetLogins returns the last logins of the user, the newest first.
+func (m *Memory) GetLogins(ctx context.Context, userID uuid.UUID, limit int) ([]models.Login, error) {
+	const op = "internal.storage.memory.GetLogins()"
+	err := done(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	logins := []models.Login{}
+	for _, login := range m.logins {
+		if login.UserID == userID {
+			logins = append(logins, login)
+		}
+	}
+	sort.SliceStable(logins, func(i, j int) bool {
+		return logins[i].CreatedAt.After(logins[j].CreatedAt)
+	})
+	if len(logins) > limit {
+		logins = logins[:limit]
+	}
+	return logins, nil
+}
+
+// AddLogin saves the login and keeps only the last keep logins of the user.
+func (m *Memory) AddLogin(ctx context.Context, login models.Login, keep int) error {
+	const op = "internal.storage.memory.AddLogin()"
+	err := done(ctx, op)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var userLogins, others []models.Login
+	for _, l := range append(m.logins, login) {
+		if l.UserID == login.UserID {
+			userLogins = append(userLogins, l)
+			continue
+		}
+		others = append(others, l)
+	}
+	sort.SliceStable(userLogins, func(i, j int) bool {
+		return userLogins[i].CreatedAt.After(userLogins[j].CreatedAt)
+	})
+	if len(userLogins) > keep {
+		userLogins = userLogins[:max(keep, 0)]
+	}
+	m.logins = append(others, userLogins...)
+	return nil
+}
@@ -0,0 +1,277 @@
+// Package memory keeps the storage of the api in the process memory.
+// Everything is lost on restart, it suits development, demos and single instance tests.
+package memory
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/nabishec/tokenapi/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+var _ storage.Storage = (*Memory)(nil)
+
+type user struct {
+	mail          string
+	locale        string
+	tokenFormat   string
+	revokedBefore time.Time
+	preferences   *models.NotificationPreferences
+}
+
+type failure struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+type throttleKey struct {
+	userID uuid.UUID
+	event  string
+}
+
+type throttle struct {
+	windowStart     time.Time
+	windowEnd       time.Time
+	suppressed      int
+	ips             []string
+	firstSuppressed time.Time
+	payload         []byte
+}
+
+// Memory implements storage.Storage with maps guarded by one mutex,
+// so every method is atomic like a transaction of the database.
+type Memory struct {
+	mu            sync.Mutex
+	users         map[uuid.UUID]*user
+	tokens        []models.RefreshToken
+	revoked       map[string]time.Time
+	dpop          map[string]time.Time
+	failures      map[string]*failure
+	buckets       map[string]*bucket
+	logins        []models.Login
+	notifications []*models.Notification
+	throttles     map[throttleKey]*throttle
+	webhooks      []*models.Webhook
+	deliveries    []*models.WebhookDelivery
+	lastID        int64
+}
+
+func New() *Memory {
+	return &Memory{
+		users:     make(map[uuid.UUID]*user),
+		revoked:   make(map[string]time.Time),
+		dpop:      make(map[string]time.Time),
+		failures:  make(map[string]*failure),
+		buckets:   make(map[string]*bucket),
+		throttles: make(map[throttleKey]*throttle),
+	}
+}
+
+// NewFromEnv creates the storage with the users from MEMORY_USERS,
+// a comma-separated list of guid=mail pairs.
+func NewFromEnv() (*Memory, error) {
+	const op = "internal.storage.memory.NewFromEnv()"
+	m := New()
+	users := os.Getenv("MEMORY_USERS")
+	for _, pair := range strings.Split(users, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		guid, mail, ok := strings.Cut(pair, "=")
+		if !ok {
+			return nil, fmt.Errorf("%s:%s", op, "MEMORY_USERS must be a list of guid=mail")
+		}
+		userID, err := uuid.Parse(strings.TrimSpace(guid))
+		if err != nil {
+			return nil, fmt.Errorf("%s:%s", op, "invalid user GUID in MEMORY_USERS")
+		}
+		m.AddUser(userID, strings.TrimSpace(mail))
+	}
+	log.Info().Msgf("Memory storage created with %d users", len(m.users))
+	return m, nil
+}
+
+// AddUser adds the user or changes the mail of an existing one.
+func (m *Memory) AddUser(userID uuid.UUID, mail string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if u, ok := m.users[userID]; ok {
+		u.mail = mail
+		return
+	}
+	m.users[userID] = &user{mail: mail}
+}
+
+// done returns the error of the canceled or expired ctx. The memory is never slow,
+// but callers expect the same errors as from the database.
+func done(ctx context.Context, op string) error {
+	err := ctx.Err()
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	return nil
+}
+
+// Healthy always reports true, the memory is always available.
+func (m *Memory) Healthy() bool {
+	return true
+}
+
+// nextID returns the next id of notifications and deliveries.
+func (m *Memory) nextID() int64 {
+	m.lastID++
+	return m.lastID
+}
+
+func (m *Memory) GetMail(ctx context.Context, userID uuid.UUID) (string, error) {
+	const op = "internal.storage.memory.GetMail()"
+	err := done(ctx, op)
+	if err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return "", storage.ErrUserNotExists
+	}
+	return u.mail, nil
+}
+
+func (m *Memory) GetLocale(ctx context.Context, userID uuid.UUID) (string, error) {
+	const op = "internal.storage.memory.GetLocale()"
+	err := done(ctx, op)
+	if err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return "", storage.ErrUserNotExists
+	}
+	return u.locale, nil
+}
+
+// GetTokenFormat returns the access token format of the user, empty if the user
+// has no format of their own or doesn't exist.
+func (m *Memory) GetTokenFormat(ctx context.Context, userID uuid.UUID) (string, error) {
+	const op = "internal.storage.memory.GetTokenFormat()"
+	err := done(ctx, op)
+	if err != nil {
+		return "", err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return "", nil
+	}
+	return u.tokenFormat, nil
+}
+
+func (m *Memory) SetTokenFormat(ctx context.Context, userID uuid.UUID, format string) error {
+	const op = "internal.storage.memory.SetTokenFormat()"
+	err := done(ctx, op)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return storage.ErrUserNotExists
+	}
+	u.tokenFormat = format
+	return nil
+}
+
+func (m *Memory) GetNotificationPreferences(ctx context.Context, userID uuid.UUID) (*models.NotificationPreferences, error) {
+	const op = "internal.storage.memory.GetNotificationPreferences()"
+	err := done(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return nil, storage.ErrUserNotExists
+	}
+	return copyPreferences(u.preferences), nil
+}
+
+func (m *Memory) SetNotificationPreferences(ctx context.Context, userID uuid.UUID, preferences *models.NotificationPreferences) error {
+	const op = "internal.storage.memory.SetNotificationPreferences()"
+	err := done(ctx, op)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return storage.ErrUserNotExists
+	}
+	u.preferences = copyPreferences(preferences)
+	return nil
+}
+
+func (m *Memory) GetRevokedBefore(ctx context.Context, userID uuid.UUID) (time.Time, error) {
+	const op = "internal.storage.memory.GetRevokedBefore()"
+	err := done(ctx, op)
+	if err != nil {
+		return time.Time{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return time.Time{}, storage.ErrUserNotExists
+	}
+	return u.revokedBefore, nil
+}
+
+// copyPreferences keeps callers from changing the saved preferences.
+func copyPreferences(preferences *models.NotificationPreferences) *models.NotificationPreferences {
+	if preferences == nil {
+		return nil
+	}
+	result := *preferences
+	if preferences.Events != nil {
+		result.Events = make(map[string][]string, len(preferences.Events))
+		for event, channels := range preferences.Events {
+			result.Events[event] = append([]string(nil), channels...)
+		}
+	}
+	return &result
+}
+
+func copyBytes(b []byte) []byte {
+	if b == nil {
+		return nil
+	}
+	return append([]byte(nil), b...)
+}
@@ -0,0 +1,239 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/nabishec/tokenapi/internal/models"
+)
+
+// maxDigestIPs limits the IPs remembered for a digest, the count is kept anyway.
+const maxDigestIPs = 10
+
+func (m *Memory) EnqueueNotifications(notifications ...models.Notification) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.enqueueNotifications(notifications)
+	return nil
+}
+
+func (m *Memory) enqueueNotifications(notifications []models.Notification) {
+	now := time.Now()
+	for _, notification := range notifications {
+		m.notifications = append(m.notifications, &models.Notification{
+			ID:          m.nextID(),
+			Event:       notification.Event,
+			UserID:      notification.UserID,
+			Payload:     copyBytes(notification.Payload),
+			Status:      models.NotificationPending,
+			NextAttempt: now,
+			CreatedAt:   now,
+		})
+	}
+}
+
+// ClaimNotifications returns up to limit pending notifications that are due and counts
+// the attempt. Claimed notifications aren't due again until lease passes.
+func (m *Memory) ClaimNotifications(limit int, lease time.Duration) ([]models.Notification, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var due []*models.Notification
+	for _, notification := range m.notifications {
+		if notification.Status == models.NotificationPending && !notification.NextAttempt.After(now) {
+			due = append(due, notification)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextAttempt.Before(due[j].NextAttempt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	var notifications []models.Notification
+	for _, notification := range due {
+		notification.Attempts++
+		notification.NextAttempt = now.Add(lease)
+		notifications = append(notifications, copyNotification(notification))
+	}
+	return notifications, nil
+}
+
+func (m *Memory) CompleteNotification(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if notification := m.notification(id); notification != nil {
+		now := time.Now()
+		notification.Status = models.NotificationDelivered
+		notification.DeliveredAt = &now
+		notification.LastError = ""
+	}
+	return nil
+}
+
+// FailNotification schedules the next attempt of the notification, or moves it to the dead letters.
+func (m *Memory) FailNotification(id int64, nextAttempt time.Time, dead bool, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if notification := m.notification(id); notification != nil {
+		notification.Status = models.NotificationPending
+		if dead {
+			notification.Status = models.NotificationDead
+		}
+		notification.NextAttempt = nextAttempt
+		notification.LastError = lastError
+	}
+	return nil
+}
+
+// SuppressNotification marks the notification as throttled, it is reported in a digest instead.
+func (m *Memory) SuppressNotification(id int64) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if notification := m.notification(id); notification != nil {
+		notification.Status = models.NotificationThrottled
+		notification.LastError = ""
+	}
+	return nil
+}
+
+// GetNotifications lists notifications with the status, newest first, all statuses if it is empty.
+func (m *Memory) GetNotifications(ctx context.Context, status string, limit int) ([]models.Notification, error) {
+	const op = "internal.storage.memory.GetNotifications()"
+	err := done(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	notifications := []models.Notification{}
+	// notifications are appended in order of creation
+	for i := len(m.notifications) - 1; i >= 0 && len(notifications) < limit; i-- {
+		if status == "" || m.notifications[i].Status == status {
+			notifications = append(notifications, copyNotification(m.notifications[i]))
+		}
+	}
+	return notifications, nil
+}
+
+func (m *Memory) notification(id int64) *models.Notification {
+	for _, notification := range m.notifications {
+		if notification.ID == id {
+			return notification
+		}
+	}
+	return nil
+}
+
+func copyNotification(notification *models.Notification) models.Notification {
+	result := *notification
+	result.Payload = copyBytes(notification.Payload)
+	if notification.DeliveredAt != nil {
+		deliveredAt := *notification.DeliveredAt
+		result.DeliveredAt = &deliveredAt
+	}
+	return result
+}
+
+// ThrottleNotification allows one notification of the user about the event per window,
+// the others are counted for a digest, see the database implementation.
+func (m *Memory) ThrottleNotification(notification models.Notification, ip string, window time.Duration) (bool, *models.NotificationDigest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	key := throttleKey{userID: notification.UserID, event: notification.Event}
+	t, ok := m.throttles[key]
+	if !ok {
+		t = &throttle{
+			windowStart: now,
+			windowEnd:   now,
+		}
+		m.throttles[key] = t
+	}
+
+	if t.windowEnd.After(now) {
+		if ip != "" && len(t.ips) < maxDigestIPs && !contains(t.ips, ip) {
+			t.ips = append(t.ips, ip)
+		}
+		t.suppressed++
+		if t.firstSuppressed.IsZero() {
+			t.firstSuppressed = now
+		}
+		t.payload = copyBytes(notification.Payload)
+		return false, nil, nil
+	}
+
+	var digest *models.NotificationDigest
+	if t.suppressed > 0 {
+		digest = t.digest(key)
+	}
+	t.reset(now, now.Add(window))
+	return true, digest, nil
+}
+
+// ClaimDigests returns up to limit digests of windows that are over and starts
+// a new window of the same length for them.
+func (m *Memory) ClaimDigests(limit int) ([]models.NotificationDigest, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var keys []throttleKey
+	for key, t := range m.throttles {
+		if t.suppressed > 0 && !t.windowEnd.After(now) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return m.throttles[keys[i]].windowEnd.Before(m.throttles[keys[j]].windowEnd)
+	})
+	if len(keys) > limit {
+		keys = keys[:limit]
+	}
+
+	var digests []models.NotificationDigest
+	for _, key := range keys {
+		t := m.throttles[key]
+		digests = append(digests, *t.digest(key))
+		t.reset(now, now.Add(t.windowEnd.Sub(t.windowStart)))
+	}
+	return digests, nil
+}
+
+func (t *throttle) digest(key throttleKey) *models.NotificationDigest {
+	return &models.NotificationDigest{
+		UserID:     key.userID,
+		Event:      key.event,
+		Suppressed: t.suppressed,
+		IPs:        append([]string(nil), t.ips...),
+		Since:      t.firstSuppressed,
+		Payload:    copyBytes(t.payload),
+	}
+}
+
+func (t *throttle) reset(start time.Time, end time.Time) {
+	t.windowStart = start
+	t.windowEnd = end
+	t.suppressed = 0
+	t.ips = nil
+	t.firstSuppressed = time.Time{}
+	t.payload = nil
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
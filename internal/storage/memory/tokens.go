@@ -0,0 +1,275 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/nabishec/tokenapi/internal/storage"
+)
+
+// AddNewToken saves the refresh token together with the notifications about it.
+func (m *Memory) AddNewToken(ctx context.Context, token models.RefreshToken, notifications ...models.Notification) error {
+	const op = "internal.storage.memory.AddNewToken()"
+	err := done(ctx, op)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.addToken(token, notifications)
+}
+
+// RotateToken replaces the refresh token old with token, of concurrent rotations
+// of one token exactly one succeeds and the others get ErrTokenNotExists.
+func (m *Memory) RotateToken(ctx context.Context, old models.RefreshToken, token models.RefreshToken, notifications ...models.Notification) error {
+	const op = "internal.storage.memory.RotateToken()"
+	err := done(ctx, op)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	// the user is checked before the delete, so nothing changes on errors
+	if _, ok := m.users[token.UserID]; !ok {
+		return storage.ErrUserNotExists
+	}
+	deleted := m.deleteTokens(func(t models.RefreshToken) bool {
+		return t.UserID == old.UserID && t.JTI == old.JTI
+	})
+	if len(deleted) == 0 {
+		return storage.ErrTokenNotExists
+	}
+	return m.addToken(token, notifications)
+}
+
+func (m *Memory) addToken(token models.RefreshToken, notifications []models.Notification) error {
+	if _, ok := m.users[token.UserID]; !ok {
+		return storage.ErrUserNotExists
+	}
+
+	//delete expired sessions of the user
+	now := time.Now()
+	m.deleteTokens(func(t models.RefreshToken) bool {
+		return t.UserID == token.UserID && t.Exp.Before(now)
+	})
+
+	token.LastUsed = now
+	m.tokens = append(m.tokens, token)
+	m.enqueueNotifications(notifications)
+	return nil
+}
+
+// GetToken returns the refresh token of the user issued with the access token jti.
+func (m *Memory) GetToken(ctx context.Context, userGUID string, jti string) (*models.RefreshToken, error) {
+	const op = "internal.storage.memory.GetToken()"
+	err := done(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	return m.getToken(func(t models.RefreshToken) bool {
+		return t.UserID.String() == userGUID && t.JTI == jti
+	})
+}
+
+// GetTokenBySelector returns the refresh token with the selector.
+func (m *Memory) GetTokenBySelector(ctx context.Context, selector string) (*models.RefreshToken, error) {
+	const op = "internal.storage.memory.GetTokenBySelector()"
+	err := done(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	return m.getToken(func(t models.RefreshToken) bool {
+		return t.Selector != "" && t.Selector == selector
+	})
+}
+
+func (m *Memory) getToken(match func(models.RefreshToken) bool) (*models.RefreshToken, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, t := range m.tokens {
+		if match(t) {
+			return &t, nil
+		}
+	}
+	return nil, storage.ErrTokenNotExists
+}
+
+func (m *Memory) DeleteToken(ctx context.Context, userID uuid.UUID, jti string) error {
+	const op = "internal.storage.memory.DeleteToken()"
+	err := done(ctx, op)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.deleteTokens(func(t models.RefreshToken) bool {
+		return t.UserID == userID && t.JTI == jti
+	})
+	return nil
+}
+
+// deleteTokens deletes the matching tokens and returns their jti.
+func (m *Memory) deleteTokens(match func(models.RefreshToken) bool) []string {
+	var jtis []string
+	kept := m.tokens[:0]
+	for _, t := range m.tokens {
+		if match(t) {
+			jtis = append(jtis, t.JTI)
+			continue
+		}
+		kept = append(kept, t)
+	}
+	m.tokens = kept
+	return jtis
+}
+
+// GetSessions returns the active sessions of the user, the last used first.
+func (m *Memory) GetSessions(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	const op = "internal.storage.memory.GetSessions()"
+	err := done(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	sessions := []models.Session{}
+	for _, t := range m.tokens {
+		if t.UserID != userID || !t.Exp.After(now) {
+			continue
+		}
+		sessions = append(sessions, models.Session{
+			ID:        t.SessionID,
+			IP:        t.IP,
+			UserAgent: t.UserAgent,
+			DeviceID:  t.DeviceID,
+			CreatedAt: t.CreatedAt,
+			LastUsed:  t.LastUsed,
+			JTI:       t.JTI,
+		})
+	}
+	sort.SliceStable(sessions, func(i, j int) bool {
+		return sessions[i].LastUsed.After(sessions[j].LastUsed)
+	})
+	return sessions, nil
+}
+
+// RevokeSession deletes the session and denylists its access token until exp.
+func (m *Memory) RevokeSession(ctx context.Context, userID uuid.UUID, sessionID uuid.UUID, exp time.Time) error {
+	const op = "internal.storage.memory.RevokeSession()"
+	err := done(ctx, op)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jtis := m.deleteTokens(func(t models.RefreshToken) bool {
+		return t.UserID == userID && t.SessionID == sessionID
+	})
+	if len(jtis) == 0 {
+		return storage.ErrSessionNotExists
+	}
+	m.revokeTokens(jtis, exp)
+	return nil
+}
+
+// RevokeOtherSessions deletes all sessions of the user except currentSession
+// and denylists their access tokens until exp. It returns the number of revoked sessions.
+func (m *Memory) RevokeOtherSessions(ctx context.Context, userID uuid.UUID, currentSession uuid.UUID, exp time.Time) (int, error) {
+	const op = "internal.storage.memory.RevokeOtherSessions()"
+	err := done(ctx, op)
+	if err != nil {
+		return 0, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	jtis := m.deleteTokens(func(t models.RefreshToken) bool {
+		return t.UserID == userID && t.SessionID != currentSession
+	})
+	m.revokeTokens(jtis, exp)
+	return len(jtis), nil
+}
+
+// RevokeUserTokens invalidates every token of the user issued until now, see the database implementation.
+func (m *Memory) RevokeUserTokens(ctx context.Context, userID uuid.UUID, exp time.Time) (time.Time, error) {
+	const op = "internal.storage.memory.RevokeUserTokens()"
+	err := done(ctx, op)
+	if err != nil {
+		return time.Time{}, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	u, ok := m.users[userID]
+	if !ok {
+		return time.Time{}, storage.ErrUserNotExists
+	}
+	// tokens keep iat in seconds, so the time is rounded up to reject tokens of the current second too
+	u.revokedBefore = time.Now().Truncate(time.Second).Add(time.Second)
+
+	jtis := m.deleteTokens(func(t models.RefreshToken) bool {
+		return t.UserID == userID
+	})
+	m.revokeTokens(jtis, exp)
+	return u.revokedBefore, nil
+}
+
+func (m *Memory) revokeTokens(jtis []string, exp time.Time) {
+	now := time.Now()
+	for jti, revokedExp := range m.revoked {
+		if revokedExp.Before(now) {
+			delete(m.revoked, jti)
+		}
+	}
+	for _, jti := range jtis {
+		if exp.After(m.revoked[jti]) {
+			m.revoked[jti] = exp
+		}
+	}
+}
+
+func (m *Memory) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	const op = "internal.storage.memory.IsTokenRevoked()"
+	err := done(ctx, op)
+	if err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	exp, ok := m.revoked[jti]
+	return ok && exp.After(time.Now()), nil
+}
+
+// UseDPoPJTI remembers the jti of a DPoP proof until exp, it reports false for replayed proofs.
+func (m *Memory) UseDPoPJTI(ctx context.Context, jti string, exp time.Time) (bool, error) {
+	const op = "internal.storage.memory.UseDPoPJTI()"
+	err := done(ctx, op)
+	if err != nil {
+		return false, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	for used, usedExp := range m.dpop {
+		if usedExp.Before(now) {
+			delete(m.dpop, used)
+		}
+	}
+	if _, ok := m.dpop[jti]; ok {
+		return false, nil
+	}
+	m.dpop[jti] = exp
+	return true, nil
+}
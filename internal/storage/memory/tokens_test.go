@@ -0,0 +1,70 @@
+package memory
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/nabishec/tokenapi/internal/storage"
+)
+
+func TestRotateTokenConcurrently(t *testing.T) {
+	const rotations = 32
+	m := New()
+	userID := uuid.New()
+	m.AddUser(userID, "user@example.com")
+	old := models.RefreshToken{
+		Hash:      "old",
+		UserID:    userID,
+		JTI:       "old-jti",
+		Exp:       time.Now().Add(time.Hour),
+		SessionID: uuid.New(),
+	}
+	err := m.AddNewToken(context.Background(), old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := make(chan struct{})
+	errs := make(chan error, rotations)
+	var wg sync.WaitGroup
+	for i := 0; i < rotations; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token := old
+			token.Hash = "new-" + strconv.Itoa(i)
+			token.JTI = "jti-" + strconv.Itoa(i)
+			<-start
+			errs <- m.RotateToken(context.Background(), old, token)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			succeeded++
+		case storage.ErrTokenNotExists:
+		default:
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("%d rotations succeeded, want 1", succeeded)
+	}
+	sessions, err := m.GetSessions(context.Background(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("%d sessions left, want 1", len(sessions))
+	}
+}
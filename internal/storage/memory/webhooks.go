@@ -0,0 +1,240 @@
+package memory
+
+import (
+	"context"
+	"sort"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/nabishec/tokenapi/internal/storage"
+)
+
+func (m *Memory) AddWebhook(ctx context.Context, webhook models.Webhook) error {
+	const op = "internal.storage.memory.AddWebhook()"
+	err := done(ctx, op)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	webhook.Events = append([]string{}, webhook.Events...)
+	m.webhooks = append(m.webhooks, &webhook)
+	return nil
+}
+
+// GetWebhooks returns all webhooks without their secrets.
+func (m *Memory) GetWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	const op = "internal.storage.memory.GetWebhooks()"
+	err := done(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	webhooks := make([]models.Webhook, 0, len(m.webhooks))
+	for _, webhook := range m.webhooks {
+		result := *webhook
+		result.Secret = ""
+		result.Events = append([]string{}, webhook.Events...)
+		webhooks = append(webhooks, result)
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook changes the url, events and state of the webhook, an empty secret keeps the current one.
+func (m *Memory) UpdateWebhook(ctx context.Context, webhook models.Webhook) error {
+	const op = "internal.storage.memory.UpdateWebhook()"
+	err := done(ctx, op)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	saved := m.webhook(webhook.ID)
+	if saved == nil {
+		return storage.ErrWebhookNotExists
+	}
+	saved.URL = webhook.URL
+	saved.Events = append([]string{}, webhook.Events...)
+	saved.Active = webhook.Active
+	if webhook.Secret != "" {
+		saved.Secret = webhook.Secret
+	}
+	return nil
+}
+
+// DeleteWebhook deletes the webhook together with its deliveries.
+func (m *Memory) DeleteWebhook(ctx context.Context, webhookID uuid.UUID) error {
+	const op = "internal.storage.memory.DeleteWebhook()"
+	err := done(ctx, op)
+	if err != nil {
+		return err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.webhook(webhookID) == nil {
+		return storage.ErrWebhookNotExists
+	}
+	webhooks := m.webhooks[:0]
+	for _, webhook := range m.webhooks {
+		if webhook.ID != webhookID {
+			webhooks = append(webhooks, webhook)
+		}
+	}
+	m.webhooks = webhooks
+	deliveries := m.deliveries[:0]
+	for _, delivery := range m.deliveries {
+		if delivery.WebhookID != webhookID {
+			deliveries = append(deliveries, delivery)
+		}
+	}
+	m.deliveries = deliveries
+	return nil
+}
+
+// EnqueueWebhookEvent adds a delivery of the event for every active webhook subscribed to it.
+func (m *Memory) EnqueueWebhookEvent(event string, payload []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	added := 0
+	for _, webhook := range m.webhooks {
+		if !webhook.Active || (len(webhook.Events) > 0 && !contains(webhook.Events, event)) {
+			continue
+		}
+		m.deliveries = append(m.deliveries, &models.WebhookDelivery{
+			ID:          m.nextID(),
+			WebhookID:   webhook.ID,
+			Event:       event,
+			Payload:     copyBytes(payload),
+			Status:      models.NotificationPending,
+			NextAttempt: now,
+			CreatedAt:   now,
+		})
+		added++
+	}
+	return added, nil
+}
+
+// ClaimWebhookDeliveries returns up to limit due deliveries of active webhooks together with
+// the url and secret of the webhook and counts the attempt.
+func (m *Memory) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := time.Now()
+	var due []*models.WebhookDelivery
+	for _, delivery := range m.deliveries {
+		webhook := m.webhook(delivery.WebhookID)
+		if delivery.Status == models.NotificationPending && !delivery.NextAttempt.After(now) && webhook.Active {
+			due = append(due, delivery)
+		}
+	}
+	sort.SliceStable(due, func(i, j int) bool {
+		return due[i].NextAttempt.Before(due[j].NextAttempt)
+	})
+	if len(due) > limit {
+		due = due[:limit]
+	}
+
+	var deliveries []models.WebhookDelivery
+	for _, delivery := range due {
+		delivery.Attempts++
+		delivery.NextAttempt = now.Add(lease)
+		result := copyDelivery(delivery)
+		webhook := m.webhook(delivery.WebhookID)
+		result.URL = webhook.URL
+		result.Secret = webhook.Secret
+		deliveries = append(deliveries, result)
+	}
+	return deliveries, nil
+}
+
+func (m *Memory) CompleteWebhookDelivery(id int64, responseStatus int) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if delivery := m.delivery(id); delivery != nil {
+		now := time.Now()
+		delivery.Status = models.NotificationDelivered
+		delivery.DeliveredAt = &now
+		delivery.ResponseStatus = responseStatus
+		delivery.LastError = ""
+	}
+	return nil
+}
+
+// FailWebhookDelivery schedules the next attempt of the delivery, or moves it to the dead letters.
+func (m *Memory) FailWebhookDelivery(id int64, responseStatus int, nextAttempt time.Time, dead bool, lastError string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if delivery := m.delivery(id); delivery != nil {
+		delivery.Status = models.NotificationPending
+		if dead {
+			delivery.Status = models.NotificationDead
+		}
+		delivery.ResponseStatus = responseStatus
+		delivery.NextAttempt = nextAttempt
+		delivery.LastError = lastError
+	}
+	return nil
+}
+
+// GetWebhookDeliveries lists deliveries of the webhook with the status, newest first, all statuses if it is empty.
+func (m *Memory) GetWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, status string, limit int) ([]models.WebhookDelivery, error) {
+	const op = "internal.storage.memory.GetWebhookDeliveries()"
+	err := done(ctx, op)
+	if err != nil {
+		return nil, err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.webhook(webhookID) == nil {
+		return nil, storage.ErrWebhookNotExists
+	}
+	deliveries := []models.WebhookDelivery{}
+	// deliveries are appended in order of creation
+	for i := len(m.deliveries) - 1; i >= 0 && len(deliveries) < limit; i-- {
+		delivery := m.deliveries[i]
+		if delivery.WebhookID == webhookID && (status == "" || delivery.Status == status) {
+			deliveries = append(deliveries, copyDelivery(delivery))
+		}
+	}
+	return deliveries, nil
+}
+
+func (m *Memory) webhook(id uuid.UUID) *models.Webhook {
+	for _, webhook := range m.webhooks {
+		if webhook.ID == id {
+			return webhook
+		}
+	}
+	return nil
+}
+
+func (m *Memory) delivery(id int64) *models.WebhookDelivery {
+	for _, delivery := range m.deliveries {
+		if delivery.ID == id {
+			return delivery
+		}
+	}
+	return nil
+}
+
+func copyDelivery(delivery *models.WebhookDelivery) models.WebhookDelivery {
+	result := *delivery
+	result.Payload = copyBytes(delivery.Payload)
+	if delivery.DeliveredAt != nil {
+		deliveredAt := *delivery.DeliveredAt
+		result.DeliveredAt = &deliveredAt
+	}
+	return result
+}
@@ -0,0 +1,2 @@
+ALTER TABLE Refresh_tokens
+    ADD COLUMN selector TEXT UNIQUE;
@@ -0,0 +1 @@
+ALTER TABLE Users ADD COLUMN locale TEXT;
@@ -0,0 +1,14 @@
+CREATE TABLE Notification_outbox (
+    notification_id BIGSERIAL PRIMARY KEY,
+    event TEXT NOT NULL,
+    user_id UUID NOT NULL,
+    payload JSONB NOT NULL,
+    status TEXT NOT NULL DEFAULT 'pending',
+    attempts INT NOT NULL DEFAULT 0,
+    next_attempt TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    last_error TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    delivered_at TIMESTAMP WITH TIME ZONE
+);
+
+CREATE INDEX notification_outbox_pending_idx ON Notification_outbox (next_attempt) WHERE status = 'pending';
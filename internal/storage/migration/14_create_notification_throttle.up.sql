@@ -0,0 +1,11 @@
+CREATE TABLE Notification_throttle (
+    user_id UUID NOT NULL,
+    event TEXT NOT NULL,
+    window_start TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    window_end TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    suppressed INTEGER NOT NULL DEFAULT 0,
+    ips TEXT NOT NULL DEFAULT '',
+    first_suppressed TIMESTAMP WITH TIME ZONE,
+    payload JSONB,
+    PRIMARY KEY (user_id, event)
+);
@@ -0,0 +1,2 @@
+DROP TABLE IF EXISTS Webhook_deliveries;
+DROP TABLE IF EXISTS Webhooks;
@@ -0,0 +1 @@
+ALTER TABLE Users ADD COLUMN notification_preferences JSONB;
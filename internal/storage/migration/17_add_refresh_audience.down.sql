@@ -0,0 +1,2 @@
+ALTER TABLE Refresh_tokens
+    DROP COLUMN IF EXISTS audience;
@@ -0,0 +1,2 @@
+ALTER TABLE Refresh_tokens
+    ADD COLUMN audience TEXT NOT NULL DEFAULT '';
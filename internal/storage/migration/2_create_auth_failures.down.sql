@@ -0,0 +1 @@
+DROP TABLE IF EXISTS Auth_failures;
\ No newline at end of file
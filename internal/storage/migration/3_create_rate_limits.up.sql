@@ -0,0 +1,5 @@
+CREATE TABLE Rate_limits (
+    limit_key TEXT PRIMARY KEY,
+    tokens DOUBLE PRECISION NOT NULL,
+    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
@@ -0,0 +1 @@
+DROP TABLE IF EXISTS Login_history;
\ No newline at end of file
@@ -0,0 +1,13 @@
+CREATE TABLE Login_history (
+    login_id SERIAL PRIMARY KEY,
+    user_id UUID REFERENCES Users(user_id) ON DELETE CASCADE NOT NULL,
+    ip TEXT NOT NULL,
+    user_agent TEXT NOT NULL DEFAULT '',
+    latitude DOUBLE PRECISION,
+    longitude DOUBLE PRECISION,
+    country TEXT NOT NULL DEFAULT '',
+    city TEXT NOT NULL DEFAULT '',
+    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()
+);
+
+CREATE INDEX login_history_user_id_idx ON Login_history (user_id, created_at DESC);
@@ -0,0 +1,3 @@
+ALTER TABLE Refresh_tokens
+    DROP COLUMN IF EXISTS user_agent,
+    DROP COLUMN IF EXISTS device_id;
\ No newline at end of file
@@ -0,0 +1,3 @@
+ALTER TABLE Refresh_tokens
+    ADD COLUMN user_agent TEXT NOT NULL DEFAULT '',
+    ADD COLUMN device_id TEXT NOT NULL DEFAULT '';
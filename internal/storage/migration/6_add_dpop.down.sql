@@ -0,0 +1,3 @@
+DROP TABLE IF EXISTS Dpop_proofs;
+
+ALTER TABLE Refresh_tokens DROP COLUMN IF EXISTS jkt;
\ No newline at end of file
@@ -0,0 +1,6 @@
+ALTER TABLE Refresh_tokens ADD COLUMN jkt TEXT NOT NULL DEFAULT '';
+
+CREATE TABLE Dpop_proofs (
+    jti TEXT PRIMARY KEY,
+    exp TIMESTAMP WITH TIME ZONE NOT NULL
+);
@@ -0,0 +1 @@
+ALTER TABLE Refresh_tokens DROP COLUMN IF EXISTS x5t;
\ No newline at end of file
@@ -0,0 +1 @@
+ALTER TABLE Refresh_tokens ADD COLUMN x5t TEXT NOT NULL DEFAULT '';
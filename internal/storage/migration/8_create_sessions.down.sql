@@ -0,0 +1,13 @@
+DROP TABLE IF EXISTS Revoked_tokens;
+
+DROP INDEX IF EXISTS refresh_tokens_user_id_idx;
+
+-- only the last session of every user can be kept, sessions used at the same time are told apart by jti
+DELETE FROM Refresh_tokens t USING Refresh_tokens newer
+    WHERE t.user_id = newer.user_id AND (t.last_used, t.jti) < (newer.last_used, newer.jti);
+
+ALTER TABLE Refresh_tokens
+    DROP COLUMN IF EXISTS session_id,
+    DROP COLUMN IF EXISTS created_at,
+    DROP COLUMN IF EXISTS last_used,
+    ADD CONSTRAINT refresh_tokens_user_id_key UNIQUE (user_id);
@@ -0,0 +1,12 @@
+ALTER TABLE Refresh_tokens
+    DROP CONSTRAINT IF EXISTS refresh_tokens_user_id_key,
+    ADD COLUMN session_id UUID UNIQUE NOT NULL DEFAULT gen_random_uuid(),
+    ADD COLUMN created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW(),
+    ADD COLUMN last_used TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW();
+
+CREATE INDEX refresh_tokens_user_id_idx ON Refresh_tokens (user_id);
+
+CREATE TABLE Revoked_tokens (
+    jti TEXT PRIMARY KEY,
+    exp TIMESTAMP WITH TIME ZONE NOT NULL
+);
@@ -0,0 +1 @@
+ALTER TABLE Users DROP COLUMN IF EXISTS revoked_before;
\ No newline at end of file
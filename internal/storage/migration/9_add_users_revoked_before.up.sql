@@ -0,0 +1 @@
+ALTER TABLE Users ADD COLUMN revoked_before TIMESTAMP WITH TIME ZONE;
@@ -1,26 +1,39 @@
 package db
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"sync/atomic"
+	"time"
 
 	_ "github.com/jackc/pgx/v4/stdlib"
 	"github.com/jmoiron/sqlx"
+	"github.com/nabishec/tokenapi/internal/lib"
+	"github.com/nabishec/tokenapi/internal/storage"
 	"github.com/nabishec/tokenapi/internal/storage/postgresql/migrations"
 
 	"github.com/rs/zerolog/log"
 )
 
+var _ storage.Storage = (*Database)(nil)
+
 type Database struct {
 	dataSourceName string
 	DB             *sqlx.DB
+	// QueryTimeout limits queries of the methods taking a context, zero disables the limit.
+	QueryTimeout time.Duration
+	// healthy is the result of the last health probe.
+	healthy atomic.Bool
 }
 
 func NewDatabase() (*Database, error) {
 	log.Info().Msg("Connecting to database")
 
 	log.Debug().Msg("Init database")
-	var database Database
+	database := Database{
+		QueryTimeout: lib.DurationEnv("DB_QUERY_TIMEOUT", 3*time.Second),
+	}
 
 	config, err := newDSN()
 	if err != nil {
@@ -48,30 +61,97 @@ func (db *Database) connectDatabase(config string) error {
 
 	db.dataSourceName = config
 
+	// the container of the api can start before postgres is ready
+	attempts := lib.IntEnv("DB_CONNECT_ATTEMPTS", 10)
+	delay := lib.DurationEnv("DB_CONNECT_BASE_DELAY", time.Second)
+	maxDelay := lib.DurationEnv("DB_CONNECT_MAX_DELAY", 30*time.Second)
 	var connectError error
-	db.DB, connectError = sqlx.Connect("pgx", db.dataSourceName)
-	if connectError != nil {
-		return fmt.Errorf("%s:%w", op, connectError)
+	for attempt := 1; ; attempt++ {
+		db.DB, connectError = sqlx.Connect("pgx", db.dataSourceName)
+		if connectError == nil {
+			break
+		}
+		if attempt >= attempts {
+			return fmt.Errorf("%s:%w", op, connectError)
+		}
+		log.Warn().Err(connectError).Msgf("Failed to connect to database, attempt %d of %d, retrying in %s",
+			attempt, attempts, delay)
+		time.Sleep(delay)
+		delay = min(delay*2, maxDelay)
 	}
+	db.configurePool()
+	db.healthy.Store(true)
 
 	log.Debug().Msg("Connecting to database is successfully")
 	return nil
 }
 
+// configurePool applies the DB_MAX_OPEN_CONNS, DB_MAX_IDLE_CONNS, DB_CONN_MAX_LIFETIME
+// and DB_CONN_MAX_IDLE_TIME settings to the connection pool.
+func (db *Database) configurePool() {
+	maxOpen := lib.IntEnv("DB_MAX_OPEN_CONNS", 25)
+	maxIdle := lib.IntEnv("DB_MAX_IDLE_CONNS", 5)
+	maxLifetime := lib.DurationEnv("DB_CONN_MAX_LIFETIME", 30*time.Minute)
+	maxIdleTime := lib.DurationEnv("DB_CONN_MAX_IDLE_TIME", 5*time.Minute)
+
+	db.DB.SetMaxOpenConns(maxOpen)
+	db.DB.SetMaxIdleConns(maxIdle)
+	db.DB.SetConnMaxLifetime(maxLifetime)
+	db.DB.SetConnMaxIdleTime(maxIdleTime)
+	log.Debug().Msgf("Database pool: %d open, %d idle connections, lifetime %s, idle time %s",
+		maxOpen, maxIdle, maxLifetime, maxIdleTime)
+}
+
+// RunHealthCheck pings the database every interval and remembers the result for Healthy.
+// It never returns and is meant to be run in its own goroutine.
+func (db *Database) RunHealthCheck(interval time.Duration) {
+	const op = "internal.storage.postgresql.db.RunHealthCheck()"
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for range ticker.C {
+		err := db.PingDatabase()
+		healthy := err == nil
+		if db.healthy.Swap(healthy) == healthy {
+			continue
+		}
+		// only changes are logged, the probe runs too often for more
+		if healthy {
+			log.Info().Str("fn", op).Msg("Database is available again")
+		} else {
+			log.Error().Str("fn", op).AnErr(lib.ErrReader(err)).Msg("Database is unavailable")
+		}
+	}
+}
+
+// Healthy reports whether the last health probe reached the database.
+func (db *Database) Healthy() bool {
+	return db.healthy.Load()
+}
+
+// withTimeout limits the query to QueryTimeout, the context of the request can cancel it earlier.
+func (db *Database) withTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if db.QueryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, db.QueryTimeout)
+}
+
 func (db *Database) PingDatabase() error {
 	const op = "internal.storage.postgresql.db.PingDatabase()"
 
-	log.Info().Msg("Attempting to ping Database")
+	log.Debug().Msg("Attempting to ping Database")
 	if db.DB == nil {
 		return fmt.Errorf("%s:%s", op, "database isn`t established")
 	}
 
-	var pingError = db.DB.Ping()
+	ctx, cancel := db.withTimeout(context.Background())
+	defer cancel()
+	var pingError = db.DB.PingContext(ctx)
 	if pingError != nil {
 		return fmt.Errorf("%s:%w", op, pingError)
 	}
 
-	log.Info().Msg("Ping database is successful")
+	log.Debug().Msg("Ping database is successful")
 	return nil
 }
 
@@ -0,0 +1,30 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// UseDPoPJTI remembers the jti of a DPoP proof until exp, it reports false for replayed proofs.
+func (r *Database) UseDPoPJTI(ctx context.Context, jti string, exp time.Time) (bool, error) {
+	const op = "internal.storage.postgresql.db.UseDPoPJTI()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	queryDeleteExpired := "DELETE FROM Dpop_proofs WHERE exp < NOW()"
+	_, err := r.DB.ExecContext(ctx, queryDeleteExpired)
+	if err != nil {
+		return false, fmt.Errorf("%s:%w", op, err)
+	}
+
+	queryAddJTI := "INSERT INTO Dpop_proofs (jti, exp) VALUES ($1, $2) ON CONFLICT (jti) DO NOTHING"
+	res, err := r.DB.ExecContext(ctx, queryAddJTI, jti, exp)
+	if err != nil {
+		return false, fmt.Errorf("%s:%w", op, err)
+	}
+	added, err := res.RowsAffected()
+	if err != nil {
+		return false, fmt.Errorf("%s:%w", op, err)
+	}
+	return added == 1, nil
+}
@@ -0,0 +1,77 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// AddFailure increments the failure counter of key and returns its new value.
+// The counter starts over when the previous failure is older than window.
+func (r *Database) AddFailure(ctx context.Context, key string, window time.Duration) (int, error) {
+	const op = "internal.storage.postgresql.db.AddFailure()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	query := `INSERT INTO Auth_failures (failure_key, failures, last_failure)
+				VALUES ($1, 1, NOW())
+				ON CONFLICT (failure_key) DO UPDATE SET
+					failures = CASE
+						WHEN Auth_failures.last_failure < NOW() - make_interval(secs => $2) THEN 1
+						ELSE Auth_failures.failures + 1
+					END,
+					last_failure = NOW()
+				RETURNING failures`
+	var failures int
+	err := r.DB.QueryRowContext(ctx, query, key, window.Seconds()).Scan(&failures)
+	if err != nil {
+		return 0, fmt.Errorf("%s:%w", op, err)
+	}
+	log.Debug().Msgf("Failure number %d registered for %s", failures, key)
+	return failures, nil
+}
+
+func (r *Database) SetLockout(ctx context.Context, key string, until time.Time) error {
+	const op = "internal.storage.postgresql.db.SetLockout()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	query := "UPDATE Auth_failures SET locked_until = $2 WHERE failure_key = $1"
+	_, err := r.DB.ExecContext(ctx, query, key, until)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	return nil
+}
+
+// GetLockout returns the end of the lockout of key, or zero time if key isn't locked.
+func (r *Database) GetLockout(ctx context.Context, key string) (time.Time, error) {
+	const op = "internal.storage.postgresql.db.GetLockout()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	var lockedUntil sql.NullTime
+	query := "SELECT locked_until FROM Auth_failures WHERE failure_key = $1"
+	err := r.DB.QueryRowContext(ctx, query, key).Scan(&lockedUntil)
+	if err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("%s:%w", op, err)
+	}
+	return lockedUntil.Time, nil
+}
+
+func (r *Database) DeleteFailures(ctx context.Context, key string) error {
+	const op = "internal.storage.postgresql.db.DeleteFailures()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	query := "DELETE FROM Auth_failures WHERE failure_key = $1"
+	_, err := r.DB.ExecContext(ctx, query, key)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	log.Debug().Msgf("Failures of %s deleted", key)
+	return nil
+}
@@ -0,0 +1,49 @@
+package db
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+// GetLogins returns the last logins of the user, the newest first.
+func (r *Database) GetLogins(ctx context.Context, userID uuid.UUID, limit int) ([]models.Login, error) {
+	const op = "internal.storage.postgresql.db.GetLogins()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	logins := []models.Login{}
+	query := `SELECT user_id, ip, user_agent, latitude, longitude, country, city, created_at
+				FROM Login_history WHERE user_id = $1
+				ORDER BY created_at DESC LIMIT $2`
+	err := r.DB.SelectContext(ctx, &logins, query, userID, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	return logins, nil
+}
+
+// AddLogin saves the login and keeps only the last keep logins of the user.
+func (r *Database) AddLogin(ctx context.Context, login models.Login, keep int) error {
+	const op = "internal.storage.postgresql.db.AddLogin()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	queryAddLogin := `INSERT INTO Login_history (user_id, ip, user_agent, latitude, longitude, country, city, created_at)
+						VALUES (:user_id, :ip, :user_agent, :latitude, :longitude, :country, :city, :created_at)`
+	_, err := r.DB.NamedExecContext(ctx, queryAddLogin, login)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+
+	queryDeleteOld := `DELETE FROM Login_history WHERE user_id = $1 AND login_id NOT IN (
+							SELECT login_id FROM Login_history WHERE user_id = $1
+							ORDER BY created_at DESC LIMIT $2)`
+	_, err = r.DB.ExecContext(ctx, queryDeleteOld, login.UserID, keep)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	log.Debug().Msgf("Login of user - %s saved", login.UserID)
+	return nil
+}
@@ -0,0 +1,124 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/rs/zerolog/log"
+)
+
+func (r *Database) EnqueueNotifications(notifications ...models.Notification) error {
+	const op = "internal.storage.postgresql.db.EnqueueNotifications()"
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	defer tx.Rollback()
+
+	err = enqueueNotifications(context.Background(), tx, notifications)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	return nil
+}
+
+func enqueueNotifications(ctx context.Context, tx *sqlx.Tx, notifications []models.Notification) error {
+	query := "INSERT INTO Notification_outbox (event, user_id, payload) VALUES ($1, $2, $3)"
+	for _, notification := range notifications {
+		_, err := tx.ExecContext(ctx, query, notification.Event, notification.UserID, notification.Payload)
+		if err != nil {
+			return err
+		}
+	}
+	if len(notifications) > 0 {
+		log.Debug().Msgf("%d notifications added to outbox", len(notifications))
+	}
+	return nil
+}
+
+// ClaimNotifications returns up to limit pending notifications that are due and counts
+// the attempt. Claimed notifications aren't due again until lease passes, so an instance
+// crashing during delivery delays them instead of losing them.
+func (r *Database) ClaimNotifications(limit int, lease time.Duration) ([]models.Notification, error) {
+	const op = "internal.storage.postgresql.db.ClaimNotifications()"
+	var notifications []models.Notification
+	query := `UPDATE Notification_outbox SET
+					attempts = attempts + 1,
+					next_attempt = NOW() + make_interval(secs => $2)
+				WHERE notification_id IN (
+					SELECT notification_id FROM Notification_outbox
+					WHERE status = 'pending' AND next_attempt <= NOW()
+					ORDER BY next_attempt
+					LIMIT $1
+					FOR UPDATE SKIP LOCKED)
+				RETURNING notification_id, event, user_id, payload, status, attempts, next_attempt,
+					last_error, created_at, delivered_at`
+	err := r.DB.Select(&notifications, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	return notifications, nil
+}
+
+func (r *Database) CompleteNotification(id int64) error {
+	const op = "internal.storage.postgresql.db.CompleteNotification()"
+	query := `UPDATE Notification_outbox SET status = 'delivered', delivered_at = NOW(), last_error = ''
+				WHERE notification_id = $1`
+	_, err := r.DB.Exec(query, id)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	return nil
+}
+
+// FailNotification schedules the next attempt of the notification, or moves it to the dead letters.
+func (r *Database) FailNotification(id int64, nextAttempt time.Time, dead bool, lastError string) error {
+	const op = "internal.storage.postgresql.db.FailNotification()"
+	status := models.NotificationPending
+	if dead {
+		status = models.NotificationDead
+	}
+	query := "UPDATE Notification_outbox SET status = $2, next_attempt = $3, last_error = $4 WHERE notification_id = $1"
+	_, err := r.DB.Exec(query, id, status, nextAttempt, lastError)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	return nil
+}
+
+// GetNotifications lists notifications with the status, newest first, all statuses if it is empty.
+func (r *Database) GetNotifications(ctx context.Context, status string, limit int) ([]models.Notification, error) {
+	const op = "internal.storage.postgresql.db.GetNotifications()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	notifications := []models.Notification{}
+	query := `SELECT notification_id, event, user_id, payload, status, attempts, next_attempt,
+					last_error, created_at, delivered_at
+				FROM Notification_outbox
+				WHERE $1 = '' OR status = $1
+				ORDER BY created_at DESC
+				LIMIT $2`
+	err := r.DB.SelectContext(ctx, &notifications, query, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	return notifications, nil
+}
+
+// SuppressNotification marks the notification as throttled, it is reported in a digest instead.
+func (r *Database) SuppressNotification(id int64) error {
+	const op = "internal.storage.postgresql.db.SuppressNotification()"
+	query := "UPDATE Notification_outbox SET status = 'throttled', last_error = '' WHERE notification_id = $1"
+	_, err := r.DB.Exec(query, id)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	return nil
+}
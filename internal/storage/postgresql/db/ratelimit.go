@@ -0,0 +1,70 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"math"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// TakeRateToken implements a token bucket shared by all instances of the api.
+// The bucket row is locked for the refill, so concurrent requests are counted once each.
+func (r *Database) TakeRateToken(ctx context.Context, key string, rate float64, burst int) (float64, bool, error) {
+	const op = "internal.storage.postgresql.db.TakeRateToken()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s:%w", op, err)
+	}
+	defer tx.Rollback()
+
+	queryAddBucket := `INSERT INTO Rate_limits (limit_key, tokens, updated_at)
+						VALUES ($1, $2, NOW())
+						ON CONFLICT (limit_key) DO NOTHING`
+	_, err = tx.ExecContext(ctx, queryAddBucket, key, burst)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s:%w", op, err)
+	}
+
+	var tokens, elapsed float64
+	queryGetBucket := `SELECT tokens, EXTRACT(EPOCH FROM NOW() - updated_at)
+						FROM Rate_limits WHERE limit_key = $1 FOR UPDATE`
+	err = tx.QueryRowContext(ctx, queryGetBucket, key).Scan(&tokens, &elapsed)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s:%w", op, err)
+	}
+
+	tokens = math.Min(float64(burst), tokens+math.Max(elapsed, 0)*rate)
+	allowed := tokens >= 1
+	if allowed {
+		tokens--
+	}
+
+	queryUpdateBucket := "UPDATE Rate_limits SET tokens = $2, updated_at = NOW() WHERE limit_key = $1"
+	_, err = tx.ExecContext(ctx, queryUpdateBucket, key, tokens)
+	if err != nil {
+		return 0, false, fmt.Errorf("%s:%w", op, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, false, fmt.Errorf("%s:%w", op, err)
+	}
+	return tokens, allowed, nil
+}
+
+func (r *Database) DeleteIdleRateLimits(idle time.Duration) error {
+	const op = "internal.storage.postgresql.db.DeleteIdleRateLimits()"
+	query := "DELETE FROM Rate_limits WHERE updated_at < NOW() - make_interval(secs => $1)"
+	res, err := r.DB.Exec(query, idle.Seconds())
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	deleted, _ := res.RowsAffected()
+	log.Debug().Msgf("%d idle rate limits deleted", deleted)
+	return nil
+}
@@ -1,97 +1,190 @@
 package db
 
 import (
+	"context"
+	"database/sql"
 	"errors"
 	"fmt"
-	"time"
 
 	"github.com/google/uuid"
-	"github.com/jackc/pgx/v4"
+	"github.com/jmoiron/sqlx"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/nabishec/tokenapi/internal/storage"
 	"github.com/rs/zerolog/log"
 )
 
-var (
-	ErrUserNotExists  = errors.New("user's id doesn't exist")
-	ErrTokenNotExists = errors.New("token not found")
-)
-
-func (r *Database) AddNewToken(refHash string, userID uuid.UUID, userIP string, jti string, exp time.Time) error {
+// AddNewToken saves the refresh token together with the notifications about it in one transaction.
+func (r *Database) AddNewToken(ctx context.Context, token models.RefreshToken, notifications ...models.Notification) error {
 	const op = "internal.storage.postgresql.db.AddToken()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 
-	err := r.userExist(userID)
+	tx, err := r.DB.BeginTxx(ctx, nil)
 	if err != nil {
-		return err
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	defer tx.Rollback()
+
+	err = addToken(ctx, tx, token, notifications)
+	if err != nil {
+		if err == storage.ErrUserNotExists {
+			return err
+		}
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	return nil
+}
+
+// RotateToken replaces the refresh token old with token in one transaction.
+// The row of old is locked by the delete, so of concurrent rotations of one token
+// exactly one succeeds and the others get ErrTokenNotExists.
+func (r *Database) RotateToken(ctx context.Context, old models.RefreshToken, token models.RefreshToken, notifications ...models.Notification) error {
+	const op = "internal.storage.postgresql.db.RotateToken()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+
+	tx, err := r.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	defer tx.Rollback()
+
+	queryDeleteOld := "DELETE FROM Refresh_tokens WHERE user_id = $1 AND jti = $2"
+	res, err := tx.ExecContext(ctx, queryDeleteOld, old.UserID, old.JTI)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	if deleted == 0 {
+		return storage.ErrTokenNotExists
+	}
+
+	err = addToken(ctx, tx, token, notifications)
+	if err != nil {
+		if err == storage.ErrUserNotExists {
+			return err
+		}
+		return fmt.Errorf("%s:%w", op, err)
 	}
-	log.Debug().Msgf("User with id - %s exist", userID.String())
-	//delete old token if exist
-	queryDeleteOldRef := "DELETE FROM Refresh_tokens WHERE user_id = $1"
-	_, err = r.DB.Exec(queryDeleteOldRef, userID)
+	err = tx.Commit()
 	if err != nil {
 		return fmt.Errorf("%s:%w", op, err)
 	}
+	log.Debug().Msgf("Refresh token of user - %s rotated", token.UserID)
+	return nil
+}
 
-	queryAddToken := `INSERT INTO Refresh_tokens (user_id, ref_hash, ip, jti, exp)
-						VALUES ($1, $2, $3, $4, $5)
+// addToken deletes expired sessions of the user and saves the token with the notifications.
+func addToken(ctx context.Context, tx *sqlx.Tx, token models.RefreshToken, notifications []models.Notification) error {
+	err := userExist(ctx, tx, token.UserID)
+	if err != nil {
+		return err
+	}
+	log.Debug().Msgf("User with id - %s exist", token.UserID.String())
+
+	//delete expired sessions of the user
+	queryDeleteOldRef := "DELETE FROM Refresh_tokens WHERE user_id = $1 AND exp < NOW()"
+	_, err = tx.ExecContext(ctx, queryDeleteOldRef, token.UserID)
+	if err != nil {
+		return err
+	}
+
+	queryAddToken := `INSERT INTO Refresh_tokens (user_id, ref_hash, ip, jti, exp, user_agent, device_id, jkt, x5t,
+							session_id, created_at, last_used, selector, audience)
+						VALUES ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, NOW(), NULLIF($12, ''), $13)
 						RETURNING token_id`
 	var tokenID int64
-	err = r.DB.QueryRow(queryAddToken, userID, refHash, userIP, jti, exp).Scan(&tokenID)
+	err = tx.QueryRowContext(ctx, queryAddToken, token.UserID, token.Hash, token.IP, token.JTI, token.Exp,
+		token.UserAgent, token.DeviceID, token.JKT, token.X5T, token.SessionID, token.CreatedAt, token.Selector,
+		token.Audience).Scan(&tokenID)
 	if err != nil {
-		return fmt.Errorf("%s:%w", op, err)
+		return err
 	}
 	log.Debug().Msgf("Refresh token with id(%d)  added succesfull", tokenID)
 
-	return nil
+	return enqueueNotifications(ctx, tx, notifications)
+}
+
+// GetToken returns the refresh token of the user issued with the access token jti.
+// The token stays saved, it is consumed by RotateToken or DeleteToken.
+func (r *Database) GetToken(ctx context.Context, userGUID string, jti string) (*models.RefreshToken, error) {
+	return r.getToken(ctx, "user_id = $1 AND jti = $2", userGUID, jti)
 }
 
-func (r *Database) GetAndDeleteToken(userGUID string) (refHash string, userIP string, jti string, exp time.Time, err error) {
-	const op = "internal.storage.postgresql.db.RefreshToken()"
+// GetTokenBySelector returns the refresh token with the selector.
+func (r *Database) GetTokenBySelector(ctx context.Context, selector string) (*models.RefreshToken, error) {
+	return r.getToken(ctx, "selector = $1", selector)
+}
+
+func (r *Database) getToken(ctx context.Context, where string, args ...interface{}) (*models.RefreshToken, error) {
+	const op = "internal.storage.postgresql.db.getToken()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 	var tokenID int64
-	queryGetParam := "SELECT token_id, ref_hash, ip, jti, exp FROM Refresh_tokens WHERE user_id = $1"
-	err = r.DB.QueryRow(queryGetParam, userGUID).Scan(&tokenID, &refHash, &userIP, &jti, &exp)
+	var token models.RefreshToken
+	queryGetParam := `SELECT token_id, user_id, ref_hash, ip, jti, exp, user_agent, device_id, jkt, x5t,
+							session_id, created_at, last_used, COALESCE(selector, ''), audience
+						FROM Refresh_tokens WHERE ` + where
+	err := r.DB.QueryRowContext(ctx, queryGetParam, args...).Scan(&tokenID, &token.UserID, &token.Hash, &token.IP,
+		&token.JTI, &token.Exp, &token.UserAgent, &token.DeviceID, &token.JKT, &token.X5T,
+		&token.SessionID, &token.CreatedAt, &token.LastUsed, &token.Selector, &token.Audience)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			err = ErrTokenNotExists
-			return
+		if errors.Is(err, sql.ErrNoRows) {
+			return nil, storage.ErrTokenNotExists
 		}
-		err = fmt.Errorf("%s:%w", op, err)
-		return
+		return nil, fmt.Errorf("%s:%w", op, err)
 	}
 	log.Debug().Msgf("Found token with token_id - %d", tokenID)
-	// delete token after accessing it to ensure security
-	queryDeleteToken := "DELETE FROM Refresh_tokens WHERE token_id = $1"
-	_, err = r.DB.Exec(queryDeleteToken, tokenID)
-	if err != nil {
-		err = fmt.Errorf("%s:%w", op, err)
-		return
-	}
-	log.Debug().Msgf("Token with id - %d deleted successful", tokenID)
-	return
+	return &token, nil
 }
 
-func (r *Database) userExist(userID uuid.UUID) error {
-	const op = "internal.storage.postgresql.db.userExist()"
-	var userNumber int
-	query := "SELECT COUNT(*) FROM Users WHERE user_id = $1"
-
-	err := r.DB.QueryRow(query, userID).Scan(&userNumber)
+// DeleteToken deletes the refresh token of the user issued with the access token jti,
+// it is used to burn tokens presented in rejected requests.
+func (r *Database) DeleteToken(ctx context.Context, userID uuid.UUID, jti string) error {
+	const op = "internal.storage.postgresql.db.DeleteToken()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	queryDeleteToken := "DELETE FROM Refresh_tokens WHERE user_id = $1 AND jti = $2"
+	_, err := r.DB.ExecContext(ctx, queryDeleteToken, userID, jti)
 	if err != nil {
 		return fmt.Errorf("%s:%w", op, err)
 	}
-	if userNumber == 0 {
-		return ErrUserNotExists
+	log.Debug().Msgf("Token with jti - %s deleted successful", jti)
+	return nil
+}
+
+// userExist locks the user for the transaction, so the user can't be deleted before the token is saved.
+func userExist(ctx context.Context, tx *sqlx.Tx, userID uuid.UUID) error {
+	var id uuid.UUID
+	query := "SELECT user_id FROM Users WHERE user_id = $1 FOR SHARE"
+	err := tx.QueryRowContext(ctx, query, userID).Scan(&id)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return storage.ErrUserNotExists
+		}
+		return err
 	}
 	return nil
 }
 
-func (r *Database) GetMail(userID uuid.UUID) (string, error) {
+func (r *Database) GetMail(ctx context.Context, userID uuid.UUID) (string, error) {
 	const op = "internal.storage.postgresql.db.GetMail()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
 	var userMail string
 	query := "SELECT user_mail FROM Users WHERE user_id = $1"
 
-	err := r.DB.QueryRow(query, userID).Scan(&userMail)
+	err := r.DB.QueryRowContext(ctx, query, userID).Scan(&userMail)
 	if err != nil {
-		if err == pgx.ErrNoRows {
-			return "", ErrUserNotExists
+		if errors.Is(err, sql.ErrNoRows) {
+			return "", storage.ErrUserNotExists
 		}
 		return "", fmt.Errorf("%s:%w", op, err)
 	}
@@ -0,0 +1,130 @@
+//go:build integration
+
+package db
+
+import (
+	"context"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/nabishec/tokenapi/internal/storage"
+)
+
+// newTestDatabase connects to the database from the DB_* variables, the test is skipped
+// without DB_HOST. Run with: go test -tags integration ./internal/storage/postgresql/db/
+func newTestDatabase(t *testing.T) *Database {
+	t.Helper()
+	if os.Getenv("DB_HOST") == "" {
+		t.Skip("DB_HOST isn't set")
+	}
+	// migrations are read relative to the root of the repository
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = os.Chdir("../../../..")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.Chdir(wd) })
+
+	database, err := NewDatabase()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { database.CloseDatabase() })
+	return database
+}
+
+func TestRotateTokenConcurrently(t *testing.T) {
+	const rotations = 16
+	database := newTestDatabase(t)
+	ctx := context.Background()
+	userID := uuid.New()
+	_, err := database.DB.ExecContext(ctx, "INSERT INTO Users (user_id, user_mail) VALUES ($1, $2)",
+		userID, userID.String()+"@example.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		database.DB.Exec("DELETE FROM Refresh_tokens WHERE user_id = $1", userID)
+		database.DB.Exec("DELETE FROM Users WHERE user_id = $1", userID)
+	})
+
+	old := models.RefreshToken{
+		Hash:      "old-" + userID.String(),
+		UserID:    userID,
+		IP:        "203.0.113.7",
+		JTI:       "old-jti",
+		Exp:       time.Now().Add(time.Hour),
+		SessionID: uuid.New(),
+		CreatedAt: time.Now(),
+	}
+	err = database.AddNewToken(ctx, old)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := make(chan struct{})
+	errs := make(chan error, rotations)
+	var wg sync.WaitGroup
+	for i := 0; i < rotations; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			token := old
+			token.Hash = "new-" + strconv.Itoa(i) + "-" + userID.String()
+			token.JTI = "jti-" + strconv.Itoa(i)
+			<-start
+			errs <- database.RotateToken(ctx, old, token)
+		}(i)
+	}
+	close(start)
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch err {
+		case nil:
+			succeeded++
+		case storage.ErrTokenNotExists:
+		default:
+			t.Fatalf("unexpected error %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Fatalf("%d rotations succeeded, want 1", succeeded)
+	}
+	sessions, err := database.GetSessions(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("%d sessions left, want 1", len(sessions))
+	}
+}
+
+func TestMissingRows(t *testing.T) {
+	database := newTestDatabase(t)
+	ctx := context.Background()
+	userID := uuid.New()
+
+	_, err := database.GetMail(ctx, userID)
+	if err != storage.ErrUserNotExists {
+		t.Fatalf("GetMail() error = %v, want %v", err, storage.ErrUserNotExists)
+	}
+	_, err = database.GetTokenBySelector(ctx, userID.String())
+	if err != storage.ErrTokenNotExists {
+		t.Fatalf("GetTokenBySelector() error = %v, want %v", err, storage.ErrTokenNotExists)
+	}
+	lockedUntil, err := database.GetLockout(ctx, "user:"+userID.String())
+	if err != nil || !lockedUntil.IsZero() {
+		t.Fatalf("GetLockout() = %v, %v, want zero time", lockedUntil, err)
+	}
+}
@@ -0,0 +1,175 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/jmoiron/sqlx"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/nabishec/tokenapi/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+// GetSessions returns the active sessions of the user, the last used first.
+func (r *Database) GetSessions(ctx context.Context, userID uuid.UUID) ([]models.Session, error) {
+	const op = "internal.storage.postgresql.db.GetSessions()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	sessions := []models.Session{}
+	query := `SELECT session_id, ip, user_agent, device_id, created_at, last_used, jti
+				FROM Refresh_tokens WHERE user_id = $1 AND exp > NOW()
+				ORDER BY last_used DESC`
+	err := r.DB.SelectContext(ctx, &sessions, query, userID)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	return sessions, nil
+}
+
+// RevokeSession deletes the session and denylists its access token until exp.
+func (r *Database) RevokeSession(ctx context.Context, userID uuid.UUID, sessionID uuid.UUID, exp time.Time) error {
+	const op = "internal.storage.postgresql.db.RevokeSession()"
+	query := "DELETE FROM Refresh_tokens WHERE user_id = $1 AND session_id = $2 RETURNING jti"
+	revoked, err := r.revokeSessions(ctx, query, exp, userID, sessionID)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	if revoked == 0 {
+		return storage.ErrSessionNotExists
+	}
+	return nil
+}
+
+// RevokeOtherSessions deletes all sessions of the user except currentSession
+// and denylists their access tokens until exp. It returns the number of revoked sessions.
+func (r *Database) RevokeOtherSessions(ctx context.Context, userID uuid.UUID, currentSession uuid.UUID, exp time.Time) (int, error) {
+	const op = "internal.storage.postgresql.db.RevokeOtherSessions()"
+	query := "DELETE FROM Refresh_tokens WHERE user_id = $1 AND session_id <> $2 RETURNING jti"
+	revoked, err := r.revokeSessions(ctx, query, exp, userID, currentSession)
+	if err != nil {
+		return 0, fmt.Errorf("%s:%w", op, err)
+	}
+	return revoked, nil
+}
+
+func (r *Database) IsTokenRevoked(ctx context.Context, jti string) (bool, error) {
+	const op = "internal.storage.postgresql.db.IsTokenRevoked()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	var revoked bool
+	query := "SELECT EXISTS (SELECT 1 FROM Revoked_tokens WHERE jti = $1 AND exp > NOW())"
+	err := r.DB.QueryRowContext(ctx, query, jti).Scan(&revoked)
+	if err != nil {
+		return false, fmt.Errorf("%s:%w", op, err)
+	}
+	return revoked, nil
+}
+
+// revokeSessions runs the delete query returning jti of the deleted sessions
+// and denylists them in one transaction.
+func (r *Database) revokeSessions(ctx context.Context, deleteQuery string, exp time.Time, args ...interface{}) (int, error) {
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	tx, err := r.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var jtis []string
+	err = tx.SelectContext(ctx, &jtis, deleteQuery, args...)
+	if err != nil {
+		return 0, err
+	}
+	err = revokeTokens(ctx, tx, jtis, exp)
+	if err != nil {
+		return 0, err
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return 0, err
+	}
+	log.Debug().Msgf("%d sessions revoked", len(jtis))
+	return len(jtis), nil
+}
+
+func revokeTokens(ctx context.Context, tx *sqlx.Tx, jtis []string, exp time.Time) error {
+	queryDeleteExpired := "DELETE FROM Revoked_tokens WHERE exp < NOW()"
+	_, err := tx.ExecContext(ctx, queryDeleteExpired)
+	if err != nil {
+		return err
+	}
+	queryRevoke := `INSERT INTO Revoked_tokens (jti, exp) VALUES ($1, $2)
+					ON CONFLICT (jti) DO UPDATE SET exp = GREATEST(Revoked_tokens.exp, EXCLUDED.exp)`
+	for _, jti := range jtis {
+		_, err = tx.ExecContext(ctx, queryRevoke, jti, exp)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// RevokeUserTokens invalidates every token of the user issued until now: it sets revoked_before,
+// deletes all sessions and denylists their access tokens until exp. It returns the new revoked_before.
+func (r *Database) RevokeUserTokens(ctx context.Context, userID uuid.UUID, exp time.Time) (time.Time, error) {
+	const op = "internal.storage.postgresql.db.RevokeUserTokens()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	tx, err := r.DB.BeginTxx(ctx, nil)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s:%w", op, err)
+	}
+	defer tx.Rollback()
+
+	// tokens keep iat in seconds, so the time is rounded up to reject tokens of the current second too
+	var revokedBefore time.Time
+	queryRevoke := `UPDATE Users SET revoked_before = date_trunc('second', NOW()) + interval '1 second'
+					WHERE user_id = $1 RETURNING revoked_before`
+	err = tx.QueryRowContext(ctx, queryRevoke, userID).Scan(&revokedBefore)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return time.Time{}, storage.ErrUserNotExists
+		}
+		return time.Time{}, fmt.Errorf("%s:%w", op, err)
+	}
+
+	var jtis []string
+	queryDeleteSessions := "DELETE FROM Refresh_tokens WHERE user_id = $1 RETURNING jti"
+	err = tx.SelectContext(ctx, &jtis, queryDeleteSessions, userID)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s:%w", op, err)
+	}
+	err = revokeTokens(ctx, tx, jtis, exp)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s:%w", op, err)
+	}
+
+	err = tx.Commit()
+	if err != nil {
+		return time.Time{}, fmt.Errorf("%s:%w", op, err)
+	}
+	log.Debug().Msgf("Tokens of user - %s revoked, %d sessions deleted", userID, len(jtis))
+	return revokedBefore, nil
+}
+
+// GetRevokedBefore returns the time tokens of the user issued before are invalid, zero if never set.
+func (r *Database) GetRevokedBefore(ctx context.Context, userID uuid.UUID) (time.Time, error) {
+	const op = "internal.storage.postgresql.db.GetRevokedBefore()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	var revokedBefore sql.NullTime
+	query := "SELECT revoked_before FROM Users WHERE user_id = $1"
+	err := r.DB.QueryRowContext(ctx, query, userID).Scan(&revokedBefore)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return time.Time{}, storage.ErrUserNotExists
+		}
+		return time.Time{}, fmt.Errorf("%s:%w", op, err)
+	}
+	return revokedBefore.Time, nil
+}
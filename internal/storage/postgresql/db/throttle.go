@@ -0,0 +1,155 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/nabishec/tokenapi/internal/models"
+)
+
+// maxDigestIPs limits the IPs remembered for a digest, the count is kept anyway.
+const maxDigestIPs = 10
+
+// ThrottleNotification allows one notification of the user about the event per window.
+// Notifications within the window are counted for a digest and false is returned.
+// The first notification after the window carries the digest of it if it wasn't sent yet.
+func (r *Database) ThrottleNotification(notification models.Notification, ip string, window time.Duration) (bool, *models.NotificationDigest, error) {
+	const op = "internal.storage.postgresql.db.ThrottleNotification()"
+
+	tx, err := r.DB.Beginx()
+	if err != nil {
+		return false, nil, fmt.Errorf("%s:%w", op, err)
+	}
+	defer tx.Rollback()
+
+	queryAddThrottle := `INSERT INTO Notification_throttle (user_id, event, window_start, window_end)
+						VALUES ($1, $2, NOW(), NOW())
+						ON CONFLICT (user_id, event) DO NOTHING`
+	_, err = tx.Exec(queryAddThrottle, notification.UserID, notification.Event)
+	if err != nil {
+		return false, nil, fmt.Errorf("%s:%w", op, err)
+	}
+
+	var (
+		open       bool
+		suppressed int
+		ips        string
+		since      sql.NullTime
+		payload    []byte
+	)
+	queryGetThrottle := `SELECT window_end > NOW(), suppressed, ips, first_suppressed, payload
+						FROM Notification_throttle WHERE user_id = $1 AND event = $2 FOR UPDATE`
+	err = tx.QueryRow(queryGetThrottle, notification.UserID, notification.Event).
+		Scan(&open, &suppressed, &ips, &since, &payload)
+	if err != nil {
+		return false, nil, fmt.Errorf("%s:%w", op, err)
+	}
+
+	if open {
+		digestIPs := splitIPs(ips)
+		if ip != "" && len(digestIPs) < maxDigestIPs && !contains(digestIPs, ip) {
+			digestIPs = append(digestIPs, ip)
+		}
+		querySuppress := `UPDATE Notification_throttle SET suppressed = suppressed + 1, ips = $3,
+							first_suppressed = COALESCE(first_suppressed, NOW()), payload = $4
+						WHERE user_id = $1 AND event = $2`
+		_, err = tx.Exec(querySuppress, notification.UserID, notification.Event,
+			strings.Join(digestIPs, ","), notification.Payload)
+		if err != nil {
+			return false, nil, fmt.Errorf("%s:%w", op, err)
+		}
+		err = tx.Commit()
+		if err != nil {
+			return false, nil, fmt.Errorf("%s:%w", op, err)
+		}
+		return false, nil, nil
+	}
+
+	var digest *models.NotificationDigest
+	if suppressed > 0 {
+		digest = &models.NotificationDigest{
+			UserID:     notification.UserID,
+			Event:      notification.Event,
+			Suppressed: suppressed,
+			IPs:        splitIPs(ips),
+			Since:      since.Time,
+			Payload:    payload,
+		}
+	}
+	queryOpen := `UPDATE Notification_throttle SET window_start = NOW(), window_end = NOW() + make_interval(secs => $3),
+						suppressed = 0, ips = '', first_suppressed = NULL, payload = NULL
+					WHERE user_id = $1 AND event = $2`
+	_, err = tx.Exec(queryOpen, notification.UserID, notification.Event, window.Seconds())
+	if err != nil {
+		return false, nil, fmt.Errorf("%s:%w", op, err)
+	}
+	err = tx.Commit()
+	if err != nil {
+		return false, nil, fmt.Errorf("%s:%w", op, err)
+	}
+	return true, digest, nil
+}
+
+// ClaimDigests returns up to limit digests of windows that are over and starts
+// a new window of the same length for them, so digests are sent at most once per window.
+func (r *Database) ClaimDigests(limit int) ([]models.NotificationDigest, error) {
+	const op = "internal.storage.postgresql.db.ClaimDigests()"
+	query := `WITH due AS (
+					SELECT user_id, event, suppressed, ips, first_suppressed, payload
+					FROM Notification_throttle
+					WHERE suppressed > 0 AND window_end <= NOW()
+					ORDER BY window_end
+					LIMIT $1
+					FOR UPDATE SKIP LOCKED)
+				UPDATE Notification_throttle t SET
+					window_start = NOW(),
+					window_end = NOW() + (t.window_end - t.window_start),
+					suppressed = 0, ips = '', first_suppressed = NULL, payload = NULL
+				FROM due
+				WHERE t.user_id = due.user_id AND t.event = due.event
+				RETURNING due.user_id, due.event, due.suppressed, due.ips, due.first_suppressed, due.payload`
+	rows, err := r.DB.Query(query, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	defer rows.Close()
+
+	var digests []models.NotificationDigest
+	for rows.Next() {
+		var (
+			digest models.NotificationDigest
+			ips    string
+			since  sql.NullTime
+		)
+		err = rows.Scan(&digest.UserID, &digest.Event, &digest.Suppressed, &ips, &since, &digest.Payload)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%w", op, err)
+		}
+		digest.IPs = splitIPs(ips)
+		digest.Since = since.Time
+		digests = append(digests, digest)
+	}
+	err = rows.Err()
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	return digests, nil
+}
+
+func splitIPs(ips string) []string {
+	if ips == "" {
+		return nil
+	}
+	return strings.Split(ips, ",")
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/nabishec/tokenapi/internal/storage"
+)
+
+// GetTokenFormat returns the access token format of the user, empty if the user
+// has no format of their own or doesn't exist.
+func (r *Database) GetTokenFormat(ctx context.Context, userID uuid.UUID) (string, error) {
+	const op = "internal.storage.postgresql.db.GetTokenFormat()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	var format sql.NullString
+	query := "SELECT token_format FROM Users WHERE user_id = $1"
+	err := r.DB.QueryRowContext(ctx, query, userID).Scan(&format)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", nil
+		}
+		return "", fmt.Errorf("%s:%w", op, err)
+	}
+	return format.String, nil
+}
+
+// SetTokenFormat sets the access token format of the user, empty resets it to the default one.
+func (r *Database) SetTokenFormat(ctx context.Context, userID uuid.UUID, format string) error {
+	const op = "internal.storage.postgresql.db.SetTokenFormat()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	query := "UPDATE Users SET token_format = NULLIF($2, '') WHERE user_id = $1"
+	result, err := r.DB.ExecContext(ctx, query, userID, format)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	if updated == 0 {
+		return storage.ErrUserNotExists
+	}
+	return nil
+}
+
+// GetLocale returns the language notifications are sent to the user in, empty if it isn't set.
+func (r *Database) GetLocale(ctx context.Context, userID uuid.UUID) (string, error) {
+	const op = "internal.storage.postgresql.db.GetLocale()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	var locale sql.NullString
+	query := "SELECT locale FROM Users WHERE user_id = $1"
+	err := r.DB.QueryRowContext(ctx, query, userID).Scan(&locale)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return "", storage.ErrUserNotExists
+		}
+		return "", fmt.Errorf("%s:%w", op, err)
+	}
+	return locale.String, nil
+}
+
+// GetNotificationPreferences returns the notification preferences of the user, nil if they aren't set.
+func (r *Database) GetNotificationPreferences(ctx context.Context, userID uuid.UUID) (*models.NotificationPreferences, error) {
+	const op = "internal.storage.postgresql.db.GetNotificationPreferences()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	var preferences []byte
+	query := "SELECT notification_preferences FROM Users WHERE user_id = $1"
+	err := r.DB.QueryRowContext(ctx, query, userID).Scan(&preferences)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, storage.ErrUserNotExists
+		}
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	if preferences == nil {
+		return nil, nil
+	}
+	var result models.NotificationPreferences
+	err = json.Unmarshal(preferences, &result)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	return &result, nil
+}
+
+// SetNotificationPreferences saves the notification preferences of the user, nil resets them to the default.
+func (r *Database) SetNotificationPreferences(ctx context.Context, userID uuid.UUID, preferences *models.NotificationPreferences) error {
+	const op = "internal.storage.postgresql.db.SetNotificationPreferences()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	// nil interface is sent as NULL
+	var value any
+	if preferences != nil {
+		encoded, err := json.Marshal(preferences)
+		if err != nil {
+			return fmt.Errorf("%s:%w", op, err)
+		}
+		value = string(encoded)
+	}
+	query := "UPDATE Users SET notification_preferences = $2 WHERE user_id = $1"
+	result, err := r.DB.ExecContext(ctx, query, userID, value)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	if updated == 0 {
+		return storage.ErrUserNotExists
+	}
+	return nil
+}
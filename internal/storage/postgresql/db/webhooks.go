@@ -0,0 +1,206 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/models"
+	"github.com/nabishec/tokenapi/internal/storage"
+	"github.com/rs/zerolog/log"
+)
+
+type webhookRow struct {
+	ID        uuid.UUID `db:"webhook_id"`
+	URL       string    `db:"url"`
+	Events    string    `db:"events"`
+	Active    bool      `db:"active"`
+	CreatedAt time.Time `db:"created_at"`
+}
+
+func (r *Database) AddWebhook(ctx context.Context, webhook models.Webhook) error {
+	const op = "internal.storage.postgresql.db.AddWebhook()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	query := `INSERT INTO Webhooks (webhook_id, url, secret, events, active, created_at)
+				VALUES ($1, $2, $3, $4, $5, $6)`
+	_, err := r.DB.ExecContext(ctx, query, webhook.ID, webhook.URL, webhook.Secret,
+		strings.Join(webhook.Events, ","), webhook.Active, webhook.CreatedAt)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	log.Debug().Msgf("Webhook - %s added", webhook.ID)
+	return nil
+}
+
+// GetWebhooks returns all webhooks without their secrets.
+func (r *Database) GetWebhooks(ctx context.Context) ([]models.Webhook, error) {
+	const op = "internal.storage.postgresql.db.GetWebhooks()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	var rows []webhookRow
+	query := "SELECT webhook_id, url, events, active, created_at FROM Webhooks ORDER BY created_at"
+	err := r.DB.SelectContext(ctx, &rows, query)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	webhooks := make([]models.Webhook, 0, len(rows))
+	for _, row := range rows {
+		webhook := models.Webhook{
+			ID:        row.ID,
+			URL:       row.URL,
+			Events:    []string{},
+			Active:    row.Active,
+			CreatedAt: row.CreatedAt,
+		}
+		if row.Events != "" {
+			webhook.Events = strings.Split(row.Events, ",")
+		}
+		webhooks = append(webhooks, webhook)
+	}
+	return webhooks, nil
+}
+
+// UpdateWebhook changes the url, events and state of the webhook, an empty secret keeps the current one.
+func (r *Database) UpdateWebhook(ctx context.Context, webhook models.Webhook) error {
+	const op = "internal.storage.postgresql.db.UpdateWebhook()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	query := `UPDATE Webhooks SET url = $2, events = $3, active = $4, secret = COALESCE(NULLIF($5, ''), secret)
+				WHERE webhook_id = $1`
+	res, err := r.DB.ExecContext(ctx, query, webhook.ID, webhook.URL, strings.Join(webhook.Events, ","), webhook.Active, webhook.Secret)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	updated, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	if updated == 0 {
+		return storage.ErrWebhookNotExists
+	}
+	return nil
+}
+
+// DeleteWebhook deletes the webhook together with its deliveries.
+func (r *Database) DeleteWebhook(ctx context.Context, webhookID uuid.UUID) error {
+	const op = "internal.storage.postgresql.db.DeleteWebhook()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	res, err := r.DB.ExecContext(ctx, "DELETE FROM Webhooks WHERE webhook_id = $1", webhookID)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	deleted, err := res.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	if deleted == 0 {
+		return storage.ErrWebhookNotExists
+	}
+	return nil
+}
+
+// EnqueueWebhookEvent adds a delivery of the event for every active webhook subscribed to it.
+func (r *Database) EnqueueWebhookEvent(event string, payload []byte) (int, error) {
+	const op = "internal.storage.postgresql.db.EnqueueWebhookEvent()"
+	query := `INSERT INTO Webhook_deliveries (webhook_id, event, payload)
+				SELECT webhook_id, $1, $2 FROM Webhooks
+				WHERE active AND (events = '' OR $1 = ANY(string_to_array(events, ',')))`
+	res, err := r.DB.Exec(query, event, payload)
+	if err != nil {
+		return 0, fmt.Errorf("%s:%w", op, err)
+	}
+	added, err := res.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("%s:%w", op, err)
+	}
+	return int(added), nil
+}
+
+// ClaimWebhookDeliveries returns up to limit due deliveries of active webhooks together with
+// the url and secret of the webhook and counts the attempt. Claimed deliveries aren't due
+// again until lease passes.
+func (r *Database) ClaimWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error) {
+	const op = "internal.storage.postgresql.db.ClaimWebhookDeliveries()"
+	var deliveries []models.WebhookDelivery
+	query := `WITH claimed AS (
+					UPDATE Webhook_deliveries SET
+						attempts = attempts + 1,
+						next_attempt = NOW() + make_interval(secs => $2)
+					WHERE delivery_id IN (
+						SELECT d.delivery_id FROM Webhook_deliveries d
+						JOIN Webhooks w ON w.webhook_id = d.webhook_id
+						WHERE d.status = 'pending' AND d.next_attempt <= NOW() AND w.active
+						ORDER BY d.next_attempt
+						LIMIT $1
+						FOR UPDATE OF d SKIP LOCKED)
+					RETURNING *)
+				SELECT c.delivery_id, c.webhook_id, c.event, c.payload, c.status, c.attempts, c.next_attempt,
+					c.response_status, c.last_error, c.created_at, c.delivered_at, w.url, w.secret
+				FROM claimed c
+				JOIN Webhooks w ON w.webhook_id = c.webhook_id`
+	err := r.DB.Select(&deliveries, query, limit, lease.Seconds())
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	return deliveries, nil
+}
+
+func (r *Database) CompleteWebhookDelivery(id int64, responseStatus int) error {
+	const op = "internal.storage.postgresql.db.CompleteWebhookDelivery()"
+	query := `UPDATE Webhook_deliveries SET status = 'delivered', delivered_at = NOW(),
+					response_status = $2, last_error = ''
+				WHERE delivery_id = $1`
+	_, err := r.DB.Exec(query, id, responseStatus)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	return nil
+}
+
+// FailWebhookDelivery schedules the next attempt of the delivery, or moves it to the dead letters.
+func (r *Database) FailWebhookDelivery(id int64, responseStatus int, nextAttempt time.Time, dead bool, lastError string) error {
+	const op = "internal.storage.postgresql.db.FailWebhookDelivery()"
+	status := models.NotificationPending
+	if dead {
+		status = models.NotificationDead
+	}
+	query := `UPDATE Webhook_deliveries SET status = $2, response_status = $3, next_attempt = $4, last_error = $5
+				WHERE delivery_id = $1`
+	_, err := r.DB.Exec(query, id, status, responseStatus, nextAttempt, lastError)
+	if err != nil {
+		return fmt.Errorf("%s:%w", op, err)
+	}
+	return nil
+}
+
+// GetWebhookDeliveries lists deliveries of the webhook with the status, newest first, all statuses if it is empty.
+func (r *Database) GetWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, status string, limit int) ([]models.WebhookDelivery, error) {
+	const op = "internal.storage.postgresql.db.GetWebhookDeliveries()"
+	ctx, cancel := r.withTimeout(ctx)
+	defer cancel()
+	var exists bool
+	err := r.DB.GetContext(ctx, &exists, "SELECT EXISTS(SELECT 1 FROM Webhooks WHERE webhook_id = $1)", webhookID)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	if !exists {
+		return nil, storage.ErrWebhookNotExists
+	}
+
+	deliveries := []models.WebhookDelivery{}
+	query := `SELECT delivery_id, webhook_id, event, payload, status, attempts, next_attempt,
+					response_status, last_error, created_at, delivered_at
+				FROM Webhook_deliveries
+				WHERE webhook_id = $1 AND ($2 = '' OR status = $2)
+				ORDER BY created_at DESC
+				LIMIT $3`
+	err = r.DB.SelectContext(ctx, &deliveries, query, webhookID, status, limit)
+	if err != nil {
+		return nil, fmt.Errorf("%s:%w", op, err)
+	}
+	return deliveries, nil
+}
@@ -0,0 +1,84 @@
+// Package storage describes everything the api keeps between requests,
+// independent of the backend it is kept in.
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/nabishec/tokenapi/internal/models"
+)
+
+// Errors returned by every backend, handlers compare them to choose the response.
+var (
+	ErrUserNotExists    = errors.New("user's id doesn't exist")
+	ErrTokenNotExists   = errors.New("token not found")
+	ErrSessionNotExists = errors.New("session not found")
+	ErrWebhookNotExists = errors.New("webhook not found")
+)
+
+// Storage is the repository of the api, handlers depend on small parts of it.
+// Methods called while serving requests take the context of the request, so a
+// disconnected client or an expired timeout cancels their queries. Writes that must
+// outlive the request, like counting a failure, are given a context without cancel.
+// Methods without a context are only called by background workers and to queue
+// notifications and webhook events, which must not be lost with the request.
+type Storage interface {
+	// Healthy reports whether the last health probe reached the backend.
+	Healthy() bool
+
+	// users
+	GetMail(ctx context.Context, userID uuid.UUID) (string, error)
+	GetLocale(ctx context.Context, userID uuid.UUID) (string, error)
+	GetTokenFormat(ctx context.Context, userID uuid.UUID) (string, error)
+	SetTokenFormat(ctx context.Context, userID uuid.UUID, format string) error
+	GetNotificationPreferences(ctx context.Context, userID uuid.UUID) (*models.NotificationPreferences, error)
+	SetNotificationPreferences(ctx context.Context, userID uuid.UUID, preferences *models.NotificationPreferences) error
+
+	// refresh tokens and sessions
+	AddNewToken(ctx context.Context, token models.RefreshToken, notifications ...models.Notification) error
+	RotateToken(ctx context.Context, old models.RefreshToken, token models.RefreshToken, notifications ...models.Notification) error
+	GetToken(ctx context.Context, userGUID string, jti string) (*models.RefreshToken, error)
+	GetTokenBySelector(ctx context.Context, selector string) (*models.RefreshToken, error)
+	DeleteToken(ctx context.Context, userID uuid.UUID, jti string) error
+	GetSessions(ctx context.Context, userID uuid.UUID) ([]models.Session, error)
+	RevokeSession(ctx context.Context, userID uuid.UUID, sessionID uuid.UUID, exp time.Time) error
+	RevokeOtherSessions(ctx context.Context, userID uuid.UUID, currentSession uuid.UUID, exp time.Time) (int, error)
+	RevokeUserTokens(ctx context.Context, userID uuid.UUID, exp time.Time) (time.Time, error)
+	GetRevokedBefore(ctx context.Context, userID uuid.UUID) (time.Time, error)
+	IsTokenRevoked(ctx context.Context, jti string) (bool, error)
+	UseDPoPJTI(ctx context.Context, jti string, exp time.Time) (bool, error)
+
+	// lockout, rate limits and login history
+	AddFailure(ctx context.Context, key string, window time.Duration) (int, error)
+	SetLockout(ctx context.Context, key string, until time.Time) error
+	GetLockout(ctx context.Context, key string) (time.Time, error)
+	DeleteFailures(ctx context.Context, key string) error
+	TakeRateToken(ctx context.Context, key string, rate float64, burst int) (float64, bool, error)
+	DeleteIdleRateLimits(idle time.Duration) error
+	GetLogins(ctx context.Context, userID uuid.UUID, limit int) ([]models.Login, error)
+	AddLogin(ctx context.Context, login models.Login, keep int) error
+
+	// notification outbox
+	EnqueueNotifications(notifications ...models.Notification) error
+	ClaimNotifications(limit int, lease time.Duration) ([]models.Notification, error)
+	CompleteNotification(id int64) error
+	FailNotification(id int64, nextAttempt time.Time, dead bool, lastError string) error
+	SuppressNotification(id int64) error
+	GetNotifications(ctx context.Context, status string, limit int) ([]models.Notification, error)
+	ThrottleNotification(notification models.Notification, ip string, window time.Duration) (bool, *models.NotificationDigest, error)
+	ClaimDigests(limit int) ([]models.NotificationDigest, error)
+
+	// webhooks
+	AddWebhook(ctx context.Context, webhook models.Webhook) error
+	GetWebhooks(ctx context.Context) ([]models.Webhook, error)
+	UpdateWebhook(ctx context.Context, webhook models.Webhook) error
+	DeleteWebhook(ctx context.Context, webhookID uuid.UUID) error
+	EnqueueWebhookEvent(event string, payload []byte) (int, error)
+	ClaimWebhookDeliveries(limit int, lease time.Duration) ([]models.WebhookDelivery, error)
+	CompleteWebhookDelivery(id int64, responseStatus int) error
+	FailWebhookDelivery(id int64, responseStatus int, nextAttempt time.Time, dead bool, lastError string) error
+	GetWebhookDeliveries(ctx context.Context, webhookID uuid.UUID, status string, limit int) ([]models.WebhookDelivery, error)
+}